@@ -1,83 +1,252 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
 	"foodshop/internal/auth"
+	"foodshop/internal/challenge"
+	"foodshop/internal/config"
 	"foodshop/internal/database"
-	"foodshop/internal/handler"
+	"foodshop/internal/health"
+	"foodshop/internal/i18n"
+	"foodshop/internal/logging"
+	"foodshop/internal/metrics"
 	"foodshop/internal/middleware"
+	"foodshop/internal/passhash"
+	"foodshop/internal/ratelimit"
+	"foodshop/internal/systemd"
+	"foodshop/internal/tlsconfig"
+	"foodshop/internal/validator"
+	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
 var (
-	server         = "127.0.0.1"
-	port           = "8080"
-	db             *database.Sqlite
+	db             database.Backend
 	tokenBlacklist *auth.TokenBlacklist
 )
 
 func main() {
+	// "foodshop migrate ..." manages the schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := runMigrate(context.Background(), os.Args[2:], os.Stdout)
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Defaults < config file < environment < flags
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// Structured logging; the standard log package writes through it as well
+	logger, err := logging.New(os.Stderr, logging.Options{Level: cfg.Log.Level, Format: cfg.Log.Format})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	slog.SetDefault(logger)
+
+	// SIGINT (Ctrl+C) and SIGTERM (systemd, docker stop) start the graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// Restore the default behaviour, so a second signal terminates immediately
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := run(ctx, cfg); err != nil {
+		slog.Error("Server failed", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Server stopped")
+}
+
+// run starts the server and blocks until ctx is cancelled. All resources are
+// released by defers, so they are also closed on startup errors.
+func run(ctx context.Context, cfg *config.Config) error {
+	slog.Info("Effective configuration", "config", cfg.String())
+
+	// Tracing first, so the provider is flushed after everything else stopped
+	tracerProvider, closeTracing, err := newTracerProvider(cfg.Tracing)
+	if err != nil {
+		return err
+	}
+	defer closeTracing()
+
 	// Initialize database
-	var err error
-	repo, err := database.New("./data/foodshop.db")
+	db, err = openDatabase(cfg.Database)
 	if err != nil {
-		log.Fatalf("Failed to open database: %v", err)
+		return fmt.Errorf("failed to open database: %w", err)
 	}
-	defer repo.Close()
+	defer db.Close()
 
-	// Type assertion to access Sqlite-specific methods
-	var ok bool
-	db, ok = repo.(*database.Sqlite)
-	if !ok {
-		log.Fatal("Failed to cast repository to Sqlite")
+	// Schema migrations on startup, or separately with "foodshop migrate up"
+	if cfg.Database.AutoMigrate {
+		migrator, err := db.Migrator(ctx)
+		if err == nil {
+			err = migrator.Up(ctx)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to migrate schema: %w", err)
+		}
 	}
 
-	// Initialize schema
-	if err := db.InitSchema(); err != nil {
-		log.Fatalf("Failed to initialize schema: %v", err)
+	if cfg.Database.Postgres() {
+		slog.Info("Database initialized", "backend", "postgres", "dsn", cfg.Database.DSN.String())
+	} else {
+		slog.Info("Database initialized", "backend", "sqlite", "path", cfg.Database.Path)
 	}
 
-	log.Printf("Database initialized successfully")
+	// JWT secret (JWTSECRET or JWTSECRET_FILE), length checked by config validation
+	auth.SetJWTSecret(string(cfg.Auth.JWTSecret))
+	slog.Info("JWT authentication enabled")
+
+	// Argon2id cost for new hashes (bcrypt hashes are still verified); hashes
+	// with other parameters are upgraded on the next successful login
+	argon2 := cfg.Password.Argon2.Params()
+	db.SetPasswordHasher(passhash.NewMulti(passhash.NewArgon2id(argon2), passhash.NewBcrypt(passhash.DefaultBcryptCost)))
+	slog.Info("Password hashing", "algorithm", "argon2id",
+		"memory_kib", argon2.Memory, "iterations", argon2.Iterations, "parallelism", argon2.Parallelism)
 
-	// Initialize JWT secret (in production, load from environment variable)
-	jwtSecret := os.Getenv("JWTSECRET")
-	if jwtSecret == "" {
-		log.Fatal("Missing JWT Secret")
+	// Optional password pepper (PASSWORD_PEPPER or PASSWORD_PEPPER_FILE)
+	if cfg.Password.Pepper != "" {
+		pepper, err := passhash.ParsePepper(string(cfg.Password.Pepper))
+		if err != nil {
+			return fmt.Errorf("invalid password pepper: %w", err)
+		}
+		db.SetPepper(pepper)
+		slog.Info("Password pepper enabled", "version", pepper.Current())
 	}
-	auth.SetJWTSecret(jwtSecret)
-	log.Printf("JWT authentication enabled")
+
+	// Password history and optional maximum age
+	db.SetPasswordHistory(cfg.Password.History)
+	if cfg.Password.MaxAge > 0 {
+		db.SetMaxPasswordAge(cfg.Password.MaxAge)
+		slog.Info("Password expiry enabled", "max_age", cfg.Password.MaxAge.String())
+	}
+
+	// Password policy: composition rules (default), strength score or both
+	policy, err := validator.ParsePasswordPolicy(cfg.Password.Policy)
+	if err != nil {
+		return fmt.Errorf("invalid password policy: %w", err)
+	}
+	validator.SetPasswordPolicy(policy, cfg.Password.MinScore)
+
+	// Language for clients without a supported Accept-Language
+	if err := i18n.SetDefault(cfg.Language.Default); err != nil {
+		return err
+	}
+
+	// Optional offline breached-password check (HIBP corpus)
+	if path := cfg.BreachedPasswords.File; path != "" {
+		corpus, err := validator.OpenHIBPCorpus(path)
+		if err != nil {
+			return fmt.Errorf("failed to open breached password corpus: %w", err)
+		}
+		defer corpus.Close()
+
+		validator.SetBreachChecker(corpus, cfg.BreachedPasswords.Threshold)
+		slog.Info("Breached password check enabled", "threshold", cfg.BreachedPasswords.Threshold)
+	}
+
+	// Adaptive login throttling
+	db.SetLoginThrottle(database.LoginThrottle{
+		User:   database.ThrottlePolicy(cfg.LoginThrottle.User),
+		IP:     database.ThrottlePolicy(cfg.LoginThrottle.IP),
+		UserIP: database.ThrottlePolicy(cfg.LoginThrottle.UserIP),
+	})
 
 	// Initialize token blacklist
 	tokenBlacklist = auth.NewTokenBlacklist()
-	log.Printf("Token blacklist initialized")
+	defer tokenBlacklist.Close()
+	slog.Info("Token blacklist initialized")
 
-	// Create rate limiter: 10 requests per second, burst of 20
-	rateLimiter := middleware.NewRateLimiter(10, 20)
+	// Trusted reverse proxies (CIDRs); forwarding headers are ignored otherwise
+	ipResolver, err := middleware.NewIPResolver(cfg.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
 
-	// Create router/mux
-	mux := http.NewServeMux()
+	// Rate limit counters: in memory (default), "sqlite:<path>" or "redis[s]://..." to share them between instances
+	limiterStore, err := ratelimit.OpenStore(string(cfg.RateLimit.Store))
+	if err != nil {
+		return fmt.Errorf("invalid rate limit store: %w", err)
+	}
+	if closer, ok := limiterStore.(io.Closer); ok {
+		// Flushes shared counters (e.g. SQLite WAL checkpoint)
+		defer closer.Close()
+	}
+	limitPolicy := func(name string, p config.RateLimitPolicy, key middleware.KeyFunc) middleware.RateLimitPolicy {
+		return middleware.RateLimitPolicy{
+			Name: name, Requests: p.Requests, Period: p.Period, Burst: p.Burst, Key: key, Store: limiterStore,
+		}
+	}
+
+	// Global rate limiter per IP
+	rateLimiter := middleware.NewPolicyRateLimiter(limitPolicy("default", cfg.RateLimit.Default, middleware.KeyByIP))
 
-	// Public endpoints (no authentication required)
-	mux.HandleFunc("/", handler.IndexHandler())
-	mux.HandleFunc("/registration", handler.RegistrationHandler(db))
-	mux.HandleFunc("/login", handler.LoginHandler(db))
-	mux.HandleFunc("/refresh", handler.RefreshHandler(db))
+	// Stricter named policies for individual routes: credential checks and
+	// account creation per IP, the authenticated API per user (or API client)
+	rateLimits := middleware.NewRateLimitPolicies(
+		limitPolicy("login", cfg.RateLimit.Login, middleware.KeyByIP),
+		limitPolicy("registration", cfg.RateLimit.Registration, middleware.KeyByIP),
+		limitPolicy("authenticated", cfg.RateLimit.Authenticated,
+			middleware.KeyCombine(middleware.KeyByAPIClient, middleware.KeyByUser)),
+	)
+	defer rateLimits.Close()
+	defer rateLimiter.Close()
 
-	// Protected endpoints (authentication required)
-	protectedMux := http.NewServeMux()
-	protectedMux.HandleFunc("/logout", handler.LogoutHandler(tokenBlacklist))
-	protectedMux.HandleFunc("/profile", handler.ProfileHandler(db))
+	// Challenge (proof-of-work or CAPTCHA) after repeated failures of one client
+	verifier, err := newChallengeVerifier(cfg.Challenge)
+	if err != nil {
+		return err
+	}
+	challengeGuard := challenge.NewGuard(verifier, cfg.Challenge.Threshold, cfg.Challenge.Window)
+	defer challengeGuard.Close()
 
-	// Apply auth middleware to protected routes
-	authMiddleware := middleware.AuthMiddleware(tokenBlacklist)
-	mux.Handle("/logout", authMiddleware(protectedMux))
-	mux.Handle("/profile", authMiddleware(protectedMux))
+	// Readiness: dependencies and background workers; liveness: workers, plus a
+	// loopback request once the listener exists
+	workers := map[string]health.Runner{
+		"token_blacklist": tokenBlacklist,
+		"challenge_guard": challengeGuard,
+	}
+	if runner, ok := limiterStore.(health.Runner); ok {
+		workers["rate_limit_store"] = runner
+	}
+	if runner, ok := db.(health.Runner); ok {
+		workers["login_throttle_pruner"] = runner
+	}
+	checker, liveness := newCheckers(db, workers)
 
 	// Build middleware chain (order matters!)
-	var handler http.Handler = mux
+	// LogRoute adds the matched route to the log lines written by handlers
+	var handler http.Handler = middleware.LogRoute(newRouter(db, tokenBlacklist, checker, rateLimits, challengeGuard, cfg.Docs.Enabled))
+
+	// Name the request span after the matched route (needs the request the mux sees)
+	if tracerProvider != nil {
+		handler = routedSpan(handler)
+	}
 
 	// Recovery must be first to catch panics from other middleware
 	handler = middleware.Recovery(handler)
@@ -88,31 +257,275 @@ func main() {
 	// Security headers
 	handler = middleware.SecurityHeaders(handler)
 
-	// CORS - allow localhost for development
-	handler = middleware.CORS([]string{"http://localhost:3000", "http://localhost:8080"})(handler)
+	// CORS - allowed origins from config (localhost for development by default)
+	handler = middleware.CORS(cfg.CORS.AllowedOrigins)(handler)
 
 	// Rate limiting
 	handler = rateLimiter.Limit(handler)
 
-	// Request size limit (1MB)
-	handler = middleware.MaxBytesReader(1024 * 1024)(handler)
+	// Request size limit (default 1MB)
+	handler = middleware.MaxBytesReader(cfg.Server.MaxBodyBytes)(handler)
+
+	// Request timeout (default 30 seconds)
+	handler = middleware.Timeout(cfg.Server.RequestTimeout)(handler)
+
+	// Service identity from verified client certificates (mTLS)
+	if len(cfg.TLS.ClientIdentities) > 0 {
+		handler = middleware.ClientCertAuth(cfg.TLS.ClientIdentities)(handler)
+	}
 
-	// Request timeout (30 seconds)
-	handler = middleware.Timeout(30 * time.Second)(handler)
+	// Client IP resolution must run first, so rate limiting and logging see the real client
+	handler = ipResolver.Middleware(handler)
+
+	// Request ID for all log lines of the request (X-Request-ID)
+	handler = middleware.RequestID(handler)
+
+	// Message language from Accept-Language, before anything can answer with an error
+	handler = middleware.Language(handler)
+
+	// Request span around the whole chain, continuing an incoming traceparent
+	if tracerProvider != nil {
+		handler = traceRequests(handler, tracerProvider, cfg.Tracing.TrustParent)
+	}
 
 	// Configure server with security best practices
 	srv := &http.Server{
-		Addr:         fmt.Sprintf("%s:%s", server, port),
+		Addr:         cfg.Server.Addr(),
 		Handler:      handler,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+		ErrorLog:     slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+
+	ln, err := listen(srv.Addr)
+	if err != nil {
+		return err
+	}
+
+	// Native TLS with certificate hot-reload; SecurityHeaders adds HSTS on TLS connections
+	if cfg.TLS.Enabled() {
+		clientAuth, err := tlsconfig.ParseClientAuth(cfg.TLS.ClientAuth, cfg.TLS.ClientCAFile != "")
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		srv.TLSConfig, err = tlsconfig.NewServerConfig(tlsconfig.Options{
+			CertFile:       cfg.TLS.CertFile,
+			KeyFile:        cfg.TLS.KeyFile,
+			ClientCAFile:   cfg.TLS.ClientCAFile,
+			ClientAuth:     clientAuth,
+			ReloadInterval: cfg.TLS.ReloadInterval,
+		})
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		ln = tls.NewListener(ln, srv.TLSConfig)
+		slog.Info("TLS enabled", "client_auth", clientAuth.String())
+	}
+
+	// Liveness probes go through the real accept loop and handler chain
+	loopback := health.NewLoopback(ln)
+	ln = loopback
+	liveness.Add("http", loopback.Check("/healthz"))
+
+	slog.Info("Server starting", "addr", ln.Addr().String())
+
+	// Prometheus metrics on their own listener; closed after the main server
+	// has drained, so the shutdown itself can still be scraped
+	if cfg.Metrics.Listen != "" {
+		metrics.RegisterProcessMetrics(metrics.Default)
+		metrics.Default.NewGaugeFunc("auth_blacklisted_tokens", "Revoked tokens currently on the blacklist.",
+			func() float64 { return float64(tokenBlacklist.Len()) })
+
+		metricsSrv, err := serveMetrics(cfg.Metrics)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		defer metricsSrv.Close()
+	}
+
+	// systemd watchdog (WatchdogSec=): only ping while the process itself is
+	// alive, i.e. answers /healthz and its workers run. An outage of the
+	// database or Redis makes us not ready, but a restart would not fix it
+	watchdog, err := systemd.WatchdogInterval()
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	if watchdog > 0 {
+		slog.Info("systemd watchdog enabled", "timeout", watchdog.String())
+		go systemd.RunWatchdog(ctx, watchdog, func(ctx context.Context) error {
+			return liveness.Check(ctx).Err()
+		})
+	}
+
+	// On shutdown /readyz turns 503 at once; the listener closes after
+	// server.shutdown_delay, so load balancers stop routing to us first
+	serveCtx, cancelServe := context.WithCancel(context.Background())
+	defer cancelServe()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-serveCtx.Done():
+			return
+		}
+		checker.Shutdown()
+		if cfg.Server.ShutdownDelay > 0 {
+			slog.Info("Not ready, delaying listener close", "delay", cfg.Server.ShutdownDelay.String())
+			select {
+			case <-time.After(cfg.Server.ShutdownDelay):
+			case <-serveCtx.Done():
+			}
+		}
+		cancelServe()
+	}()
+
+	// Deferred cleanup runs after serve returns: workers stop, stores flush, DB closes
+	return serve(serveCtx, srv, ln, cfg.Server.ShutdownTimeout)
+}
+
+// newCheckers returns the readiness checks (dependencies and background
+// workers, served at /readyz) and the liveness checks (workers only) that
+// gate the systemd watchdog. run adds a loopback request to /healthz to the
+// liveness checks once the listener exists.
+func newCheckers(db database.Backend, workers map[string]health.Runner) (readiness, liveness *health.Checker) {
+	readiness = health.NewChecker(health.DefaultTimeout)
+	readiness.Add("database", db.DB().PingContext)
+	readiness.Add("signing_key", func(context.Context) error { return auth.CheckSigningKey() })
+	readiness.Add("schema", health.SchemaVersion(database.SchemaVersion, db.CurrentSchemaVersion))
+
+	liveness = health.NewChecker(health.DefaultTimeout)
+	for name, runner := range workers {
+		readiness.Add(name, health.Worker(runner))
+		liveness.Add(name, health.Worker(runner))
+	}
+	return readiness, liveness
+}
+
+// openDatabase connects to PostgreSQL if database.dsn is set and opens
+// the SQLite file at database.path otherwise.
+func openDatabase(cfg config.Database) (database.Backend, error) {
+	if cfg.Postgres() {
+		return database.NewPostgres(string(cfg.DSN))
+	}
+	return database.New(cfg.Path)
+}
+
+// listen returns the socket passed by systemd socket activation or, when
+// started without it, a new TCP listener on addr.
+func listen(addr string) (net.Listener, error) {
+	lns, err := systemd.Listeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(lns) == 0 {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen: %w", err)
+		}
+		return ln, nil
+	}
+
+	// The server has a single HTTP listener; extra sockets are a unit misconfiguration
+	for _, extra := range lns[1:] {
+		slog.Warn("Ignoring additional socket-activated listener", "addr", extra.Addr().String())
+		extra.Close()
+	}
+	slog.Info("Using socket-activated listener, server.host/server.port ignored", "addr", lns[0].Addr().String())
+	return lns[0], nil
+}
+
+// serveMetrics serves /metrics on cfg.Listen in the background.
+func serveMetrics(cfg config.Metrics) (*http.Server, error) {
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for metrics: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler(metrics.Default, string(cfg.Token)))
+	srv := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	go func() {
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server failed", "error", err)
+		}
+	}()
+
+	if host, _, _ := net.SplitHostPort(cfg.Listen); cfg.Token == "" && !isLoopback(host) {
+		slog.Warn("Metrics are reachable without a token; set metrics.token or bind to localhost", "addr", cfg.Listen)
+	}
+	slog.Info("Metrics available", "url", "http://"+ln.Addr().String()+"/metrics")
+	return srv, nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
 
-	log.Printf("Server starting on %s:%s with security middleware enabled", server, port)
-	log.Printf("Security features: Rate limiting, CORS, Security headers, Request size limits, Timeouts")
+// newChallengeVerifier creates the configured challenge: self-hosted
+// proof-of-work or a CAPTCHA provider.
+func newChallengeVerifier(cfg config.Challenge) (challenge.Verifier, error) {
+	if cfg.Provider == "pow" {
+		pow, err := challenge.NewProofOfWork([]byte(cfg.Key), cfg.Difficulty, cfg.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize proof-of-work: %w", err)
+		}
+		return pow, nil
+	}
+	return &challenge.SiteVerifyCaptcha{
+		Provider:  cfg.Provider,
+		VerifyURL: cfg.CaptchaVerifyURL(),
+		SiteKey:   cfg.SiteKey,
+		Secret:    string(cfg.Secret),
+	}, nil
+}
+
+// serve runs srv on ln until ctx is cancelled and then drains in-flight
+// requests for at most timeout. New connections are refused immediately.
+// Under systemd (Type=notify) it reports READY=1 and STOPPING=1.
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, timeout time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(ln)
+	}()
 
-	if err := srv.ListenAndServe(); err != nil {
-		log.Fatalf("Server failed: %v", err)
+	// The listener is bound, so connections are queued from here on
+	if _, err := systemd.Notify(systemd.Ready); err != nil {
+		slog.Warn("systemd notify failed", "error", err)
+	}
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutdown signal received, draining in-flight requests", "timeout", timeout.String())
+	if _, err := systemd.Notify(systemd.Stopping); err != nil {
+		slog.Warn("systemd notify failed", "error", err)
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// Deadline exceeded: cut the remaining connections
+		srv.Close()
+		return fmt.Errorf("graceful shutdown: %w", err)
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("server failed: %w", err)
 	}
+	return nil
 }
@@ -2,19 +2,45 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"foodshop/internal/auth"
+	"foodshop/internal/challenge"
 	"foodshop/internal/database"
 	"foodshop/internal/handler"
+	"foodshop/internal/health"
+	"foodshop/internal/middleware"
+	"foodshop/internal/openapi"
+	"foodshop/internal/problem"
+	"foodshop/internal/router"
+	"foodshop/internal/systemd"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
-// setupTestDB creates a temporary database for testing.
-func setupTestDB(t *testing.T) *database.Sqlite {
+// setupTestDB creates an empty in-memory store for testing.
+func setupTestDB(t *testing.T) *database.Memory {
+	return database.NewMemory()
+}
+
+// setupSqliteDB creates a temporary SQLite database, for tests that need
+// to modify rows directly.
+func setupSqliteDB(t *testing.T) *database.Sqlite {
 	tmpDir := t.TempDir()
 	dbPath := filepath.Join(tmpDir, "test.db")
 
@@ -28,6 +54,7 @@ func setupTestDB(t *testing.T) *database.Sqlite {
 		t.Fatal("Failed to cast repository to Sqlite")
 	}
 
+	t.Cleanup(func() { testDB.Close() })
 	if err := testDB.InitSchema(); err != nil {
 		t.Fatalf("Failed to initialize schema: %v", err)
 	}
@@ -37,7 +64,6 @@ func setupTestDB(t *testing.T) *database.Sqlite {
 
 func TestRegistrationHandler_Success(t *testing.T) {
 	db := setupTestDB(t)
-	defer db.Close()
 	payload := map[string]string{
 		"username":              "testuser",
 		"password":              "MyP@ssw0rd123",
@@ -68,7 +94,6 @@ func TestRegistrationHandler_Success(t *testing.T) {
 
 func TestRegistrationHandler_PasswordMismatch(t *testing.T) {
 	db := setupTestDB(t)
-	defer db.Close()
 	payload := map[string]string{
 		"username":              "testuser",
 		"password":              "MyP@ssw0rd123",
@@ -91,14 +116,13 @@ func TestRegistrationHandler_PasswordMismatch(t *testing.T) {
 		t.Fatalf("Failed to decode response: %v", err)
 	}
 
-	if response["message"] != "Passwords do not match" {
-		t.Errorf("Expected 'Passwords do not match', got: %v", response["message"])
+	if response["detail"] != "Passwords do not match" {
+		t.Errorf("Expected 'Passwords do not match', got: %v", response["detail"])
 	}
 }
 
 func TestRegistrationHandler_MissingPasswordVerification(t *testing.T) {
 	db := setupTestDB(t)
-	defer db.Close()
 	payload := map[string]string{
 		"username": "testuser",
 		"password": "MyP@ssw0rd123",
@@ -120,14 +144,13 @@ func TestRegistrationHandler_MissingPasswordVerification(t *testing.T) {
 		t.Fatalf("Failed to decode response: %v", err)
 	}
 
-	if response["message"] != "Password verification is required" {
-		t.Errorf("Expected 'Password verification is required', got: %v", response["message"])
+	if response["detail"] != "Password verification is required" {
+		t.Errorf("Expected 'Password verification is required', got: %v", response["detail"])
 	}
 }
 
 func TestRegistrationHandler_ShortPassword(t *testing.T) {
 	db := setupTestDB(t)
-	defer db.Close()
 	payload := map[string]string{
 		"username":              "testuser",
 		"password":              "Short1!",
@@ -150,14 +173,17 @@ func TestRegistrationHandler_ShortPassword(t *testing.T) {
 		t.Fatalf("Failed to decode response: %v", err)
 	}
 
-	if response["message"] != "Password must be at least 8 characters long" {
-		t.Errorf("Expected password length error, got: %v", response["message"])
+	if response["detail"] != "Password must be at least 8 characters long" {
+		t.Errorf("Expected password length error, got: %v", response["detail"])
+	}
+	errs, _ := response["errors"].([]interface{})
+	if len(errs) != 1 || errs[0].(map[string]interface{})["code"] != "too_short" {
+		t.Errorf("Expected too_short field error, got: %v", response["errors"])
 	}
 }
 
 func TestRegistrationHandler_DuplicateUsername(t *testing.T) {
 	db := setupTestDB(t)
-	defer db.Close()
 	payload := map[string]string{
 		"username":              "testuser",
 		"password":              "MyP@ssw0rd123",
@@ -191,8 +217,11 @@ func TestRegistrationHandler_DuplicateUsername(t *testing.T) {
 		t.Fatalf("Failed to decode response: %v", err)
 	}
 
-	if response["message"] != "Username already exists" {
-		t.Errorf("Expected 'Username already exists', got: %v", response["message"])
+	if response["detail"] != "Username already exists" {
+		t.Errorf("Expected 'Username already exists', got: %v", response["detail"])
+	}
+	if response["code"] != "user_exists" {
+		t.Errorf("Expected code user_exists, got: %v", response["code"])
 	}
 }
 
@@ -223,41 +252,639 @@ func TestLoginHandler_AccountLockout(t *testing.T) {
 		t.Errorf("Expected 200 OK, got %d", w.Code)
 	}
 
-	// 2. Mehrfache Fehlversuche
-	for i := 1; i <= database.MaxLoginAttempts; i++ {
+	// 2. Mehrfache Fehlversuche: ab der Schwelle wird exponentiell gebremst
+	threshold := database.DefaultLoginThrottle.UserIP.Threshold
+	for i := 1; i <= threshold; i++ {
 		badLogin := map[string]string{"username": "lockuser", "password": "Falsch123!"}
 		body, _ := json.Marshal(badLogin)
 		req := httptest.NewRequest("POST", "/login", bytes.NewBuffer(body))
 		req.Header.Set("Content-Type", "application/json")
 		w := httptest.NewRecorder()
 		loginHandler(w, req)
-		if i < database.MaxLoginAttempts && w.Code != http.StatusUnauthorized {
+		if i < threshold && w.Code != http.StatusUnauthorized {
 			t.Errorf("Attempt %d: Expected 401 Unauthorized, got %d", i, w.Code)
 		}
-		if i == database.MaxLoginAttempts && w.Code != http.StatusLocked {
-			t.Errorf("Attempt %d: Expected 423 Locked, got %d", i, w.Code)
+		if i == threshold && w.Code != http.StatusTooManyRequests {
+			t.Errorf("Attempt %d: Expected 429 Too Many Requests, got %d", i, w.Code)
 		}
 	}
 
-	// 3. Gesperrtes Konto liefert 423
-	badLogin := map[string]string{"username": "lockuser", "password": "LockP@ssw0rd!"}
-	body, _ = json.Marshal(badLogin)
+	// 3. Während der Wartezeit liefert auch das richtige Passwort 429 mit Retry-After
+	body, _ = json.Marshal(login)
 	req = httptest.NewRequest("POST", "/login", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
 	w = httptest.NewRecorder()
 	loginHandler(w, req)
-	if w.Code != http.StatusLocked {
-		t.Errorf("Locked account: Expected 423 Locked, got %d", w.Code)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Throttled: Expected 429 Too Many Requests, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Throttled: Expected Retry-After header")
 	}
 
-	// 4. Nach Ablauf der Sperrzeit ist Login wieder möglich
-	db.UnlockAccount("lockuser") // Simuliere Ablauf der Sperrzeit
+	// 4. Nach Ablauf der Wartezeit ist Login wieder möglich
+	db.ResetLoginFailures("lockuser", middleware.ClientIP(req)) // Simuliere Ablauf der Wartezeit
 	body, _ = json.Marshal(login)
 	req = httptest.NewRequest("POST", "/login", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
 	w = httptest.NewRecorder()
 	loginHandler(w, req)
 	if w.Code != http.StatusOK {
-		t.Errorf("After unlock: Expected 200 OK, got %d", w.Code)
+		t.Errorf("After backoff: Expected 200 OK, got %d", w.Code)
+	}
+}
+
+// Ein Angreifer mit wechselnden IPs wird über den Username-Zähler gebremst,
+// kann das Opfer aber höchstens für MaxUserDelay aussperren
+func TestLoginHandler_DistributedAttackCapped(t *testing.T) {
+	db := setupTestDB(t)
+	if _, err := db.CreateUser("victim", "VictimP@ssw0rd!", ""); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	loginHandler := handler.LoginHandler(db)
+
+	login := func(password, remoteAddr string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"username": "victim", "password": password})
+		req := httptest.NewRequest("POST", "/login", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		loginHandler(w, req)
+		return w
+	}
+
+	for i := range 3 * database.DefaultLoginThrottle.User.Threshold {
+		login("Falsch123!", fmt.Sprintf("198.51.100.%d:4000", i))
+	}
+
+	w := login("VictimP@ssw0rd!", "203.0.113.7:4000")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Fresh IP during attack: Expected 429, got %d", w.Code)
+	}
+	retry, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retry <= 0 || retry > int(database.MaxUserDelay/time.Second) {
+		t.Errorf("Retry-After = %q, want 1..%d", w.Header().Get("Retry-After"), int(database.MaxUserDelay/time.Second))
+	}
+}
+
+func TestLoginHandler_PasswordChangeRequired(t *testing.T) {
+	db := setupSqliteDB(t)
+	user, err := db.CreateUser("ageuser", "AgeP@ssw0rd!", "age@example.com")
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	// Passwort ist älter als das erlaubte Maximum
+	db.SetMaxPasswordAge(24 * time.Hour)
+	db.DB().Exec("UPDATE users SET password_changed_at = ? WHERE id = ?", time.Now().Add(-48*time.Hour), user.ID)
+
+	login := map[string]string{"username": "ageuser", "password": "AgeP@ssw0rd!"}
+	body, _ := json.Marshal(login)
+	req := httptest.NewRequest("POST", "/login", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	handler.LoginHandler(db)(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 Forbidden, got %d", w.Code)
+	}
+	var response map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&response)
+	if response["password_change_required"] != true {
+		t.Errorf("Expected password_change_required, got %v", response)
+	}
+	if _, ok := response["token"]; ok {
+		t.Error("No token must be issued for an expired password")
+	}
+
+	// Altes Passwort darf nicht wiederverwendet werden
+	change := map[string]string{
+		"username":                  "ageuser",
+		"password":                  "AgeP@ssw0rd!",
+		"new_password":              "AgeP@ssw0rd!",
+		"new_password_verification": "AgeP@ssw0rd!",
+	}
+	body, _ = json.Marshal(change)
+	req = httptest.NewRequest("POST", "/password", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	handler.ChangePasswordHandler(db)(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Reused password: Expected 400 Bad Request, got %d", w.Code)
+	}
+
+	change["new_password"] = "N3ues-P@sswort!"
+	change["new_password_verification"] = "N3ues-P@sswort!"
+	body, _ = json.Marshal(change)
+	req = httptest.NewRequest("POST", "/password", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	handler.ChangePasswordHandler(db)(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Change password: Expected 200 OK, got %d: %s", w.Code, w.Body.String())
+	}
+
+	login["password"] = "N3ues-P@sswort!"
+	body, _ = json.Marshal(login)
+	req = httptest.NewRequest("POST", "/login", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	handler.LoginHandler(db)(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("After change: Expected 200 OK, got %d", w.Code)
+	}
+}
+
+func TestLanguage_AcceptLanguageAndUserPreference(t *testing.T) {
+	db := setupTestDB(t)
+
+	send := func(h http.Handler, method, target, acceptLanguage string, payload interface{}, userID int64) (*httptest.ResponseRecorder, map[string]interface{}) {
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest(method, target, bytes.NewBuffer(body))
+		req.Header.Set("Accept-Language", acceptLanguage)
+		if userID != 0 {
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, userID))
+		}
+		w := httptest.NewRecorder()
+		middleware.Language(h).ServeHTTP(w, req)
+		var response map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&response)
+		return w, response
+	}
+
+	// Fehlermeldung in der Sprache aus Accept-Language
+	w, response := send(handler.RegistrationHandler(db), "POST", "/registration", "de-DE,de;q=0.9,en;q=0.8", map[string]string{
+		"username":              "languser",
+		"password":              "MyP@ssw0rd123",
+		"password_verification": "Other_P@ss123",
+	}, 0)
+	if w.Code != http.StatusBadRequest || response["detail"] != "Die Passwörter stimmen nicht überein" {
+		t.Errorf("Expected German mismatch error, got %d %v", w.Code, response)
+	}
+	if w.Header().Get("Content-Language") != "de" {
+		t.Errorf("Expected Content-Language de, got %q", w.Header().Get("Content-Language"))
+	}
+
+	user, err := db.CreateUser("languser", "MyP@ssw0rd123", "lang@example.com")
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	// Nicht unterstützte Sprache wird abgelehnt
+	w, response = send(handler.UpdateProfileHandler(db), "PUT", "/api/v1/profile", "en", map[string]string{"language": "fr"}, user.ID)
+	errs, _ := response["errors"].([]interface{})
+	if w.Code != http.StatusBadRequest || len(errs) != 1 || errs[0].(map[string]interface{})["code"] != "unsupported" {
+		t.Errorf("Expected unsupported language error, got %d %v", w.Code, response)
+	}
+
+	// Die Präferenz gilt sofort und überschreibt Accept-Language
+	w, response = send(handler.UpdateProfileHandler(db), "PUT", "/api/v1/profile", "en", map[string]string{"language": "de"}, user.ID)
+	if w.Code != http.StatusOK || response["message"] != "Benutzer erfolgreich aktualisiert" {
+		t.Errorf("Expected German update message, got %d %v", w.Code, response)
+	}
+	if updated, _ := response["user"].(map[string]interface{}); updated["language"] != "de" || updated["email"] != "lang@example.com" {
+		t.Errorf("Expected language de and unchanged email, got %v", updated)
+	}
+
+	_, response = send(handler.LoginHandler(db), "POST", "/login", "en", map[string]string{"username": "languser", "password": "MyP@ssw0rd123"}, 0)
+	if response["message"] != "Anmeldung erfolgreich" {
+		t.Errorf("Expected German login message, got %v", response["message"])
+	}
+
+	// Leere Sprache entfernt die Präferenz wieder
+	_, response = send(handler.UpdateProfileHandler(db), "PATCH", "/api/v1/profile", "en", map[string]string{"language": ""}, user.ID)
+	if response["message"] != "User updated successfully" {
+		t.Errorf("Expected English update message, got %v", response["message"])
+	}
+}
+
+// newTestRouter creates the API router with generous rate limits.
+func newTestRouter(t *testing.T, db database.Store, docs bool) *router.Router {
+	t.Helper()
+	blacklist := auth.NewTokenBlacklist()
+	t.Cleanup(func() { blacklist.Close() })
+	policy := func(name string) middleware.RateLimitPolicy {
+		return middleware.RateLimitPolicy{Name: name, Requests: 100, Period: time.Minute}
+	}
+	rateLimits := middleware.NewRateLimitPolicies(policy("login"), policy("registration"), policy("authenticated"))
+	t.Cleanup(func() { rateLimits.Close() })
+	pow, err := challenge.NewProofOfWork(nil, 8, time.Minute)
+	if err != nil {
+		t.Fatalf("NewProofOfWork() failed: %v", err)
+	}
+	guard := challenge.NewGuard(pow, 5, time.Minute)
+	t.Cleanup(func() { guard.Close() })
+	return newRouter(db, blacklist, health.NewChecker(time.Second), rateLimits, guard, docs)
+}
+
+func TestRouter_VersionedRoutesAndAliases(t *testing.T) {
+	db := setupTestDB(t)
+	r := newTestRouter(t, db, false)
+
+	registration := `{"username":"routeuser","password":"MyP@ssw0rd123","password_verification":"MyP@ssw0rd123"}`
+	tests := []struct {
+		name, method, target, body string
+		status                     int
+		allow, successor           string
+	}{
+		{"Versioned route", "POST", "/api/v1/registration", registration, http.StatusCreated, "", ""},
+		{"Legacy alias", "POST", "/registration", strings.Replace(registration, "routeuser", "legacyuser", 1), http.StatusCreated, "", "/api/v1/registration"},
+		{"Alias keeps authentication", "POST", "/profile", "", http.StatusUnauthorized, "", "/api/v1/profile"},
+		{"Wrong method", "GET", "/api/v1/login", "", http.StatusMethodNotAllowed, "POST", ""},
+		{"Wrong method on profile", "DELETE", "/api/v1/profile", "", http.StatusMethodNotAllowed, "GET, HEAD, PATCH, PUT", ""},
+		{"Unknown path", "GET", "/api/v2/login", "", http.StatusNotFound, "", ""},
+		{"Health without prefix", "GET", "/healthz", "", http.StatusOK, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("%s %s: got %d, want %d: %s", tt.method, tt.target, w.Code, tt.status, w.Body)
+			}
+			if got := w.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+			if tt.status >= 400 && w.Header().Get("Content-Type") != problem.ContentType {
+				t.Errorf("Content-Type = %q, want problem+json", w.Header().Get("Content-Type"))
+			}
+			deprecated := w.Header().Get("Deprecation") != ""
+			if deprecated != (tt.successor != "") {
+				t.Errorf("Deprecation = %q, want it only on aliases", w.Header().Get("Deprecation"))
+			}
+			if tt.successor != "" && !strings.Contains(w.Header().Get("Link"), "<"+tt.successor+">") {
+				t.Errorf("Link = %q, want successor %s", w.Header().Get("Link"), tt.successor)
+			}
+		})
+	}
+}
+
+// TestOpenAPI_CoversRoutes fails when a route is missing from
+// internal/openapi/openapi.json or the document lists a route that does
+// not exist.
+func TestOpenAPI_CoversRoutes(t *testing.T) {
+	db := setupTestDB(t)
+	doc, err := openapi.Parse()
+	if err != nil {
+		t.Fatalf("openapi.Parse() failed: %v", err)
+	}
+
+	documented := make(map[string]openapi.Endpoint)
+	for _, e := range doc.Endpoints() {
+		documented[e.Method+" "+e.Path] = e
+	}
+	for _, route := range newTestRouter(t, db, true).Routes() {
+		// "/{$}" matches only "/" itself
+		key := route.Method + " " + strings.TrimSuffix(route.Path, "{$}")
+		e, ok := documented[key]
+		if !ok {
+			t.Errorf("Route %s is missing from openapi.json", route.Pattern())
+			continue
+		}
+		delete(documented, key)
+		if e.Deprecated != route.Deprecated() {
+			t.Errorf("%s: deprecated = %v in openapi.json, route deprecated = %v", key, e.Deprecated, route.Deprecated())
+		}
+	}
+	for key := range documented {
+		t.Errorf("openapi.json documents %s, but no such route is registered", key)
+	}
+}
+
+func TestOpenAPI_Served(t *testing.T) {
+	db := setupTestDB(t)
+
+	tests := []struct {
+		name, target string
+		docs         bool
+		status       int
+		contentType  string
+	}{
+		{"Document", "/openapi.json", false, http.StatusOK, "application/json"},
+		{"Docs page", "/docs", true, http.StatusOK, "text/html; charset=utf-8"},
+		{"Docs disabled", "/docs", false, http.StatusNotFound, problem.ContentType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			newTestRouter(t, db, tt.docs).ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+			if w.Code != tt.status || w.Header().Get("Content-Type") != tt.contentType {
+				t.Errorf("GET %s: got %d %q, want %d %q", tt.target, w.Code, w.Header().Get("Content-Type"), tt.status, tt.contentType)
+			}
+		})
+	}
+}
+
+func TestRunMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "migrate.db")
+	ctx := context.Background()
+
+	tests := []struct {
+		args    []string
+		want    []string
+		wantErr string
+	}{
+		{[]string{"status"}, []string{"initial", "pending", "Schema version 0 (latest 2)"}, ""},
+		{[]string{"up"}, []string{"Schema version 2"}, ""},
+		{[]string{"down"}, []string{"Schema version 1"}, ""},
+		{[]string{"status"}, []string{"initial        applied", "user_language  pending"}, ""},
+		{[]string{"to", "0"}, []string{"Schema version 0"}, ""},
+		{[]string{"to", "9"}, nil, "unknown migration version"},
+		{[]string{"to", "x"}, nil, "invalid version"},
+		{[]string{"sideways"}, nil, "usage"},
+		{nil, nil, "usage"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		err := runMigrate(ctx, append([]string{"-database.path", path}, tt.args...), &out)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("migrate %v: error = %v, want %q", tt.args, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("migrate %v failed: %v", tt.args, err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("migrate %v: output does not contain %q:\n%s", tt.args, want, out.String())
+			}
+		}
+	}
+}
+
+func TestServe_GracefulShutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- serve(ctx, srv, ln, 5*time.Second) }()
+
+	// Request in flight when the shutdown starts must still complete
+	respErr := make(chan error, 1)
+	var body []byte
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err == nil {
+			body, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		respErr <- err
+	}()
+
+	<-started
+	cancel()
+
+	if err := <-respErr; err != nil {
+		t.Fatalf("In-flight request failed: %v", err)
+	}
+	if string(body) != "done" {
+		t.Errorf("Expected body 'done', got %q", body)
+	}
+	if err := <-serveErr; err != nil {
+		t.Errorf("serve() failed: %v", err)
+	}
+
+	// Listener is closed after shutdown
+	if _, err := http.Get("http://" + ln.Addr().String()); err == nil {
+		t.Error("Expected connection error after shutdown")
+	}
+}
+
+func TestServe_ShutdownDeadline(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- serve(ctx, srv, ln, 50*time.Millisecond) }()
+
+	go http.Get("http://" + ln.Addr().String())
+	<-started
+	cancel()
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected deadline exceeded, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve() did not return after the shutdown deadline")
+	}
+}
+
+func TestServe_SystemdNotify(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen on notify socket: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	srv := &http.Server{Handler: http.NotFoundHandler()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- serve(ctx, srv, ln, time.Second) }()
+
+	read := func() string {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 256)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("No notification received: %v", err)
+		}
+		return string(buf[:n])
+	}
+
+	if got := read(); got != "READY=1" {
+		t.Errorf("First notification = %q, want READY=1", got)
+	}
+	cancel()
+	if got := read(); got != "STOPPING=1" {
+		t.Errorf("Notification on shutdown = %q, want STOPPING=1", got)
+	}
+	if err := <-serveErr; err != nil {
+		t.Errorf("serve() failed: %v", err)
+	}
+}
+
+type stubRunner bool
+
+func (r stubRunner) Running() bool { return bool(r) }
+
+// Der Watchdog hängt nur an den eigenen Workern: eine ausgefallene Datenbank
+// macht die Instanz nicht ready, aber systemd soll sie nicht neu starten
+func TestNewCheckers_WatchdogIgnoresDependencies(t *testing.T) {
+	db := setupSqliteDB(t)
+	db.Close()
+	ctx := context.Background()
+
+	readiness, liveness := newCheckers(db, map[string]health.Runner{"worker": stubRunner(true)})
+	if report := readiness.Check(ctx); report.Ready() || report.Checks["database"].Status != "fail" {
+		t.Errorf("Readiness with closed database = %+v, want database failed", report)
+	}
+	if err := liveness.Check(ctx).Err(); err != nil {
+		t.Errorf("Liveness with closed database failed: %v", err)
+	}
+
+	_, liveness = newCheckers(db, map[string]health.Runner{"worker": stubRunner(false)})
+	if err := liveness.Check(ctx).Err(); err == nil {
+		t.Error("Liveness with stopped worker succeeded, want error")
+	}
+}
+
+// Ein Server, dessen Handler hängen, darf den Watchdog nicht weiter füttern,
+// auch wenn alle Hintergrund-Worker laufen
+func TestWatchdog_StopsWhenServerStuck(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen on notify socket: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	loopback := health.NewLoopback(ln)
+
+	var mu sync.Mutex
+	stuck := false
+	blocked := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hang := stuck
+		mu.Unlock()
+		if hang {
+			select {
+			case blocked <- struct{}{}:
+			default:
+			}
+			<-release
+		}
+		health.LivenessHandler()(w, r)
+	})}
+	go srv.Serve(loopback)
+	defer srv.Close()
+	defer close(release)
+
+	_, liveness := newCheckers(setupSqliteDB(t), map[string]health.Runner{"worker": stubRunner(true)})
+	liveness.Add("http", loopback.Check("/healthz"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go systemd.RunWatchdog(ctx, 100*time.Millisecond, func(ctx context.Context) error {
+		return liveness.Check(ctx).Err()
+	})
+
+	read := func(timeout time.Duration) (string, error) {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		buf := make([]byte, 256)
+		n, err := conn.Read(buf)
+		return string(buf[:n]), err
+	}
+
+	if got, err := read(5 * time.Second); err != nil || got != systemd.Watchdog {
+		t.Fatalf("Healthy server: notification = %q, %v, want %q", got, err, systemd.Watchdog)
+	}
+
+	mu.Lock()
+	stuck = true
+	mu.Unlock()
+	select {
+	case <-blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watchdog check never reached the handler")
+	}
+
+	// Pings sent before the handler hung may still be queued
+	for {
+		if _, err := read(10 * time.Millisecond); err != nil {
+			break
+		}
+	}
+	if got, err := read(500 * time.Millisecond); err == nil {
+		t.Errorf("Stuck server: received %q, want no watchdog ping", got)
+	}
+}
+
+// TestTraceRequests checks the server span: named after the route and a
+// child of an incoming traceparent only if trust_parent is set.
+func TestTraceRequests(t *testing.T) {
+	const (
+		traceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentID    = "00f067aa0ba902b7"
+		traceparent = "00-" + traceID + "-" + parentID + "-01"
+	)
+
+	for _, trust := range []bool{true, false} {
+		t.Run(fmt.Sprintf("trust_parent=%v", trust), func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+			defer provider.Shutdown(context.Background())
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /api/v1/login", func(w http.ResponseWriter, r *http.Request) {})
+			// RequestID passes a copy of the request, like the real chain
+			h := traceRequests(middleware.RequestID(routedSpan(mux)), provider, trust)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/login", nil)
+			req.Header.Set("traceparent", traceparent)
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := recorder.Ended()
+			if len(spans) != 1 {
+				t.Fatalf("Got %d spans, want 1", len(spans))
+			}
+			span := spans[0]
+			if span.Name() != "POST /api/v1/login" {
+				t.Errorf("Span name = %q, want %q", span.Name(), "POST /api/v1/login")
+			}
+			var route string
+			for _, attr := range span.Attributes() {
+				if attr.Key == "http.route" {
+					route = attr.Value.AsString()
+				}
+			}
+			if route != "POST /api/v1/login" {
+				t.Errorf("http.route = %q, want the pattern", route)
+			}
+
+			continued := span.SpanContext().TraceID().String() == traceID && span.Parent().SpanID().String() == parentID
+			if continued != trust {
+				t.Errorf("Trace continued = %v, want %v", continued, trust)
+			}
+			if !trust && len(span.Links()) != 1 {
+				t.Errorf("Got %d links, want the untrusted parent as link", len(span.Links()))
+			}
+		})
 	}
 }
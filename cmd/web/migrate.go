@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"foodshop/internal/config"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const migrateUsage = "usage: foodshop migrate [flags] status|up|down|to <version>"
+
+// runMigrate implements "foodshop migrate": status lists the migrations,
+// up applies all pending ones, down reverts the newest and to migrates up
+// or down to a version (0 reverts everything). Flags and environment are
+// the same as for the server, but only the database settings are used.
+func runMigrate(ctx context.Context, args []string, out io.Writer) error {
+	dbCfg, args, err := config.LoadDatabase(args, os.Getenv)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	command, args := args[0], args[1:]
+	target := -1
+	switch {
+	case command == "to" && len(args) == 1:
+		target, err = strconv.Atoi(args[0])
+		if err != nil || target < 0 {
+			return fmt.Errorf("invalid version %q", args[0])
+		}
+	case (command == "status" || command == "up" || command == "down") && len(args) == 0:
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	db, err := openDatabase(dbCfg)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+	migrator, err := db.Migrator(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		err = migrator.Down(ctx)
+	case "to":
+		err = migrator.To(ctx, target)
+	}
+	if err != nil {
+		return err
+	}
+
+	if command == "status" {
+		states, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, s := range states {
+			state, appliedAt := "pending", ""
+			switch {
+			case s.Unknown:
+				state = "unknown"
+			case s.Modified():
+				state = "modified"
+			case s.Applied:
+				state = "applied"
+			}
+			if s.Applied {
+				appliedAt = s.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+		}
+		tw.Flush()
+	}
+	version, err := migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Schema version %d (latest %d)\n", version, migrator.Latest())
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"foodshop/internal/auth"
+	"foodshop/internal/challenge"
+	"foodshop/internal/database"
+	"foodshop/internal/handler"
+	"foodshop/internal/health"
+	"foodshop/internal/middleware"
+	"foodshop/internal/openapi"
+	"foodshop/internal/router"
+	"time"
+)
+
+// apiPrefix is the path prefix of the current API version.
+const apiPrefix = "/api/v1"
+
+// legacySince is the date the unversioned paths were deprecated. They stay
+// available as aliases of the /api/v1 routes until clients have moved.
+var legacySince = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+
+// newRouter registers all API routes. Every route must be described in
+// internal/openapi/openapi.json (see TestOpenAPI_CoversRoutes).
+func newRouter(db database.Store, tokenBlacklist *auth.TokenBlacklist, checker *health.Checker,
+	rateLimits middleware.RateLimitPolicies, challengeGuard *challenge.Guard, docs bool) *router.Router {
+	r := router.New()
+
+	// Index und Betriebsendpunkte bleiben ohne Versionspräfix
+	r.HandleFunc("GET /{$}", handler.IndexHandler())
+	r.HandleFunc("GET /healthz", health.LivenessHandler())
+	r.HandleFunc("GET /readyz", checker.ReadinessHandler())
+	r.HandleFunc("GET /openapi.json", openapi.Handler())
+	if docs {
+		r.HandleFunc("GET /docs", openapi.DocsHandler())
+	}
+
+	// Public endpoints (no authentication required)
+	api := r.Group(apiPrefix)
+	loginLimit := rateLimits.Attach("login")
+	api.Group("", rateLimits.Attach("registration"), challengeGuard.Protect).
+		HandleFunc("POST /registration", handler.RegistrationHandler(db))
+	credentials := api.Group("", loginLimit, challengeGuard.Protect)
+	credentials.HandleFunc("POST /login", handler.LoginHandler(db))
+	credentials.HandleFunc("POST /password", handler.ChangePasswordHandler(db))
+	api.HandleFunc("POST /refresh", handler.RefreshHandler(db))
+
+	// Protected endpoints; the per-user limit runs after authentication
+	protected := api.Group("", middleware.AuthMiddleware(tokenBlacklist), rateLimits.Attach("authenticated"))
+	protected.HandleFunc("POST /logout", handler.LogoutHandler(tokenBlacklist))
+	protected.HandleFunc("GET /profile", handler.ProfileHandler(db))
+	protected.HandleFunc("PUT /profile", handler.UpdateProfileHandler(db))
+	protected.HandleFunc("PATCH /profile", handler.UpdateProfileHandler(db))
+
+	// Deprecated unversioned paths; the profile was read with POST before
+	for _, alias := range []struct{ pattern, target string }{
+		{"POST /registration", "POST /api/v1/registration"},
+		{"POST /login", "POST /api/v1/login"},
+		{"POST /refresh", "POST /api/v1/refresh"},
+		{"POST /password", "POST /api/v1/password"},
+		{"POST /logout", "POST /api/v1/logout"},
+		{"POST /profile", "GET /api/v1/profile"},
+		{"PUT /profile", "PUT /api/v1/profile"},
+		{"PATCH /profile", "PATCH /api/v1/profile"},
+	} {
+		r.Alias(alias.pattern, alias.target, legacySince)
+	}
+	return r
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"foodshop/internal/config"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newTracerProvider installs the OpenTelemetry tracer provider for cfg as
+// global provider. It returns a nil provider if tracing is disabled; the
+// close function flushes the pending spans and must be called in any case.
+func newTracerProvider(cfg config.Tracing) (*sdktrace.TracerProvider, func(), error) {
+	if cfg.Exporter == "none" {
+		return nil, func() {}, nil
+	}
+	res, err := resource.Merge(resource.Default(),
+		resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("tracing resource: %w", err)
+	}
+
+	var (
+		exporter sdktrace.SpanExporter
+		file     io.Closer
+	)
+	switch cfg.Exporter {
+	case "otlp":
+		exporter, err = newOTLPExporter(cfg.Endpoint, string(cfg.Token))
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "file":
+		var f *os.File
+		f, err = os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("open span file: %w", err)
+		}
+		file = f
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	default:
+		return nil, nil, fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return nil, nil, fmt.Errorf("tracing exporter: %w", err)
+	}
+
+	// Continued traces follow the caller's sampling decision
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	slog.Info("Tracing enabled", "exporter", cfg.Exporter, "sample_ratio", cfg.SampleRatio)
+	return provider, func() {
+		// Not bound to the signal context, which is already cancelled here
+		if err := provider.Shutdown(context.Background()); err != nil {
+			slog.Warn("Failed to flush spans", "error", err)
+		}
+		if file != nil {
+			file.Close()
+		}
+	}, nil
+}
+
+// newOTLPExporter sends spans with OTLP/HTTP to endpoint; "/v1/traces" is
+// appended if the URL has no path. A token is sent as bearer token.
+func newOTLPExporter(endpoint, token string) (sdktrace.SpanExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpointURL(u.String())}
+	if token != "" {
+		opts = append(opts, otlptracehttp.WithHeaders(map[string]string{"Authorization": "Bearer " + token}))
+	}
+	return otlptracehttp.New(context.Background(), opts...)
+}
+
+// traceRequests starts the server span for every request around the whole
+// chain, continuing an incoming W3C traceparent. Without trustParent it is
+// only linked, so clients cannot choose trace IDs or force sampling.
+func traceRequests(handler http.Handler, provider trace.TracerProvider, trustParent bool) http.Handler {
+	opts := []otelhttp.Option{
+		otelhttp.WithTracerProvider(provider),
+		otelhttp.WithPropagators(propagation.TraceContext{}),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string { return spanName(r) }),
+	}
+	if !trustParent {
+		opts = append(opts, otelhttp.WithPublicEndpointFn(func(*http.Request) bool { return true }))
+	}
+	return otelhttp.NewHandler(handler, "http.server", opts...)
+}
+
+// routedSpan wraps the ServeMux: after routing it names the server span
+// "<method> <pattern>" and sets http.route. It must receive the request the
+// mux gets, because the mux stores the matched pattern in it.
+func routedSpan(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r)
+
+		span := trace.SpanFromContext(r.Context())
+		if !span.IsRecording() || r.Pattern == "" {
+			return
+		}
+		span.SetName(spanName(r))
+		span.SetAttributes(attribute.String("http.route", r.Pattern))
+	})
+}
+
+// spanName returns "<method> <pattern>", or only the method before routing.
+func spanName(r *http.Request) string {
+	switch {
+	case r.Pattern == "":
+		return r.Method
+	case strings.Contains(r.Pattern, " "):
+		return r.Pattern
+	}
+	return r.Method + " " + r.Pattern
+}
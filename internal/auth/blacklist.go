@@ -9,12 +9,18 @@ import (
 type TokenBlacklist struct {
 	tokens map[string]time.Time
 	mu     sync.RWMutex
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 // NewTokenBlacklist creates a new token blacklist
 func NewTokenBlacklist() *TokenBlacklist {
 	bl := &TokenBlacklist{
 		tokens: make(map[string]time.Time),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
 	}
 
 	// Start cleanup goroutine
@@ -38,12 +44,44 @@ func (bl *TokenBlacklist) IsBlacklisted(token string) bool {
 	return exists
 }
 
+// Len returns the number of blacklisted tokens (including expired ones not yet cleaned up).
+func (bl *TokenBlacklist) Len() int {
+	bl.mu.RLock()
+	defer bl.mu.RUnlock()
+	return len(bl.tokens)
+}
+
+// Close stops the cleanup goroutine and waits until it has exited.
+func (bl *TokenBlacklist) Close() error {
+	bl.closeOnce.Do(func() { close(bl.stop) })
+	<-bl.done
+	return nil
+}
+
+// Running reports whether the cleanup goroutine is still running.
+func (bl *TokenBlacklist) Running() bool {
+	select {
+	case <-bl.done:
+		return false
+	default:
+		return true
+	}
+}
+
 // cleanup removes expired tokens from the blacklist
 func (bl *TokenBlacklist) cleanup() {
+	defer close(bl.done)
+
 	ticker := time.NewTicker(1 * time.Hour)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-bl.stop:
+			return
+		case <-ticker.C:
+		}
+
 		bl.mu.Lock()
 		now := time.Now()
 		for token, expiresAt := range bl.tokens {
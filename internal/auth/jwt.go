@@ -17,7 +17,9 @@ var (
 
 // JWT secret key - In production, load from environment variable!
 // For now, we use a default value for development
-var jwtSecret = []byte("your-secret-key-change-in-production")
+var jwtSecret = []byte(defaultJWTSecret)
+
+const defaultJWTSecret = "your-secret-key-change-in-production"
 
 // Claims represents the JWT claims
 type Claims struct {
@@ -31,6 +33,25 @@ func SetJWTSecret(secret string) {
 	jwtSecret = []byte(secret)
 }
 
+// CheckSigningKey verifies that a real signing key is configured and that
+// tokens can be signed and validated with it (used by the readiness check).
+func CheckSigningKey() error {
+	if len(jwtSecret) == 0 {
+		return errors.New("no JWT signing key configured")
+	}
+	if string(jwtSecret) == defaultJWTSecret {
+		return errors.New("JWT signing key is the development default")
+	}
+	token, err := GenerateToken(0, "healthcheck")
+	if err != nil {
+		return err
+	}
+	if _, err := ValidateToken(token); err != nil {
+		return fmt.Errorf("signing key cannot validate its own token: %w", err)
+	}
+	return nil
+}
+
 // GenerateToken generates a new JWT token for a user
 func GenerateToken(userID int64, username string) (string, error) {
 	// Token expires in 24 hours
@@ -118,3 +118,52 @@ func TestTokenBlacklist(t *testing.T) {
 		t.Error("Different token should not be blacklisted")
 	}
 }
+
+func TestTokenBlacklistClose(t *testing.T) {
+	bl := NewTokenBlacklist()
+	bl.Add("token", time.Now().Add(time.Hour))
+
+	done := make(chan struct{})
+	go func() {
+		bl.Close()
+		bl.Close() // idempotent
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close() did not stop the cleanup goroutine")
+	}
+
+	if bl.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", bl.Len())
+	}
+	if bl.Running() {
+		t.Error("Running() after Close = true, want false")
+	}
+
+	// Lookups still work after Close
+	if !bl.IsBlacklisted("token") {
+		t.Error("Token should still be blacklisted after Close")
+	}
+}
+
+func TestCheckSigningKey(t *testing.T) {
+	defer SetJWTSecret(defaultJWTSecret)
+
+	tests := []struct {
+		secret  string
+		wantErr bool
+	}{
+		{"", true},
+		{defaultJWTSecret, true},
+		{"test-secret-key-with-at-least-32-bytes", false},
+	}
+	for _, tt := range tests {
+		SetJWTSecret(tt.secret)
+		if err := CheckSigningKey(); (err != nil) != tt.wantErr {
+			t.Errorf("CheckSigningKey() with %q error = %v, wantErr %v", tt.secret, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package challenge
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// SiteVerifyCaptcha verifies CAPTCHA tokens with the "siteverify" API that
+// hCaptcha, reCAPTCHA and Cloudflare Turnstile have in common.
+// The client renders the widget with SiteKey and sends the token in ResponseHeader.
+type SiteVerifyCaptcha struct {
+	// Provider is reported as challenge type (e.g. "hcaptcha", "turnstile").
+	Provider string
+	// VerifyURL is the siteverify endpoint of the provider.
+	VerifyURL string
+	// SiteKey is the public key for the widget.
+	SiteKey string
+	// Secret is the private key for the siteverify call.
+	Secret string
+	// Client is used for the siteverify call (default: 5s timeout).
+	Client *http.Client
+}
+
+// Issue returns the widget configuration; the CAPTCHA itself is created by the provider.
+func (c *SiteVerifyCaptcha) Issue() (*Challenge, error) {
+	return &Challenge{Type: c.Provider, SiteKey: c.SiteKey}, nil
+}
+
+// Verify asks the provider whether the token is valid.
+func (c *SiteVerifyCaptcha) Verify(response, clientIP string) error {
+	client := c.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+
+	resp, err := client.PostForm(c.VerifyURL, url.Values{
+		"secret":   {c.Secret},
+		"response": {response},
+		"remoteip": {clientIP},
+	})
+	if err != nil {
+		return fmt.Errorf("captcha siteverify: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("captcha siteverify: %w", err)
+	}
+	if !result.Success {
+		return ErrInvalidResponse
+	}
+	return nil
+}
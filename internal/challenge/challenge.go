@@ -0,0 +1,236 @@
+package challenge
+
+import (
+	"errors"
+	"foodshop/internal/middleware"
+	"foodshop/internal/problem"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// tracer starts the span for challenge verification.
+var tracer = otel.Tracer("foodshop/internal/challenge")
+
+// ResponseHeader carries the client's answer to a challenge
+// (proof-of-work solution or CAPTCHA token).
+const ResponseHeader = "X-Challenge-Response"
+
+var (
+	// ErrMissingResponse is returned when no challenge response was sent.
+	ErrMissingResponse = errors.New("challenge response missing")
+	// ErrInvalidResponse is returned when the challenge response is wrong, expired or reused.
+	ErrInvalidResponse = errors.New("invalid challenge response")
+)
+
+// Challenge describes what the client has to solve. It is sent in the
+// error response when a challenge is required.
+type Challenge struct {
+	// Type is "pow" for proof-of-work or the CAPTCHA provider name.
+	Type string `json:"type"`
+	// Challenge is the opaque proof-of-work challenge.
+	Challenge string `json:"challenge,omitempty"`
+	// Difficulty is the number of leading zero bits the proof-of-work hash needs.
+	Difficulty int `json:"difficulty,omitempty"`
+	// SiteKey is the public CAPTCHA site key.
+	SiteKey string `json:"site_key,omitempty"`
+}
+
+// Verifier issues and verifies challenges. The default is the self-hosted
+// ProofOfWork; a CAPTCHA service can be plugged in via SiteVerifyCaptcha
+// or any other implementation.
+type Verifier interface {
+	// Issue creates a new challenge for a client.
+	Issue() (*Challenge, error)
+	// Verify checks the client's response (content of ResponseHeader).
+	Verify(response, clientIP string) error
+}
+
+// Guard demands a challenge from clients with repeated failures.
+// Failures are derived from the response status of the protected handler
+// (401, 409, 429 and 400), so handlers don't need to know about it.
+type Guard struct {
+	verifier  Verifier
+	threshold int
+	window    time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*client
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type client struct {
+	failures int
+	lastSeen time.Time
+}
+
+// NewGuard creates a Guard that requires a challenge after threshold
+// failures of one client within window.
+func NewGuard(verifier Verifier, threshold int, window time.Duration) *Guard {
+	g := &Guard{
+		verifier:  verifier,
+		threshold: threshold,
+		window:    window,
+		clients:   make(map[string]*client),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	// Start cleanup goroutine
+	go g.cleanup()
+
+	return g
+}
+
+// Protect returns a middleware that verifies the challenge response before
+// the handler runs (i.e. before any password check) if the client is suspicious.
+func (g *Guard) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := middleware.ClientIP(r)
+
+		if g.Required(ip) {
+			response := r.Header.Get(ResponseHeader)
+			err := ErrMissingResponse
+			if response != "" {
+				_, span := tracer.Start(r.Context(), "challenge.Verify")
+				err = g.verifier.Verify(response, ip)
+				if err != nil {
+					span.RecordError(err)
+					span.SetStatus(codes.Error, err.Error())
+				}
+				span.End()
+			}
+			if err != nil {
+				g.writeRequired(w, r, err)
+				return
+			}
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		switch {
+		case isFailure(recorder.status):
+			g.RecordFailure(ip)
+		case recorder.status < 300:
+			g.Reset(ip)
+		}
+	})
+}
+
+// Required reports whether the client has to solve a challenge.
+func (g *Guard) Required(ip string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c, ok := g.clients[ip]
+	if !ok || time.Since(c.lastSeen) > g.window {
+		return false
+	}
+	return c.failures >= g.threshold
+}
+
+// RecordFailure counts a failed request of the client.
+func (g *Guard) RecordFailure(ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c, ok := g.clients[ip]
+	if !ok || time.Since(c.lastSeen) > g.window {
+		c = &client{}
+		g.clients[ip] = c
+	}
+	c.failures++
+	c.lastSeen = time.Now()
+}
+
+// Reset forgets the failures of the client.
+func (g *Guard) Reset(ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.clients, ip)
+}
+
+func (g *Guard) writeRequired(w http.ResponseWriter, r *http.Request, cause error) {
+	ch, err := g.verifier.Issue()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to issue challenge", "error", err)
+		problem.Error(w, r, problem.CodeInternal)
+		return
+	}
+
+	p := problem.New(problem.CodeChallengeRequired)
+	if errors.Is(cause, ErrInvalidResponse) {
+		p.Variant("invalid_response")
+	}
+	problem.Write(w, r, p.
+		With("challenge_required", true).
+		With("challenge", ch))
+}
+
+// Close stops the cleanup goroutine and waits until it has exited.
+func (g *Guard) Close() error {
+	g.closeOnce.Do(func() { close(g.stop) })
+	<-g.done
+	return nil
+}
+
+// Running reports whether the cleanup goroutine is still running.
+func (g *Guard) Running() bool {
+	select {
+	case <-g.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// cleanup removes clients whose failures are outside the window.
+func (g *Guard) cleanup() {
+	defer close(g.done)
+
+	ticker := time.NewTicker(g.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.C:
+		}
+
+		g.mu.Lock()
+		for ip, c := range g.clients {
+			if time.Since(c.lastSeen) > g.window {
+				delete(g.clients, ip)
+			}
+		}
+		g.mu.Unlock()
+	}
+}
+
+func isFailure(status int) bool {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusConflict, http.StatusTooManyRequests:
+		return true
+	}
+	return false
+}
+
+// statusRecorder captures the status code written by the protected handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	sr.status = code
+	sr.ResponseWriter.WriteHeader(code)
+}
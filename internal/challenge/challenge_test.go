@@ -0,0 +1,173 @@
+package challenge
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestPoW(t *testing.T) *ProofOfWork {
+	t.Helper()
+	pow, err := NewProofOfWork([]byte("test-key"), 8, time.Minute)
+	if err != nil {
+		t.Fatalf("NewProofOfWork() failed: %v", err)
+	}
+	return pow
+}
+
+func TestProofOfWork(t *testing.T) {
+	pow := newTestPoW(t)
+
+	ch, err := pow.Issue()
+	if err != nil {
+		t.Fatalf("Issue() failed: %v", err)
+	}
+	if ch.Type != "pow" || ch.Difficulty != 8 {
+		t.Errorf("Unexpected challenge: %+v", ch)
+	}
+
+	solution := Solve(ch.Challenge, ch.Difficulty)
+	if err := pow.Verify(solution, "10.0.0.1"); err != nil {
+		t.Errorf("Verify() of valid solution failed: %v", err)
+	}
+
+	// Solutions can only be used once
+	if err := pow.Verify(solution, "10.0.0.1"); !errors.Is(err, ErrInvalidResponse) {
+		t.Errorf("Expected replay to fail, got %v", err)
+	}
+}
+
+func TestProofOfWorkRejectsInvalid(t *testing.T) {
+	pow := newTestPoW(t)
+	ch, _ := pow.Issue()
+
+	// Find a counter that does NOT satisfy the difficulty
+	var unsolved string
+	for i := 0; unsolved == ""; i++ {
+		candidate := ch.Challenge + ":" + strconv.Itoa(i)
+		if sum := sha256.Sum256([]byte(candidate)); leadingZeroBits(sum[:]) < 8 {
+			unsolved = candidate
+		}
+	}
+
+	other, _ := NewProofOfWork([]byte("other-key"), 8, time.Minute)
+	foreign, _ := other.Issue()
+
+	expired, _ := NewProofOfWork([]byte("test-key"), 8, -time.Minute)
+	old, _ := expired.Issue()
+
+	tests := []struct {
+		name     string
+		response string
+	}{
+		{"Not enough work", unsolved},
+		{"No counter", ch.Challenge},
+		{"Garbage", "abc"},
+		{"Foreign key", Solve(foreign.Challenge, 8)},
+		{"Expired", Solve(old.Challenge, 8)},
+		{"Tampered difficulty", Solve(strings.Replace(ch.Challenge, ".8.", ".1.", 1), 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := pow.Verify(tt.response, ""); !errors.Is(err, ErrInvalidResponse) {
+				t.Errorf("Expected ErrInvalidResponse, got %v", err)
+			}
+		})
+	}
+}
+
+func TestGuard(t *testing.T) {
+	pow := newTestPoW(t)
+	guard := NewGuard(pow, 2, time.Minute)
+
+	// Handler fails unless the body says "ok"
+	calls := 0
+	protected := guard.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Query().Get("ok") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	send := func(query, response string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/login"+query, nil)
+		if response != "" {
+			req.Header.Set(ResponseHeader, response)
+		}
+		w := httptest.NewRecorder()
+		protected.ServeHTTP(w, req)
+		return w
+	}
+
+	// Two failures are counted normally
+	for i := 0; i < 2; i++ {
+		if w := send("", ""); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Attempt %d: expected 401, got %d", i+1, w.Code)
+		}
+	}
+
+	// Now a challenge is required before the handler runs
+	w := send("?ok=1", "")
+	if w.Code != http.StatusPreconditionRequired {
+		t.Fatalf("Expected 428, got %d", w.Code)
+	}
+	if calls != 2 {
+		t.Errorf("Handler must not run without a solved challenge, calls = %d", calls)
+	}
+
+	var body struct {
+		Code              string     `json:"code"`
+		ChallengeRequired bool       `json:"challenge_required"`
+		Challenge         *Challenge `json:"challenge"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if body.Code != "challenge_required" || !body.ChallengeRequired || body.Challenge == nil || body.Challenge.Challenge == "" {
+		t.Fatalf("Expected challenge in response, got %+v", body)
+	}
+
+	// Invalid response gets a new challenge
+	if w := send("?ok=1", body.Challenge.Challenge+":0"); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("Invalid solution: expected 428, got %d", w.Code)
+	}
+
+	// Solved challenge lets the request through; success resets the client
+	if w := send("?ok=1", Solve(body.Challenge.Challenge, body.Challenge.Difficulty)); w.Code != http.StatusOK {
+		t.Errorf("Solved challenge: expected 200, got %d", w.Code)
+	}
+	if guard.Required("192.0.2.1") {
+		t.Error("Successful request should reset the client")
+	}
+}
+
+func TestSiteVerifyCaptcha(t *testing.T) {
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		success := r.Form.Get("secret") == "s3cret" && r.Form.Get("response") == "good-token"
+		json.NewEncoder(w).Encode(map[string]bool{"success": success})
+	}))
+	defer provider.Close()
+
+	captcha := &SiteVerifyCaptcha{Provider: "hcaptcha", VerifyURL: provider.URL, SiteKey: "site", Secret: "s3cret"}
+
+	ch, _ := captcha.Issue()
+	if ch.Type != "hcaptcha" || ch.SiteKey != "site" {
+		t.Errorf("Unexpected challenge: %+v", ch)
+	}
+	if err := captcha.Verify("good-token", "10.0.0.1"); err != nil {
+		t.Errorf("Verify() of valid token failed: %v", err)
+	}
+	if err := captcha.Verify("bad-token", "10.0.0.1"); !errors.Is(err, ErrInvalidResponse) {
+		t.Errorf("Expected ErrInvalidResponse, got %v", err)
+	}
+}
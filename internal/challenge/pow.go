@@ -0,0 +1,162 @@
+package challenge
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDifficulty needs about one million hashes (well below a second
+// in a browser), which is negligible for users but expensive at scale.
+const DefaultDifficulty = 20
+
+// ProofOfWork is a self-hosted hashcash-style challenge. No external
+// service is involved.
+//
+// The challenge "<expiry>.<difficulty>.<nonce>.<mac>" is signed with a
+// server key, so the server keeps no state until a solution is submitted.
+// The client answers with "<challenge>:<counter>" where
+// SHA-256("<challenge>:<counter>") has at least difficulty leading zero bits.
+// Every challenge can be used only once.
+type ProofOfWork struct {
+	key        []byte
+	difficulty int
+	ttl        time.Duration
+
+	mu        sync.Mutex
+	spent     map[string]time.Time
+	lastPrune time.Time
+}
+
+// NewProofOfWork creates a proof-of-work verifier. If key is empty a random
+// key is generated (challenges are then only valid for this process).
+func NewProofOfWork(key []byte, difficulty int, ttl time.Duration) (*ProofOfWork, error) {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("generate challenge key: %w", err)
+		}
+	}
+	if difficulty < 1 || difficulty > 32 {
+		return nil, fmt.Errorf("difficulty must be between 1 and 32, got %d", difficulty)
+	}
+	return &ProofOfWork{
+		key:        key,
+		difficulty: difficulty,
+		ttl:        ttl,
+		spent:      make(map[string]time.Time),
+		lastPrune:  time.Now(),
+	}, nil
+}
+
+// Issue creates a new signed challenge.
+func (p *ProofOfWork) Issue() (*Challenge, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate challenge nonce: %w", err)
+	}
+
+	payload := fmt.Sprintf("%d.%d.%s",
+		time.Now().Add(p.ttl).Unix(),
+		p.difficulty,
+		base64.RawURLEncoding.EncodeToString(nonce),
+	)
+
+	return &Challenge{
+		Type:       "pow",
+		Challenge:  payload + "." + p.sign(payload),
+		Difficulty: p.difficulty,
+	}, nil
+}
+
+// Verify checks signature, expiry, work and single use of the solution.
+func (p *ProofOfWork) Verify(response, clientIP string) error {
+	challenge, counter, found := strings.Cut(response, ":")
+	if !found || counter == "" {
+		return ErrInvalidResponse
+	}
+
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 4 {
+		return ErrInvalidResponse
+	}
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(p.sign(payload))) {
+		return ErrInvalidResponse
+	}
+
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return ErrInvalidResponse
+	}
+	difficulty, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return ErrInvalidResponse
+	}
+
+	sum := sha256.Sum256([]byte(response))
+	if leadingZeroBits(sum[:]) < difficulty {
+		return ErrInvalidResponse
+	}
+
+	// Single use: remember the challenge until it expires
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prune()
+	if _, used := p.spent[challenge]; used {
+		return ErrInvalidResponse
+	}
+	p.spent[challenge] = time.Unix(expiry, 0)
+
+	return nil
+}
+
+// Solve computes a solution for a challenge. It is used by tests and
+// non-browser clients; browsers implement the same loop in JavaScript.
+func Solve(challenge string, difficulty int) string {
+	for counter := 0; ; counter++ {
+		response := challenge + ":" + strconv.Itoa(counter)
+		sum := sha256.Sum256([]byte(response))
+		if leadingZeroBits(sum[:]) >= difficulty {
+			return response
+		}
+	}
+}
+
+func (p *ProofOfWork) sign(payload string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// prune forgets expired spent challenges (at most once per ttl). Caller holds p.mu.
+func (p *ProofOfWork) prune() {
+	now := time.Now()
+	if now.Sub(p.lastPrune) < p.ttl {
+		return
+	}
+	for c, expiry := range p.spent {
+		if now.After(expiry) {
+			delete(p.spent, c)
+		}
+	}
+	p.lastPrune = now
+}
+
+func leadingZeroBits(b []byte) int {
+	n := 0
+	for _, v := range b {
+		if v != 0 {
+			return n + bits.LeadingZeros8(v)
+		}
+		n += 8
+	}
+	return n
+}
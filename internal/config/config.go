@@ -0,0 +1,546 @@
+// Package config holds the typed server configuration.
+//
+// Values are applied in this order, later sources win:
+//
+//  1. Defaults (Default)
+//  2. YAML file (-config flag or CONFIG_FILE)
+//  3. Environment variables
+//  4. Command line flags
+//
+// Every setting has a path like "server.port". The environment variable is
+// the upper-case path with "_" (SERVER_PORT) unless the field has an `env`
+// tag, and the flag is the path itself (-server.port). Secrets can also be
+// read from a file named in <ENV>_FILE (e.g. JWTSECRET_FILE), which works
+// with Docker/Kubernetes secrets and systemd credentials.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"foodshop/internal/i18n"
+	"foodshop/internal/passhash"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Secret is a configuration value that is never printed.
+type Secret string
+
+// String redacts the secret, so it is safe in logs and error messages.
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "[REDACTED]"
+}
+
+// MarshalYAML redacts the secret when the effective config is printed.
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// DSN is a connection string that may contain a password
+// (e.g. "redis://:password@host:6379/0" or "host=db password=secret").
+// The password is never printed.
+type DSN string
+
+// dsnPasswordRegex finds passwords in the keyword/value form of libpq.
+var dsnPasswordRegex = regexp.MustCompile(`(?i)((?:^|\s)(?:ssl)?password)\s*=\s*(?:'(?:[^'\\]|\\.)*'|\S*)`)
+
+// String returns the DSN with passwords (user info, "password" query
+// parameters and keywords) replaced by "xxxxx". A DSN that cannot be
+// parsed is printed as "[REDACTED]".
+func (d DSN) String() string {
+	if !strings.Contains(string(d), "://") {
+		return dsnPasswordRegex.ReplaceAllString(string(d), "${1}=xxxxx")
+	}
+	u, err := url.Parse(string(d))
+	if err != nil {
+		return "[REDACTED]"
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	query := u.Query()
+	redacted := false
+	for key := range query {
+		if strings.EqualFold(key, "password") || strings.EqualFold(key, "sslpassword") {
+			query.Set(key, "xxxxx")
+			redacted = true
+		}
+	}
+	if redacted {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// MarshalYAML redacts the password when the effective config is printed.
+func (d DSN) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+// Config is the complete server configuration.
+type Config struct {
+	Server            Server            `yaml:"server"`
+	Log               Log               `yaml:"log"`
+	Language          Language          `yaml:"language"`
+	Docs              Docs              `yaml:"docs"`
+	TLS               TLS               `yaml:"tls"`
+	Metrics           Metrics           `yaml:"metrics"`
+	Tracing           Tracing           `yaml:"tracing"`
+	Database          Database          `yaml:"database"`
+	Auth              Auth              `yaml:"auth"`
+	CORS              CORS              `yaml:"cors"`
+	TrustedProxies    []string          `yaml:"trusted_proxies"`
+	RateLimit         RateLimit         `yaml:"rate_limit"`
+	Password          Password          `yaml:"password"`
+	BreachedPasswords BreachedPasswords `yaml:"breached_passwords"`
+	LoginThrottle     LoginThrottle     `yaml:"login_throttle"`
+	Challenge         Challenge         `yaml:"challenge"`
+}
+
+// Server configures the HTTP server.
+type Server struct {
+	Host            string        `yaml:"host"`
+	Port            int           `yaml:"port"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	RequestTimeout  time.Duration `yaml:"request_timeout"`
+	MaxBodyBytes    int64         `yaml:"max_body_bytes"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// ShutdownDelay keeps serving with /readyz reporting 503 before the
+	// listener closes, so load balancers stop routing new requests first.
+	ShutdownDelay time.Duration `yaml:"shutdown_delay"`
+}
+
+// Addr returns host:port for the listener.
+func (s Server) Addr() string {
+	return net.JoinHostPort(s.Host, fmt.Sprint(s.Port))
+}
+
+// Log configures the server log (log/slog).
+type Log struct {
+	// Level is debug, info, warn or error.
+	Level string `yaml:"level"`
+	// Format is json (one object per line) or text (key=value).
+	Format string `yaml:"format"`
+}
+
+// Language configures the language of API messages.
+type Language struct {
+	// Default is used when Accept-Language names no supported language
+	// and the user has no preference (de or en).
+	Default string `yaml:"default"`
+}
+
+// Docs configures the API documentation. /openapi.json is always served.
+type Docs struct {
+	// Enabled serves the HTML rendering of the OpenAPI document at /docs.
+	Enabled bool `yaml:"enabled"`
+}
+
+// TLS configures native HTTPS. TLS is enabled when CertFile is set.
+type TLS struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ReloadInterval is how often the files are checked for changes.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+	// ClientCAFile enables mTLS; ClientAuth is "none", "request" or "require"
+	// (empty: "require" with ClientCAFile, "none" without).
+	ClientCAFile string `yaml:"client_ca_file"`
+	ClientAuth   string `yaml:"client_auth"`
+	// ClientIdentities maps certificate subjects to service identities.
+	// Environment/flag format: "CN=billing,O=Foodshop=billing;CN=...=...".
+	ClientIdentities map[string]string `yaml:"client_identities"`
+}
+
+// Enabled reports whether the server speaks TLS.
+func (t TLS) Enabled() bool { return t.CertFile != "" }
+
+// Metrics configures the Prometheus endpoint. It is served on its own
+// listener, so it can stay on an internal interface.
+type Metrics struct {
+	// Listen is host:port of the metrics listener; empty disables /metrics.
+	Listen string `yaml:"listen"`
+	// Token, if set, must be sent as "Authorization: Bearer <token>".
+	Token Secret `yaml:"token"`
+}
+
+// Tracing configures span export.
+type Tracing struct {
+	// Exporter is "none", "otlp", "stdout" or "file".
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the OTLP/HTTP collector URL, e.g. http://localhost:4318.
+	Endpoint string `yaml:"endpoint"`
+	// Token, if set, is sent to the collector as bearer token.
+	Token Secret `yaml:"token"`
+	// File is the JSON lines output of the file exporter.
+	File string `yaml:"file"`
+	// SampleRatio is the share of new traces that are recorded (0..1).
+	SampleRatio float64 `yaml:"sample_ratio"`
+	ServiceName string  `yaml:"service_name"`
+	// TrustParent continues traces from incoming traceparent headers.
+	TrustParent bool `yaml:"trust_parent"`
+}
+
+// Database configures the user database.
+type Database struct {
+	// DSN selects PostgreSQL, e.g. "postgres://foodshop:secret@db:5432/foodshop"
+	// or "host=/run/postgresql dbname=foodshop".
+	// Empty uses the SQLite file at Path.
+	DSN  DSN    `yaml:"dsn"`
+	Path string `yaml:"path"`
+	// AutoMigrate applies pending migrations on startup. Without it the
+	// schema is migrated with "foodshop migrate up" and /readyz reports
+	// the mismatch until then.
+	AutoMigrate bool `yaml:"auto_migrate"`
+}
+
+// Postgres reports whether the DSN selects PostgreSQL.
+func (d Database) Postgres() bool { return d.DSN != "" }
+
+// validate checks the database section (also used by LoadDatabase).
+func (d Database) validate() error {
+	if !d.Postgres() {
+		if d.Path == "" {
+			return errors.New("database.path must not be empty")
+		}
+		return nil
+	}
+	// Accepts URLs (also "postgres:///foodshop?host=/run/postgresql") and
+	// the keyword/value form. The DSN and the parse error are not printed,
+	// both may contain the password.
+	if _, err := pgx.ParseConfig(string(d.DSN)); err != nil {
+		return errors.New("database.dsn must be a PostgreSQL URL or keyword/value connection string")
+	}
+	return nil
+}
+
+// Auth configures JWT authentication.
+type Auth struct {
+	JWTSecret Secret `yaml:"jwt_secret" env:"JWTSECRET"`
+}
+
+// CORS configures cross-origin requests.
+type CORS struct {
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+// RateLimitPolicy is one rate limit (see middleware.RateLimitPolicy).
+type RateLimitPolicy struct {
+	Requests int           `yaml:"requests"`
+	Period   time.Duration `yaml:"period"`
+	Burst    int           `yaml:"burst"`
+}
+
+// RateLimit configures the global and per-route rate limits.
+type RateLimit struct {
+	// Store is "memory", "sqlite:<path>", "redis://..." or "rediss://..." (TLS,
+	// see ratelimit.OpenStore).
+	Store         DSN             `yaml:"store"`
+	Default       RateLimitPolicy `yaml:"default"`
+	Login         RateLimitPolicy `yaml:"login"`
+	Registration  RateLimitPolicy `yaml:"registration"`
+	Authenticated RateLimitPolicy `yaml:"authenticated"`
+}
+
+// Password configures hashing, history, expiry and the password policy.
+type Password struct {
+	// Pepper is "secret" or "1:old,2:new" (see passhash.ParsePepper).
+	Pepper   Secret        `yaml:"pepper"`
+	History  int           `yaml:"history"`
+	MaxAge   time.Duration `yaml:"max_age"`
+	Policy   string        `yaml:"policy"`
+	MinScore int           `yaml:"min_score"`
+	Argon2   Argon2        `yaml:"argon2"`
+}
+
+// Argon2 tunes the cost of new password hashes (see passhash.Argon2Params).
+// Existing hashes are upgraded on the next successful login.
+type Argon2 struct {
+	Memory      int `yaml:"memory"` // KiB
+	Iterations  int `yaml:"iterations"`
+	Parallelism int `yaml:"parallelism"`
+}
+
+// Params returns the argon2id parameters with the default salt and key length.
+func (a Argon2) Params() passhash.Argon2Params {
+	params := passhash.DefaultArgon2Params
+	params.Memory = uint32(a.Memory)
+	params.Iterations = uint32(a.Iterations)
+	params.Parallelism = uint8(a.Parallelism)
+	return params
+}
+
+// BreachedPasswords configures the offline HIBP check.
+type BreachedPasswords struct {
+	File      string `yaml:"file"`
+	Threshold int    `yaml:"threshold"`
+}
+
+// ThrottlePolicy is one login throttle (see database.ThrottlePolicy).
+type ThrottlePolicy struct {
+	Threshold int           `yaml:"threshold"`
+	BaseDelay time.Duration `yaml:"base_delay"`
+	MaxDelay  time.Duration `yaml:"max_delay"`
+	Window    time.Duration `yaml:"window"`
+}
+
+// LoginThrottle configures the adaptive login throttling.
+type LoginThrottle struct {
+	User   ThrottlePolicy `yaml:"user"`
+	IP     ThrottlePolicy `yaml:"ip"`
+	UserIP ThrottlePolicy `yaml:"user_ip"`
+}
+
+// Challenge configures the proof-of-work/CAPTCHA challenge.
+type Challenge struct {
+	// Provider is "pow", "hcaptcha", "turnstile" or "recaptcha".
+	Provider  string        `yaml:"provider"`
+	Threshold int           `yaml:"threshold"`
+	Window    time.Duration `yaml:"window"`
+	// Proof-of-work settings; Key signs the challenges (random if empty).
+	Difficulty int           `yaml:"difficulty"`
+	TTL        time.Duration `yaml:"ttl"`
+	Key        Secret        `yaml:"key"`
+	// CAPTCHA settings; VerifyURL defaults to the provider's siteverify endpoint.
+	SiteKey   string `yaml:"site_key"`
+	Secret    Secret `yaml:"secret"`
+	VerifyURL string `yaml:"verify_url"`
+}
+
+// Default returns the built-in configuration.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Host:            "127.0.0.1",
+			Port:            8080,
+			ReadTimeout:     15 * time.Second,
+			WriteTimeout:    15 * time.Second,
+			IdleTimeout:     60 * time.Second,
+			RequestTimeout:  30 * time.Second,
+			MaxBodyBytes:    1024 * 1024,
+			ShutdownTimeout: 20 * time.Second,
+		},
+		Log:      Log{Level: "info", Format: "json"},
+		Language: Language{Default: i18n.English},
+		Docs:     Docs{Enabled: true},
+		TLS: TLS{
+			ReloadInterval: 30 * time.Second,
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			SampleRatio: 1,
+			ServiceName: "foodshop",
+			TrustParent: true,
+		},
+		Database: Database{Path: "./data/foodshop.db", AutoMigrate: true},
+		CORS: CORS{
+			AllowedOrigins: []string{"http://localhost:3000", "http://localhost:8080"},
+		},
+		RateLimit: RateLimit{
+			Store:         "memory",
+			Default:       RateLimitPolicy{Requests: 10, Period: time.Second, Burst: 20},
+			Login:         RateLimitPolicy{Requests: 5, Period: time.Minute},
+			Registration:  RateLimitPolicy{Requests: 3, Period: time.Hour},
+			Authenticated: RateLimitPolicy{Requests: 60, Period: time.Minute, Burst: 20},
+		},
+		Password: Password{
+			History:  5,
+			Policy:   "composition",
+			MinScore: 3,
+			Argon2: Argon2{
+				Memory:      int(passhash.DefaultArgon2Params.Memory),
+				Iterations:  int(passhash.DefaultArgon2Params.Iterations),
+				Parallelism: int(passhash.DefaultArgon2Params.Parallelism),
+			},
+		},
+		BreachedPasswords: BreachedPasswords{Threshold: 1},
+		LoginThrottle: LoginThrottle{
+			User:   ThrottlePolicy{Threshold: 10, BaseDelay: time.Second, MaxDelay: time.Minute, Window: time.Hour},
+			IP:     ThrottlePolicy{Threshold: 20, BaseDelay: time.Second, MaxDelay: 15 * time.Minute, Window: time.Hour},
+			UserIP: ThrottlePolicy{Threshold: 5, BaseDelay: 2 * time.Second, MaxDelay: 15 * time.Minute, Window: time.Hour},
+		},
+		Challenge: Challenge{
+			Provider:   "pow",
+			Threshold:  3,
+			Window:     15 * time.Minute,
+			Difficulty: 20,
+			TTL:        5 * time.Minute,
+		},
+	}
+}
+
+// captchaVerifyURLs are the siteverify endpoints of the supported CAPTCHA providers.
+var captchaVerifyURLs = map[string]string{
+	"hcaptcha":  "https://api.hcaptcha.com/siteverify",
+	"turnstile": "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+	"recaptcha": "https://www.google.com/recaptcha/api/siteverify",
+}
+
+// CaptchaVerifyURL returns the configured or the provider's default siteverify URL.
+func (c Challenge) CaptchaVerifyURL() string {
+	if c.VerifyURL != "" {
+		return c.VerifyURL
+	}
+	return captchaVerifyURLs[c.Provider]
+}
+
+// MinJWTSecretLength is the minimum secret length for HS256 (256 bits).
+const MinJWTSecretLength = 32
+
+// Validate checks the configuration and reports all problems at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Host != "", "server.host must not be empty")
+	check(c.Server.Port > 0 && c.Server.Port <= 65535, "server.port must be between 1 and 65535, got %d", c.Server.Port)
+	check(c.Server.ReadTimeout > 0, "server.read_timeout must be positive")
+	check(c.Server.WriteTimeout > 0, "server.write_timeout must be positive")
+	check(c.Server.IdleTimeout > 0, "server.idle_timeout must be positive")
+	check(c.Server.RequestTimeout > 0, "server.request_timeout must be positive")
+	check(c.Server.MaxBodyBytes > 0, "server.max_body_bytes must be positive")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check(c.Server.ShutdownDelay >= 0, "server.shutdown_delay must not be negative")
+
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		check(false, "log.level must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	check(c.Log.Format == "json" || c.Log.Format == "text", "log.format must be json or text, got %q", c.Log.Format)
+	check(i18n.Supported(c.Language.Default), "language.default must be one of %s, got %q", strings.Join(i18n.Languages(), ", "), c.Language.Default)
+
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls.cert_file and tls.key_file must be set together")
+	check(c.TLS.ReloadInterval > 0, "tls.reload_interval must be positive")
+	switch c.TLS.ClientAuth {
+	case "":
+	case "none":
+		check(c.TLS.ClientCAFile == "", "tls.client_auth none contradicts tls.client_ca_file (leave client_auth empty to require certificates)")
+	case "request", "require":
+		check(c.TLS.ClientCAFile != "", "tls.client_auth %s needs tls.client_ca_file", c.TLS.ClientAuth)
+	default:
+		check(false, "tls.client_auth must be none, request or require, got %q", c.TLS.ClientAuth)
+	}
+	check(c.TLS.ClientCAFile == "" || c.TLS.Enabled(), "tls.client_ca_file needs tls.cert_file")
+	check(len(c.TLS.ClientIdentities) == 0 || c.TLS.ClientCAFile != "", "tls.client_identities needs tls.client_ca_file")
+
+	if c.Metrics.Listen != "" {
+		_, port, err := net.SplitHostPort(c.Metrics.Listen)
+		check(err == nil && port != "", "metrics.listen must be host:port, got %q", c.Metrics.Listen)
+		check(c.Metrics.Listen != c.Server.Addr(), "metrics.listen must differ from the server address")
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		u, err := url.Parse(c.Tracing.Endpoint)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"tracing.endpoint must be an http(s) URL for the otlp exporter, got %q", c.Tracing.Endpoint)
+	case "file":
+		check(c.Tracing.File != "", "tracing.file is required for the file exporter")
+	default:
+		check(false, "tracing.exporter must be none, otlp, stdout or file, got %q", c.Tracing.Exporter)
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+	check(c.Tracing.ServiceName != "", "tracing.service_name must not be empty")
+
+	if err := c.Database.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	check(c.Auth.JWTSecret != "", "auth.jwt_secret is required (JWTSECRET or JWTSECRET_FILE)")
+	check(c.Auth.JWTSecret == "" || len(c.Auth.JWTSecret) >= MinJWTSecretLength,
+		"auth.jwt_secret must be at least %d bytes; shorter secrets were accepted by earlier versions, "+
+			"generate a new one with \"openssl rand -hex 32\" (issued tokens become invalid)", MinJWTSecretLength)
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		check(origin != "*", "cors.allowed_origins must not contain \"*\"")
+	}
+
+	store := string(c.RateLimit.Store)
+	check(store == "memory" || strings.HasPrefix(store, "sqlite:") || strings.HasPrefix(store, "redis://") || strings.HasPrefix(store, "rediss://"),
+		"rate_limit.store must be memory, sqlite:<path>, redis://... or rediss://..., got %q", c.RateLimit.Store)
+	for _, p := range []struct {
+		name string
+		RateLimitPolicy
+	}{
+		{"default", c.RateLimit.Default},
+		{"login", c.RateLimit.Login},
+		{"registration", c.RateLimit.Registration},
+		{"authenticated", c.RateLimit.Authenticated},
+	} {
+		check(p.Requests > 0, "rate_limit.%s.requests must be positive", p.name)
+		check(p.Period > 0, "rate_limit.%s.period must be positive", p.name)
+		check(p.Burst >= 0, "rate_limit.%s.burst must not be negative", p.name)
+	}
+
+	check(c.Password.History >= 0, "password.history must not be negative")
+	check(c.Password.MaxAge >= 0, "password.max_age must not be negative")
+	check(c.Password.Policy == "composition" || c.Password.Policy == "strength" || c.Password.Policy == "both",
+		"password.policy must be composition, strength or both, got %q", c.Password.Policy)
+	check(c.Password.MinScore >= 0 && c.Password.MinScore <= 4, "password.min_score must be between 0 and 4")
+	argon2 := c.Password.Argon2
+	check(argon2.Memory >= 0 && argon2.Memory <= passhash.MaxArgon2Memory,
+		"password.argon2.memory must be at most %d KiB", passhash.MaxArgon2Memory)
+	check(argon2.Iterations >= 1 && argon2.Iterations <= passhash.MaxArgon2Iterations,
+		"password.argon2.iterations must be between 1 and %d", passhash.MaxArgon2Iterations)
+	check(argon2.Parallelism >= 1 && argon2.Parallelism <= passhash.MaxArgon2Parallelism,
+		"password.argon2.parallelism must be between 1 and %d", passhash.MaxArgon2Parallelism)
+	check(argon2.Memory >= 8*argon2.Parallelism, "password.argon2.memory must be at least 8 KiB per lane (parallelism)")
+	check(c.BreachedPasswords.Threshold >= 1, "breached_passwords.threshold must be at least 1")
+
+	for _, p := range []struct {
+		name string
+		ThrottlePolicy
+	}{
+		{"user", c.LoginThrottle.User},
+		{"ip", c.LoginThrottle.IP},
+		{"user_ip", c.LoginThrottle.UserIP},
+	} {
+		// Threshold 0 disables the policy
+		check(p.Threshold >= 0, "login_throttle.%s.threshold must not be negative", p.name)
+		if p.Threshold > 0 {
+			check(p.BaseDelay > 0 && p.MaxDelay >= p.BaseDelay, "login_throttle.%s needs 0 < base_delay <= max_delay", p.name)
+			check(p.Window > 0, "login_throttle.%s.window must be positive", p.name)
+		}
+	}
+
+	check(c.Challenge.Threshold > 0, "challenge.threshold must be positive")
+	check(c.Challenge.Window > 0, "challenge.window must be positive")
+	switch c.Challenge.Provider {
+	case "pow":
+		check(c.Challenge.Difficulty >= 1 && c.Challenge.Difficulty <= 32, "challenge.difficulty must be between 1 and 32")
+		check(c.Challenge.TTL > 0, "challenge.ttl must be positive")
+	case "hcaptcha", "turnstile", "recaptcha":
+		check(c.Challenge.SiteKey != "", "challenge.site_key is required for %s", c.Challenge.Provider)
+		check(c.Challenge.Secret != "", "challenge.secret is required for %s", c.Challenge.Provider)
+	default:
+		check(false, "challenge.provider must be pow, hcaptcha, turnstile or recaptcha, got %q", c.Challenge.Provider)
+	}
+
+	return errors.Join(errs...)
+}
+
+// String returns the configuration as YAML with secrets redacted.
+func (c *Config) String() string {
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("config: %v", err)
+	}
+	return string(out)
+}
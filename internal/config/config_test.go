@@ -0,0 +1,329 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"foodshop/internal/passhash"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+// env returns a getenv function backed by a map.
+func env(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load(nil, env(map[string]string{"JWTSECRET": testSecret}))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if cfg.Server.Addr() != "127.0.0.1:8080" {
+		t.Errorf("Addr() = %q, want 127.0.0.1:8080", cfg.Server.Addr())
+	}
+	if string(cfg.Auth.JWTSecret) != testSecret {
+		t.Errorf("JWTSecret not loaded from JWTSECRET")
+	}
+	if cfg.RateLimit.Login.Requests != 5 || cfg.Challenge.Provider != "pow" {
+		t.Errorf("Unexpected defaults: %+v", cfg)
+	}
+	if cfg.Password.Argon2.Params() != passhash.DefaultArgon2Params {
+		t.Errorf("Argon2 params = %+v, want %+v", cfg.Password.Argon2.Params(), passhash.DefaultArgon2Params)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+server:
+  host: 0.0.0.0
+  port: 9000
+  read_timeout: 5s
+database:
+  path: /var/lib/foodshop/foodshop.db
+cors:
+  allowed_origins: [https://shop.example.com]
+rate_limit:
+  login:
+    requests: 3
+    period: 2m
+`)
+
+	cfg, err := Load(
+		[]string{"-config", file, "-server.port", "9100", "-rate_limit.login.requests=4"},
+		env(map[string]string{
+			"JWTSECRET":        testSecret,
+			"SERVER_PORT":      "9050",
+			"SERVER_HOST":      "10.0.0.1",
+			"TRUSTED_PROXIES":  "10.0.0.0/8, 192.0.2.10",
+			"PASSWORD_MAX_AGE": "2160h",
+		}),
+	)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"flag beats env and file", cfg.Server.Port, 9100},
+		{"env beats file", cfg.Server.Host, "10.0.0.1"},
+		{"file beats default", cfg.Server.ReadTimeout, 5 * time.Second},
+		{"default kept", cfg.Server.WriteTimeout, 15 * time.Second},
+		{"file path", cfg.Database.Path, "/var/lib/foodshop/foodshop.db"},
+		{"file list", strings.Join(cfg.CORS.AllowedOrigins, ","), "https://shop.example.com"},
+		{"env list", strings.Join(cfg.TrustedProxies, ","), "10.0.0.0/8,192.0.2.10"},
+		{"env duration", cfg.Password.MaxAge, 2160 * time.Hour},
+		{"nested flag", cfg.RateLimit.Login.Requests, 4},
+		{"nested file", cfg.RateLimit.Login.Period, 2 * time.Minute},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadSecretFile(t *testing.T) {
+	secretFile := writeFile(t, "jwt", testSecret+"\n")
+
+	cfg, err := Load(nil, env(map[string]string{"JWTSECRET_FILE": secretFile}))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if string(cfg.Auth.JWTSecret) != testSecret {
+		t.Errorf("JWTSecret = %q, want content of JWTSECRET_FILE without newline", string(cfg.Auth.JWTSecret))
+	}
+
+	// Both variants set is ambiguous
+	_, err = Load(nil, env(map[string]string{"JWTSECRET": testSecret, "JWTSECRET_FILE": secretFile}))
+	if err == nil {
+		t.Error("Load() with JWTSECRET and JWTSECRET_FILE succeeded, want error")
+	}
+}
+
+func TestLoadDSNFile(t *testing.T) {
+	dsn := "postgres://foodshop:geheim@db:5432/foodshop"
+	dsnFile := writeFile(t, "database-dsn", dsn+"\n")
+
+	cfg, err := Load(nil, env(map[string]string{"JWTSECRET": testSecret, "DATABASE_DSN_FILE": dsnFile}))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if string(cfg.Database.DSN) != dsn {
+		t.Errorf("Database.DSN = %q, want content of DATABASE_DSN_FILE", string(cfg.Database.DSN))
+	}
+
+	_, err = Load(nil, env(map[string]string{"JWTSECRET": testSecret, "DATABASE_DSN": dsn, "DATABASE_DSN_FILE": dsnFile}))
+	if err == nil {
+		t.Error("Load() with DATABASE_DSN and DATABASE_DSN_FILE succeeded, want error")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		vars    map[string]string
+		wantErr string
+	}{
+		{"Missing JWT secret", nil, nil, "auth.jwt_secret is required"},
+		{"Short JWT secret", nil, map[string]string{"JWTSECRET": "short"}, "at least 32 bytes"},
+		{"Invalid env number", nil, map[string]string{"JWTSECRET": testSecret, "SERVER_PORT": "http"}, "invalid SERVER_PORT"},
+		{"Invalid flag duration", []string{"-server.read_timeout", "5"}, map[string]string{"JWTSECRET": testSecret}, "invalid -server.read_timeout"},
+		{"Negative shutdown delay", []string{"-server.shutdown_delay", "-1s"}, map[string]string{"JWTSECRET": testSecret}, "server.shutdown_delay"},
+		{"Port out of range", []string{"-server.port", "70000"}, map[string]string{"JWTSECRET": testSecret}, "server.port must be between"},
+		{"Wildcard CORS", nil, map[string]string{"JWTSECRET": testSecret, "CORS_ALLOWED_ORIGINS": "*"}, "must not contain"},
+		{"Unknown policy", nil, map[string]string{"JWTSECRET": testSecret, "PASSWORD_POLICY": "weak"}, "password.policy"},
+		{"CAPTCHA without keys", nil, map[string]string{"JWTSECRET": testSecret, "CHALLENGE_PROVIDER": "turnstile"}, "challenge.site_key is required"},
+		{"Sample ratio out of range", nil, map[string]string{"JWTSECRET": testSecret, "TRACING_SAMPLE_RATIO": "1.5"}, "tracing.sample_ratio"},
+		{"Invalid sample ratio", []string{"-tracing.sample_ratio", "half"}, map[string]string{"JWTSECRET": testSecret}, "invalid -tracing.sample_ratio"},
+		{"OTLP without endpoint", nil, map[string]string{"JWTSECRET": testSecret, "TRACING_EXPORTER": "otlp"}, "tracing.endpoint"},
+		{"Unknown log level", nil, map[string]string{"JWTSECRET": testSecret, "LOG_LEVEL": "verbose"}, "log.level"},
+		{"Unsupported language", nil, map[string]string{"JWTSECRET": testSecret, "LANGUAGE_DEFAULT": "fr"}, "language.default"},
+		{"Unknown store", nil, map[string]string{"JWTSECRET": testSecret, "RATE_LIMIT_STORE": "memcached://x"}, "rate_limit.store"},
+		{"Unknown database", nil, map[string]string{"JWTSECRET": testSecret, "DATABASE_DSN": "mysql://foodshop:geheim@db/foodshop"}, "database.dsn must be a PostgreSQL"},
+		{"Invalid database keyword", nil, map[string]string{"JWTSECRET": testSecret, "DATABASE_DSN": "host=db password=geheim port=abc"}, "database.dsn must be a PostgreSQL"},
+		{"Argon2 without lanes", nil, map[string]string{"JWTSECRET": testSecret, "PASSWORD_ARGON2_PARALLELISM": "0"}, "password.argon2.parallelism"},
+		{"Argon2 memory too large", nil, map[string]string{"JWTSECRET": testSecret, "PASSWORD_ARGON2_MEMORY": "4294967296"}, "password.argon2.memory"},
+		{"Argon2 memory per lane", []string{"-password.argon2.memory", "16", "-password.argon2.parallelism", "4"}, map[string]string{"JWTSECRET": testSecret}, "8 KiB per lane"},
+		{"Negative throttle threshold", nil, map[string]string{"JWTSECRET": testSecret, "LOGIN_THROTTLE_USER_THRESHOLD": "-1"}, "login_throttle.user.threshold must not be negative"},
+		{"Unknown flag", []string{"-nope"}, map[string]string{"JWTSECRET": testSecret}, "flag provided but not defined"},
+		{"Missing config file", []string{"-config", "/does/not/exist.yaml"}, map[string]string{"JWTSECRET": testSecret}, "read config file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.args, env(tt.vars))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load() error = %v, want error containing %q", err, tt.wantErr)
+			}
+			if err != nil && strings.Contains(err.Error(), "geheim") {
+				t.Errorf("Load() error contains a password: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadDisabledThrottlePolicy(t *testing.T) {
+	cfg, err := Load(nil, env(map[string]string{
+		"JWTSECRET":                        testSecret,
+		"LOGIN_THROTTLE_USER_THRESHOLD":    "0",
+		"LOGIN_THROTTLE_USER_BASE_DELAY":   "0s",
+		"LOGIN_THROTTLE_USER_IP_THRESHOLD": "0",
+	}))
+	if err != nil {
+		t.Fatalf("Load() with threshold 0 failed: %v", err)
+	}
+	if cfg.LoginThrottle.User.Threshold != 0 || cfg.LoginThrottle.UserIP.Threshold != 0 {
+		t.Errorf("LoginThrottle = %+v, want user and user_ip disabled", cfg.LoginThrottle)
+	}
+}
+
+func TestLoadUnknownFileKey(t *testing.T) {
+	file := writeFile(t, "config.yaml", "server:\n  prot: 9000\n")
+	_, err := Load([]string{"-config", file}, env(map[string]string{"JWTSECRET": testSecret}))
+	if err == nil || !strings.Contains(err.Error(), "prot") {
+		t.Errorf("Load() error = %v, want unknown field error", err)
+	}
+}
+
+func TestConfigStringRedactsSecrets(t *testing.T) {
+	cfg, err := Load(nil, env(map[string]string{
+		"JWTSECRET":        testSecret,
+		"PASSWORD_PEPPER":  "pepper-value",
+		"RATE_LIMIT_STORE": "rediss://:redis-password@cache:6379/0",
+		"DATABASE_DSN":     "postgres://foodshop:db-password@db:5432/foodshop",
+	}))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	out := cfg.String()
+	for _, secret := range []string{testSecret, "pepper-value", "redis-password", "db-password"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("Effective config contains secret %q:\n%s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "[REDACTED]") || !strings.Contains(out, "cache:6379") || !strings.Contains(out, "db:5432") {
+		t.Errorf("Effective config missing redacted values:\n%s", out)
+	}
+}
+
+func TestLoadPostgresDSN(t *testing.T) {
+	for _, dsn := range []string{
+		"postgres://foodshop:geheim@db:5432/foodshop?sslmode=disable",
+		"postgresql:///foodshop?host=/run/postgresql",
+		"host=/run/postgresql dbname=foodshop user=foodshop",
+	} {
+		cfg, err := Load(nil, env(map[string]string{"JWTSECRET": testSecret, "DATABASE_DSN": dsn}))
+		if err != nil {
+			t.Errorf("Load() with DATABASE_DSN %q failed: %v", dsn, err)
+			continue
+		}
+		if !cfg.Database.Postgres() {
+			t.Errorf("DATABASE_DSN %q does not select PostgreSQL", dsn)
+		}
+	}
+}
+
+func TestDSNString(t *testing.T) {
+	tests := []struct {
+		name string
+		dsn  DSN
+		want string
+	}{
+		{"URL user info", "postgres://foodshop:geheim@db:5432/foodshop", "postgres://foodshop:xxxxx@db:5432/foodshop"},
+		{"URL query", "postgres://db/foodshop?user=foodshop&password=geheim", "postgres://db/foodshop?password=xxxxx&user=foodshop"},
+		{"URL without password", "redis://cache:6379/0", "redis://cache:6379/0"},
+		{"Keyword/value", "host=db user=foodshop password=geheim dbname=foodshop", "host=db user=foodshop password=xxxxx dbname=foodshop"},
+		{"Quoted keyword", "password = 'ge heim' host=db", "password=xxxxx host=db"},
+		{"SSL key password", "host=db sslpassword=geheim", "host=db sslpassword=xxxxx"},
+		{"Unparsable URL", "postgres://foodshop:geheim@db:port/%zz", "[REDACTED]"},
+		{"Plain value", "memory", "memory"},
+		{"SQLite path", "sqlite:./data/ratelimit.db", "sqlite:./data/ratelimit.db"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.dsn.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	cfg, err := Load([]string{"-config", "../../config.example.yaml"}, env(map[string]string{"JWTSECRET": testSecret}))
+	if err != nil {
+		t.Fatalf("config.example.yaml is invalid: %v", err)
+	}
+	if cfg.Server.Port != 8080 {
+		t.Errorf("Port = %d, want 8080", cfg.Server.Port)
+	}
+}
+
+func TestLoadTLS(t *testing.T) {
+	cfg, err := Load(nil, env(map[string]string{
+		"JWTSECRET":             testSecret,
+		"TLS_CERT_FILE":         "/etc/foodshop/cert.pem",
+		"TLS_KEY_FILE":          "/etc/foodshop/key.pem",
+		"TLS_CLIENT_CA_FILE":    "/etc/foodshop/ca.pem",
+		"TLS_CLIENT_AUTH":       "request",
+		"TLS_CLIENT_IDENTITIES": "CN=billing,O=Foodshop=billing; CN=reporting=reports",
+	}))
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if !cfg.TLS.Enabled() {
+		t.Error("TLS not enabled")
+	}
+	want := map[string]string{"CN=billing,O=Foodshop": "billing", "CN=reporting": "reports"}
+	if len(cfg.TLS.ClientIdentities) != len(want) {
+		t.Fatalf("ClientIdentities = %v, want %v", cfg.TLS.ClientIdentities, want)
+	}
+	for subject, identity := range want {
+		if cfg.TLS.ClientIdentities[subject] != identity {
+			t.Errorf("ClientIdentities[%q] = %q, want %q", subject, cfg.TLS.ClientIdentities[subject], identity)
+		}
+	}
+
+	_, err = Load(nil, env(map[string]string{"JWTSECRET": testSecret, "TLS_CERT_FILE": "/etc/foodshop/cert.pem"}))
+	if err == nil || !strings.Contains(err.Error(), "must be set together") {
+		t.Errorf("Load() error = %v, want cert/key error", err)
+	}
+	_, err = Load(nil, env(map[string]string{"JWTSECRET": testSecret, "TLS_CLIENT_AUTH": "require"}))
+	if err == nil || !strings.Contains(err.Error(), "needs tls.client_ca_file") {
+		t.Errorf("Load() error = %v, want client CA error", err)
+	}
+
+	// Only an empty client_auth defaults to require with a CA file
+	mtls := map[string]string{
+		"JWTSECRET":          testSecret,
+		"TLS_CERT_FILE":      "/etc/foodshop/cert.pem",
+		"TLS_KEY_FILE":       "/etc/foodshop/key.pem",
+		"TLS_CLIENT_CA_FILE": "/etc/foodshop/ca.pem",
+	}
+	if _, err := Load(nil, env(mtls)); err != nil {
+		t.Errorf("Load() with CA file and empty client_auth failed: %v", err)
+	}
+	mtls["TLS_CLIENT_AUTH"] = "none"
+	_, err = Load(nil, env(mtls))
+	if err == nil || !strings.Contains(err.Error(), "contradicts tls.client_ca_file") {
+		t.Errorf("Load() error = %v, want none/CA conflict", err)
+	}
+}
@@ -0,0 +1,241 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileEnv names the config file if -config is not given.
+const ConfigFileEnv = "CONFIG_FILE"
+
+// setting is one leaf of the Config struct.
+type setting struct {
+	path   string // e.g. "server.port" (also the flag name)
+	env    string // e.g. "SERVER_PORT"
+	secret bool   // Secret or DSN, may also be read from <ENV>_FILE
+	value  reflect.Value
+}
+
+// Load builds the configuration from defaults, the config file, the
+// environment (getenv, usually os.Getenv) and the command line args
+// (without the program name), then validates it.
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	cfg, rest, err := merge(args, getenv)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", rest)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// LoadDatabase loads the configuration like Load for commands that only
+// need the database (migrate). It returns the arguments after the flags;
+// other sections are not validated, so no JWT secret is required.
+func LoadDatabase(args []string, getenv func(string) string) (Database, []string, error) {
+	cfg, rest, err := merge(args, getenv)
+	if err != nil {
+		return Database{}, nil, err
+	}
+	if err := cfg.Database.validate(); err != nil {
+		return Database{}, nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg.Database, rest, nil
+}
+
+// merge applies the config file, environment and flags to the defaults and
+// returns the remaining positional arguments.
+func merge(args []string, getenv func(string) string) (*Config, []string, error) {
+	cfg := Default()
+	settings := collect(reflect.ValueOf(cfg).Elem(), "", "")
+
+	// Flags are parsed first (to find -config) but applied last
+	fs := flag.NewFlagSet("foodshop", flag.ContinueOnError)
+	configFile := fs.String("config", getenv(ConfigFileEnv), "YAML config file (env "+ConfigFileEnv+")")
+	type flagValue struct {
+		s   setting
+		raw string
+	}
+	var flagValues []flagValue
+	for _, s := range settings {
+		s := s
+		usage := "env " + s.env
+		if s.secret {
+			usage += " or " + s.env + "_FILE"
+		}
+		fs.Func(s.path, usage, func(raw string) error {
+			flagValues = append(flagValues, flagValue{s, raw})
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(cfg, *configFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		raw, ok, err := lookupEnv(s, getenv)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			continue
+		}
+		if err := set(s.value, raw); err != nil {
+			return nil, nil, fmt.Errorf("invalid %s: %w", s.env, err)
+		}
+	}
+
+	for _, fv := range flagValues {
+		if err := set(fv.s.value, fv.raw); err != nil {
+			return nil, nil, fmt.Errorf("invalid -%s: %w", fv.s.path, err)
+		}
+	}
+
+	return cfg, fs.Args(), nil
+}
+
+// loadFile merges a YAML file into cfg. Unknown keys are an error, so typos
+// don't silently fall back to defaults.
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// lookupEnv returns the value of the setting's environment variable. For
+// secrets and DSNs <ENV>_FILE is read as well; setting both is an error.
+func lookupEnv(s setting, getenv func(string) string) (string, bool, error) {
+	raw := getenv(s.env)
+	if s.secret {
+		if path := getenv(s.env + "_FILE"); path != "" {
+			if raw != "" {
+				return "", false, fmt.Errorf("both %s and %s_FILE are set", s.env, s.env)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return "", false, fmt.Errorf("read %s_FILE: %w", s.env, err)
+			}
+			return strings.TrimSpace(string(data)), true, nil
+		}
+	}
+	return raw, raw != "", nil
+}
+
+// collect walks the struct and returns its leaves.
+func collect(v reflect.Value, path, env string) []setting {
+	var settings []setting
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		p, e := name, strings.ToUpper(name)
+		if path != "" {
+			p, e = path+"."+name, env+"_"+e
+		}
+		if tag := f.Tag.Get("env"); tag != "" {
+			e = tag
+		}
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			settings = append(settings, collect(fv, p, e)...)
+			continue
+		}
+		settings = append(settings, setting{
+			path:   p,
+			env:    e,
+			secret: f.Type == reflect.TypeOf(Secret("")) || f.Type == reflect.TypeOf(DSN("")),
+			value:  fv,
+		})
+	}
+	return settings
+}
+
+// set parses raw into v. Lists are comma-separated, maps are
+// "key=value;key=value" (split at the last "=", so keys may contain "=").
+func set(v reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		items := make(map[string]string)
+		for _, item := range strings.Split(raw, ";") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			i := strings.LastIndex(item, "=")
+			if i <= 0 {
+				return fmt.Errorf("%q is not key=value", item)
+			}
+			items[strings.TrimSpace(item[:i])] = strings.TrimSpace(item[i+1:])
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
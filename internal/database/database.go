@@ -1,12 +1,24 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-
-	_ "github.com/mattn/go-sqlite3"
+	"foodshop/internal/migrate"
+	"foodshop/internal/passhash"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracer starts the spans of the repository methods and password hashing.
+var tracer = otel.Tracer("foodshop/internal/database")
+
 // Repository defines the minimal interface the rest of the app depends on.
 // This keeps the implementation swappable (sqlite, postgres, in-memory, ...).
 type Repository interface {
@@ -16,15 +28,63 @@ type Repository interface {
 	Close() error
 }
 
+// Backend is a SQL database backend as used by main: the Store plus
+// connection, settings and schema management. Sqlite and Postgres
+// implement it.
+type Backend interface {
+	Repository
+	Store
+
+	SetPasswordHasher(h passhash.Hasher)
+	SetPepper(p *passhash.Pepper)
+	SetPasswordHistory(n int)
+	SetMaxPasswordAge(d time.Duration)
+	SetLoginThrottle(cfg LoginThrottle)
+
+	// Migrator returns the migrator for the backend's schema.
+	Migrator(ctx context.Context) (*migrate.Migrator, error)
+	// InitSchema applies all pending migrations.
+	InitSchema() error
+	// CurrentSchemaVersion returns the highest applied migration.
+	CurrentSchemaVersion(ctx context.Context) (int, error)
+}
+
+// policy holds the password and login throttle settings. It is embedded
+// in every backend, so they all share the setters and hashing rules.
+type policy struct {
+	hasher passhash.Hasher
+	pepper *passhash.Pepper
+
+	// Password history and expiry (see SetPasswordHistory, SetMaxPasswordAge)
+	passwordHistory int
+	maxPasswordAge  time.Duration
+
+	// Login throttling (see SetLoginThrottle)
+	throttle LoginThrottle
+}
+
+func defaultPolicy() policy {
+	return policy{
+		hasher:          passhash.Default(),
+		passwordHistory: DefaultPasswordHistory,
+		throttle:        DefaultLoginThrottle,
+	}
+}
+
 // Sqlite is a simple wrapper around *sql.DB for sqlite3.
 type Sqlite struct {
-	db *sql.DB
+	policy
+	db     *sql.DB
+	pruner *pruner
+
+	// Parent for trace spans (see WithContext)
+	ctx context.Context
 }
 
 // New opens (or creates) a sqlite database at the provided path and returns
-// a Repository. If the path is empty an error is returned.
+// a Backend. If the path is empty an error is returned.
 // The sqlite3 driver will create the file if it does not exist.
-func New(path string) (Repository, error) {
+func New(path string) (Backend, error) {
 	if path == "" {
 		return nil, fmt.Errorf("database path must not be empty")
 	}
@@ -43,50 +103,76 @@ func New(path string) (Repository, error) {
 	// Small sqlite tuning: single writer allowed.
 	db.SetMaxOpenConns(1)
 
-	return &Sqlite{db: db}, nil
+	s := &Sqlite{policy: defaultPolicy(), db: db}
+	s.pruner = startPruner(s.PruneLoginThrottle)
+	return s, nil
 }
 
 // DB returns the underlying *sql.DB.
 func (s *Sqlite) DB() *sql.DB { return s.db }
 
-// Close closes the database connection.
-func (s *Sqlite) Close() error { return s.db.Close() }
-
-// InitSchema creates the initial database schema.
-// Call this after New() to set up tables if they don't exist.
-func (s *Sqlite) InitSchema() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		username TEXT NOT NULL UNIQUE,
-		password TEXT NOT NULL,
-		email TEXT,
-		is_active BOOLEAN NOT NULL DEFAULT 1,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		deactived_at DATETIME,
-		failed_login_attempts INTEGER DEFAULT 0,
-		locked_until DATETIME
-	);
-	
-	CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
-	CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
-	`
-
-	_, err := s.db.Exec(schema)
-	if err != nil {
-		return fmt.Errorf("init schema: %w", err)
-	}
+// Close stops the throttle pruning and closes the database connection.
+func (s *Sqlite) Close() error {
+	s.pruner.Close()
+	return s.db.Close()
+}
+
+// Running reports whether the background pruning of the login throttle runs.
+func (s *Sqlite) Running() bool { return s.pruner.Running() }
+
+// WithContext returns a copy of s whose spans are children of the span in
+// ctx, typically the request context. Only the trace is taken from ctx:
+// queries are not canceled with it, so a client hanging up cannot abort
+// e.g. recording a failed login.
+func (s *Sqlite) WithContext(ctx context.Context) Store { return s.withContext(ctx) }
 
-	// Add columns to existing tables if they don't exist (migration)
-	migrations := []string{
-		`ALTER TABLE users ADD COLUMN failed_login_attempts INTEGER DEFAULT 0`,
-		`ALTER TABLE users ADD COLUMN locked_until DATETIME`,
+func (s *Sqlite) withContext(ctx context.Context) *Sqlite {
+	c := *s
+	c.ctx = ctx
+	return &c
+}
+
+// isSqliteUniqueViolation reports whether err is a UNIQUE constraint failure.
+func isSqliteUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+func (s *Sqlite) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
 	}
+	return s.ctx
+}
 
-	for _, migration := range migrations {
-		// Ignore errors if column already exists
-		s.db.Exec(migration)
+// trace starts the span for a repository method and returns a copy of s
+// bound to it, so nested calls become child spans. The span includes the
+// wait for the single connection.
+func (s *Sqlite) trace(method string) (*Sqlite, trace.Span) {
+	ctx, span := tracer.Start(s.context(), "db."+method, trace.WithAttributes(
+		attribute.String("db.system", "sqlite"),
+		attribute.String("db.operation", method),
+	))
+	if !span.IsRecording() {
+		return s, span
 	}
+	return s.withContext(ctx), span
+}
 
-	return nil
+// finishSpan records *errp (if any) and ends the span; for use with named
+// results:
+//
+//	defer finishSpan(span, &err)
+func finishSpan(span trace.Span, errp *error) {
+	if err := *errp; err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
 }
+
+// SetPasswordHasher replaces the password hasher (default: argon2id with bcrypt fallback).
+func (p *policy) SetPasswordHasher(h passhash.Hasher) { p.hasher = h }
+
+// SetPepper enables the server-side password pepper (nil disables it for new hashes).
+func (p *policy) SetPepper(pepper *passhash.Pepper) { p.pepper = pepper }
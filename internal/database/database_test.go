@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
@@ -54,11 +55,19 @@ func TestInitSchema(t *testing.T) {
 		t.Fatal("Repository is not *Sqlite")
 	}
 
+	if version, err := sqliteRepo.CurrentSchemaVersion(context.Background()); err != nil || version != 0 {
+		t.Errorf("CurrentSchemaVersion() before InitSchema = %d, %v, want 0", version, err)
+	}
+
 	// Initialize the schema
 	if err := sqliteRepo.InitSchema(); err != nil {
 		t.Fatalf("InitSchema() failed: %v", err)
 	}
 
+	if version, err := sqliteRepo.CurrentSchemaVersion(context.Background()); err != nil || version != SchemaVersion {
+		t.Errorf("CurrentSchemaVersion() = %d, %v, want %d", version, err, SchemaVersion)
+	}
+
 	// Verify the users table exists by querying sqlite_master
 	var tableName string
 	query := "SELECT name FROM sqlite_master WHERE type='table' AND name='users'"
@@ -0,0 +1,411 @@
+package database
+
+import (
+	"context"
+	"foodshop/internal/models"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Memory is a thread-safe in-memory Store. It follows the same rules as
+// Sqlite (hashing, password history, lockout, throttling) and is meant for
+// tests and local development; all data is lost on exit.
+type Memory struct {
+	policy
+
+	mu       sync.Mutex
+	nextID   int64
+	users    map[int64]*models.User
+	byName   map[string]int64
+	history  map[int64][]storedHash // newest last
+	throttle map[string]*throttleEntry
+	// nextPrune is when RecordLoginFailure scans the throttle for stale
+	// entries again (at most once per throttlePruneInterval, so a flood of
+	// failures does not cost O(n) each under the lock)
+	nextPrune time.Time
+}
+
+// throttleEntry is a row of the login throttle.
+type throttleEntry struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// NewMemory returns an empty in-memory Store with the default policy.
+func NewMemory() *Memory {
+	return &Memory{
+		policy:   defaultPolicy(),
+		users:    make(map[int64]*models.User),
+		byName:   make(map[string]int64),
+		history:  make(map[int64][]storedHash),
+		throttle: make(map[string]*throttleEntry),
+	}
+}
+
+// WithContext returns m; the in-memory store creates no spans.
+func (m *Memory) WithContext(ctx context.Context) Store { return m }
+
+// lookup returns the stored user; m.mu must be held.
+func (m *Memory) lookup(username string) (*models.User, bool) {
+	id, ok := m.byName[username]
+	if !ok {
+		return nil, false
+	}
+	return m.users[id], true
+}
+
+// clone returns a copy, so callers cannot modify the stored user.
+func clone(user *models.User) *models.User {
+	c := *user
+	return &c
+}
+
+// CreateUser creates a new user with hashed password.
+func (m *Memory) CreateUser(username, password, email string) (*models.User, error) {
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	// Hash outside the lock, it is slow on purpose
+	hash, pepperVersion, err := m.hashPassword(context.Background(), password)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.byName[username]; ok {
+		return nil, ErrUserExists
+	}
+	m.nextID++
+	now := time.Now()
+	user := &models.User{
+		ID:                m.nextID,
+		Username:          username,
+		Password:          hash,
+		Email:             email,
+		IsActive:          true,
+		CreatedAt:         now,
+		PepperVersion:     pepperVersion,
+		PasswordChangedAt: &now,
+	}
+	m.users[user.ID] = user
+	m.byName[username] = user.ID
+	return clone(user), nil
+}
+
+// UpdateUser changes the password (optional) and email of a user. A new
+// password must not match the current or a recent one (ErrPasswordReused).
+func (m *Memory) UpdateUser(username, password, email string) (*models.User, error) {
+	user, err := m.GetUserByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if password == "" {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		stored, ok := m.lookup(username)
+		if !ok {
+			return nil, ErrUserNotFound
+		}
+		stored.Email = email
+		return clone(stored), nil
+	}
+
+	m.mu.Lock()
+	hashes := []storedHash{{user.Password, user.PepperVersion}}
+	history := m.history[user.ID]
+	for i := len(history) - 1; i >= 0 && len(hashes) <= m.passwordHistory; i-- {
+		hashes = append(hashes, history[i])
+	}
+	m.mu.Unlock()
+
+	reused, err := m.matchesAny(password, hashes)
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		return nil, ErrPasswordReused
+	}
+
+	hash, pepperVersion, err := m.hashPassword(context.Background(), password)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.lookup(username)
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+
+	history = m.history[stored.ID]
+	if m.passwordHistory > 0 {
+		history = append(history, storedHash{stored.Password, stored.PepperVersion})
+	}
+	if len(history) > m.passwordHistory {
+		history = history[len(history)-m.passwordHistory:]
+	}
+	m.history[stored.ID] = history
+
+	now := time.Now()
+	stored.Password = hash
+	stored.PepperVersion = pepperVersion
+	stored.Email = email
+	stored.PasswordChangedAt = &now
+	return clone(stored), nil
+}
+
+// GetUserByUsername retrieves a user by username.
+func (m *Memory) GetUserByUsername(username string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.lookup(username)
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return clone(user), nil
+}
+
+// GetUserByID retrieves a user by ID.
+func (m *Memory) GetUserByID(id int64) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return clone(user), nil
+}
+
+// DeleteUser permanently deletes a user and its password history.
+func (m *Memory) DeleteUser(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	delete(m.byName, user.Username)
+	delete(m.users, id)
+	delete(m.history, id)
+	return nil
+}
+
+// SetLanguage stores the preferred language of a user ("" = negotiate
+// from Accept-Language).
+func (m *Memory) SetLanguage(id int64, language string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.Language = language
+	return nil
+}
+
+// DeactivateUser soft-deletes an active user.
+func (m *Memory) DeactivateUser(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[id]
+	if !ok || !user.IsActive {
+		return ErrUserNotFound
+	}
+	now := time.Now()
+	user.IsActive = false
+	user.DeactivedAt = &now
+	return nil
+}
+
+// ActivateUser reactivates a deactivated user.
+func (m *Memory) ActivateUser(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[id]
+	if !ok || user.IsActive {
+		return ErrUserNotFound
+	}
+	user.IsActive = true
+	user.DeactivedAt = nil
+	return nil
+}
+
+// VerifyPassword checks the password and returns the user if it matches.
+// Outdated hashes are upgraded like in Sqlite.VerifyPassword.
+func (m *Memory) VerifyPassword(username, password string) (*models.User, error) {
+	user, err := m.GetUserByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsActive {
+		return nil, ErrInvalidCredentials
+	}
+	if err := m.checkPassword(context.Background(), user, password); err != nil {
+		return nil, err
+	}
+
+	if m.needsRehash(user) {
+		hash, version, err := m.hashPassword(context.Background(), password)
+		if err != nil {
+			slog.Warn("Password rehash failed", "user_id", user.ID, "error", err)
+			return user, nil
+		}
+		m.mu.Lock()
+		// Only replace the hash we verified against, in case it changed concurrently
+		if stored, ok := m.users[user.ID]; ok && stored.Password == user.Password {
+			stored.Password, stored.PepperVersion = hash, version
+			user.Password, user.PepperVersion = hash, version
+		}
+		m.mu.Unlock()
+	}
+	return user, nil
+}
+
+// IsAccountLocked checks if a user account is currently locked. An expired
+// lock is reset.
+func (m *Memory) IsAccountLocked(username string) (bool, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.lookup(username)
+	if !ok {
+		return false, time.Time{}, ErrUserNotFound
+	}
+	if user.LockedUntil == nil {
+		return false, time.Time{}, nil
+	}
+	if time.Now().After(*user.LockedUntil) {
+		user.FailedLoginAttempts = 0
+		user.LockedUntil = nil
+		return false, time.Time{}, nil
+	}
+	return true, *user.LockedUntil, nil
+}
+
+// IncrementFailedAttempts increments the failed login counter for a user.
+func (m *Memory) IncrementFailedAttempts(username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if user, ok := m.lookup(username); ok {
+		user.FailedLoginAttempts++
+	}
+	return nil
+}
+
+// LockAccount locks a user account for the specified duration.
+func (m *Memory) LockAccount(username string, duration time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.lookup(username)
+	if !ok {
+		return ErrUserNotFound
+	}
+	lockUntil := time.Now().Add(duration)
+	user.LockedUntil = &lockUntil
+	return nil
+}
+
+// ResetFailedAttempts resets the failed login counter to 0.
+func (m *Memory) ResetFailedAttempts(username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if user, ok := m.lookup(username); ok {
+		user.FailedLoginAttempts = 0
+		user.LockedUntil = nil
+	}
+	return nil
+}
+
+// UnlockAccount unlocks a user account and resets failed attempts.
+func (m *Memory) UnlockAccount(username string) error {
+	return m.ResetFailedAttempts(username)
+}
+
+// GetFailedAttempts returns the number of failed login attempts for a user.
+func (m *Memory) GetFailedAttempts(username string) (int, error) {
+	user, err := m.GetUserByUsername(username)
+	if err != nil {
+		return 0, err
+	}
+	return user.FailedLoginAttempts, nil
+}
+
+// CheckLoginThrottle returns how long the client has to wait before the
+// next login attempt for username is allowed (0 if it is allowed now).
+func (m *Memory) CheckLoginThrottle(username, ip string) (time.Duration, error) {
+	keys, _ := m.throttleKeys(username, ip)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var wait time.Duration
+	for _, key := range keys {
+		if e, ok := m.throttle[key]; ok {
+			wait = max(wait, time.Until(e.blockedUntil))
+		}
+	}
+	return wait, nil
+}
+
+// RecordLoginFailure counts a failed login for all keys and returns the
+// resulting delay before the next attempt (0 if none).
+func (m *Memory) RecordLoginFailure(username, ip string) (time.Duration, error) {
+	keys, policies := m.throttleKeys(username, ip)
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var longest time.Duration
+	for i, key := range keys {
+		policy := policies[i]
+		if policy.Threshold <= 0 {
+			continue
+		}
+
+		// Failures outside the window start a new count
+		e, ok := m.throttle[key]
+		if !ok {
+			e = &throttleEntry{}
+			m.throttle[key] = e
+		}
+		if e.lastFailure.Before(now.Add(-policy.Window)) {
+			e.failures = 0
+		}
+		e.failures++
+		e.lastFailure = now
+
+		if d := policy.delay(e.failures); d > 0 {
+			e.blockedUntil = now.Add(d)
+			longest = max(longest, d)
+		}
+	}
+
+	// Forget stale entries that are neither recent nor blocked
+	if now.After(m.nextPrune) {
+		m.nextPrune = now.Add(throttlePruneInterval)
+		stale := now.Add(-m.maxThrottleWindow())
+		for key, e := range m.throttle {
+			if e.lastFailure.Before(stale) && e.blockedUntil.Before(now) {
+				delete(m.throttle, key)
+			}
+		}
+	}
+	return longest, nil
+}
+
+// ResetLoginFailures clears the (username, IP) counter after a successful
+// login. The username and IP counters are kept.
+func (m *Memory) ResetLoginFailures(username, ip string) error {
+	keys, _ := m.throttleKeys(username, ip)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.throttle, keys[2])
+	return nil
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"foodshop/internal/migrate"
+	"io/fs"
+	"log/slog"
+)
+
+// SchemaVersion is the version of the newest migration in
+// migrations/sqlite and migrations/postgres. A test checks that they match.
+const SchemaVersion = 2
+
+//go:embed migrations/sqlite/*.sql
+var sqliteFiles embed.FS
+
+// sqliteMigrations are the embedded migrations of the SQLite schema.
+var sqliteMigrations = mustLoadMigrations(sqliteFiles, "migrations/sqlite")
+
+func mustLoadMigrations(fsys fs.FS, dir string) []migrate.Migration {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	migrations, err := migrate.Load(sub)
+	if err != nil {
+		panic(err)
+	}
+	return migrations
+}
+
+// Migrator returns the migrator for the schema. Databases created before
+// migrations were tracked are adopted first (see adoptLegacySchema).
+func (s *Sqlite) Migrator(ctx context.Context) (*migrate.Migrator, error) {
+	m := migrate.New(s.db, sqliteMigrations)
+	if err := s.adoptLegacySchema(ctx, m); err != nil {
+		return nil, fmt.Errorf("adopt legacy schema: %w", err)
+	}
+	return m, nil
+}
+
+// InitSchema applies all pending migrations. Call this after New().
+func (s *Sqlite) InitSchema() error {
+	ctx := context.Background()
+	m, err := s.Migrator(ctx)
+	if err != nil {
+		return err
+	}
+	if err := m.Up(ctx); err != nil {
+		return fmt.Errorf("migrate schema: %w", err)
+	}
+	return nil
+}
+
+// CurrentSchemaVersion returns the highest applied migration (0 if
+// InitSchema has not run yet).
+func (s *Sqlite) CurrentSchemaVersion(ctx context.Context) (int, error) {
+	version, err := migrate.New(s.db, sqliteMigrations).Version(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// legacyColumns were added with ALTER TABLE before PRAGMA user_version was
+// set, so databases of version 0 may lack them.
+var legacyColumns = []struct{ name, definition string }{
+	{"failed_login_attempts", "INTEGER DEFAULT 0"},
+	{"locked_until", "DATETIME"},
+	{"pepper_version", "INTEGER NOT NULL DEFAULT 0"},
+	{"password_changed_at", "DATETIME"},
+}
+
+// adoptLegacySchema records the migrations of a database created before
+// schema_migrations existed. Its version is in PRAGMA user_version; tables
+// of version 0 get their missing columns, migration 1 then creates the
+// remaining tables and indexes.
+func (s *Sqlite) adoptLegacySchema(ctx context.Context, m *migrate.Migrator) error {
+	tracked, err := m.Version(ctx)
+	if err != nil || tracked > 0 {
+		return err
+	}
+	var users int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'users'`).Scan(&users); err != nil {
+		return err
+	}
+	if users == 0 {
+		return nil
+	}
+
+	var version int
+	if err := s.db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Adopting untracked schema", "user_version", version)
+	if version > 0 {
+		return m.Baseline(ctx, version)
+	}
+	for _, c := range legacyColumns {
+		var exists int
+		if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_table_info('users') WHERE name = ?`, c.name).Scan(&exists); err != nil {
+			return err
+		}
+		if exists == 0 {
+			if _, err := s.db.ExecContext(ctx, `ALTER TABLE users ADD COLUMN `+c.name+` `+c.definition); err != nil {
+				return fmt.Errorf("add column %s: %w", c.name, err)
+			}
+		}
+	}
+	return nil
+}
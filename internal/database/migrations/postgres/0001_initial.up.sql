@@ -0,0 +1,37 @@
+-- Same schema as migrations/sqlite/0001_initial; the versions of both
+-- backends are kept in step (see database.SchemaVersion).
+CREATE TABLE users (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	username TEXT NOT NULL UNIQUE,
+	password TEXT NOT NULL,
+	email TEXT NOT NULL DEFAULT '',
+	is_active BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	deactived_at TIMESTAMPTZ,
+	failed_login_attempts INTEGER NOT NULL DEFAULT 0,
+	locked_until TIMESTAMPTZ,
+	pepper_version INTEGER NOT NULL DEFAULT 0,
+	password_changed_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_users_email ON users(email);
+
+CREATE TABLE password_history (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	password TEXT NOT NULL,
+	pepper_version INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_password_history_user ON password_history(user_id);
+
+-- Times in Unix milliseconds, like in SQLite
+CREATE TABLE login_throttle (
+	key TEXT PRIMARY KEY,
+	failures INTEGER NOT NULL DEFAULT 0,
+	last_failure BIGINT NOT NULL,
+	blocked_until BIGINT
+);
+
+CREATE INDEX idx_login_throttle_last_failure ON login_throttle(last_failure);
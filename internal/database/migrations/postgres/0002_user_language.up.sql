@@ -0,0 +1,2 @@
+-- Preferred message language ('' = Accept-Language)
+ALTER TABLE users ADD COLUMN language TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+DROP TABLE login_throttle;
+DROP TABLE password_history;
+DROP TABLE users;
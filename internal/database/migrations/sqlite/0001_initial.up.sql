@@ -0,0 +1,37 @@
+-- Schema as of PRAGMA user_version 1. IF NOT EXISTS lets databases created
+-- before migrations were tracked adopt it (see adoptLegacySchema).
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT NOT NULL UNIQUE,
+	password TEXT NOT NULL,
+	email TEXT,
+	is_active BOOLEAN NOT NULL DEFAULT 1,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	deactived_at DATETIME,
+	failed_login_attempts INTEGER DEFAULT 0,
+	locked_until DATETIME,
+	pepper_version INTEGER NOT NULL DEFAULT 0,
+	password_changed_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
+CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
+
+CREATE TABLE IF NOT EXISTS password_history (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	password TEXT NOT NULL,
+	pepper_version INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_history_user ON password_history(user_id);
+
+CREATE TABLE IF NOT EXISTS login_throttle (
+	key TEXT PRIMARY KEY,
+	failures INTEGER NOT NULL DEFAULT 0,
+	last_failure INTEGER NOT NULL,
+	blocked_until INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_throttle_last_failure ON login_throttle(last_failure);
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *Sqlite {
+	t.Helper()
+	repo, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo.(*Sqlite)
+}
+
+func TestSchemaVersionMatchesMigrations(t *testing.T) {
+	if latest := sqliteMigrations[len(sqliteMigrations)-1].Version; latest != SchemaVersion {
+		t.Errorf("SchemaVersion = %d, newest migration is %d", SchemaVersion, latest)
+	}
+	if latest := postgresMigrations[len(postgresMigrations)-1].Version; latest != SchemaVersion {
+		t.Errorf("SchemaVersion = %d, newest postgres migration is %d", SchemaVersion, latest)
+	}
+}
+
+// TestAdoptLegacySchema upgrades databases created by InitSchema before
+// migrations were tracked.
+func TestAdoptLegacySchema(t *testing.T) {
+	const usersV0 = `CREATE TABLE users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL UNIQUE,
+		password TEXT NOT NULL,
+		email TEXT,
+		is_active BOOLEAN NOT NULL DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		deactived_at DATETIME,
+		failed_login_attempts INTEGER DEFAULT 0
+	)`
+
+	tests := []struct {
+		name  string
+		setup []string
+	}{
+		{"Version 0 with missing columns", []string{usersV0}},
+		{"Version 1", []string{sqliteMigrations[0].Up, "PRAGMA user_version = 1"}},
+		{"Version 2", []string{sqliteMigrations[0].Up, sqliteMigrations[1].Up, "PRAGMA user_version = 2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			for _, stmt := range tt.setup {
+				if _, err := db.DB().Exec(stmt); err != nil {
+					t.Fatalf("Setup %q failed: %v", stmt, err)
+				}
+			}
+			if _, err := db.DB().Exec(`INSERT INTO users (username, password, email) VALUES ('legacy', 'hash', 'legacy@example.com')`); err != nil {
+				t.Fatalf("Insert failed: %v", err)
+			}
+
+			if err := db.InitSchema(); err != nil {
+				t.Fatalf("InitSchema() failed: %v", err)
+			}
+			if version, err := db.CurrentSchemaVersion(context.Background()); err != nil || version != SchemaVersion {
+				t.Errorf("CurrentSchemaVersion() = %d, %v, want %d", version, err, SchemaVersion)
+			}
+			user, err := db.GetUserByUsername("legacy")
+			if err != nil {
+				t.Fatalf("GetUserByUsername() failed: %v", err)
+			}
+			if err := db.SetLanguage(user.ID, "de"); err != nil {
+				t.Errorf("SetLanguage() failed: %v", err)
+			}
+			if _, err := db.RecordLoginFailure("legacy", "192.0.2.1"); err != nil {
+				t.Errorf("RecordLoginFailure() failed: %v", err)
+			}
+		})
+	}
+}
+
+// TestMigrationsDownAndUp runs every down script and re-applies the schema.
+func TestMigrationsDownAndUp(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	if err := db.InitSchema(); err != nil {
+		t.Fatalf("InitSchema() failed: %v", err)
+	}
+	m, err := db.Migrator(ctx)
+	if err != nil {
+		t.Fatalf("Migrator() failed: %v", err)
+	}
+
+	if err := m.Down(ctx); err != nil {
+		t.Fatalf("Down() failed: %v", err)
+	}
+	var columns int
+	db.DB().QueryRow(`SELECT COUNT(*) FROM pragma_table_info('users') WHERE name = 'language'`).Scan(&columns)
+	if columns != 0 {
+		t.Error("Column language still exists after Down()")
+	}
+
+	if err := m.To(ctx, 0); err != nil {
+		t.Fatalf("To(0) failed: %v", err)
+	}
+	var tables int
+	db.DB().QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('users', 'password_history', 'login_throttle')`).Scan(&tables)
+	if tables != 0 {
+		t.Errorf("%d tables left after To(0)", tables)
+	}
+
+	if err := db.InitSchema(); err != nil {
+		t.Fatalf("InitSchema() after To(0) failed: %v", err)
+	}
+	if _, err := db.CreateUser("again", "MyP@ssw0rd123", ""); err != nil {
+		t.Errorf("CreateUser() after re-migration failed: %v", err)
+	}
+}
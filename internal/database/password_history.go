@@ -0,0 +1,155 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"foodshop/internal/models"
+	"foodshop/internal/passhash"
+	"time"
+)
+
+// DefaultPasswordHistory is the number of previous password hashes kept per user.
+const DefaultPasswordHistory = 5
+
+// SetPasswordHistory sets how many previous password hashes are kept per
+// user and checked on password changes. The current password is always
+// checked, even if n is 0.
+func (p *policy) SetPasswordHistory(n int) {
+	if n < 0 {
+		n = 0
+	}
+	p.passwordHistory = n
+}
+
+// SetMaxPasswordAge sets the maximum password age. Users whose password
+// is older must change it before they can log in again. 0 disables expiry.
+func (p *policy) SetMaxPasswordAge(d time.Duration) { p.maxPasswordAge = d }
+
+// IsPasswordExpired reports whether the user's password is older than the
+// maximum password age. Users created before password changes were tracked
+// are measured from their creation date.
+func (p *policy) IsPasswordExpired(user *models.User) bool {
+	if p.maxPasswordAge <= 0 {
+		return false
+	}
+	changedAt := user.CreatedAt
+	if user.PasswordChangedAt != nil {
+		changedAt = *user.PasswordChangedAt
+	}
+	return time.Since(changedAt) > p.maxPasswordAge
+}
+
+// storedHash is a password hash with the pepper version it was created with.
+type storedHash struct {
+	hash          string
+	pepperVersion int
+}
+
+// isPasswordReused checks the plain password against the current hash and
+// the stored history of the user.
+func (s *Sqlite) isPasswordReused(user *models.User, plain string) (bool, error) {
+	hashes := []storedHash{{user.Password, user.PepperVersion}}
+
+	if s.passwordHistory > 0 {
+		query := `
+			SELECT password, pepper_version
+			FROM password_history
+			WHERE user_id = ?
+			ORDER BY id DESC
+			LIMIT ?
+		`
+		rows, err := s.db.Query(query, user.ID, s.passwordHistory)
+		if err != nil {
+			return false, fmt.Errorf("query password history: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var h storedHash
+			if err := rows.Scan(&h.hash, &h.pepperVersion); err != nil {
+				return false, fmt.Errorf("scan password history: %w", err)
+			}
+			hashes = append(hashes, h)
+		}
+		if err := rows.Err(); err != nil {
+			return false, fmt.Errorf("query password history: %w", err)
+		}
+	}
+
+	return s.matchesAny(plain, hashes)
+}
+
+// matchesAny reports whether the plain password matches one of the hashes.
+func (p *policy) matchesAny(plain string, hashes []storedHash) (bool, error) {
+	for _, h := range hashes {
+		peppered, err := p.pepper.Apply(plain, h.pepperVersion)
+		if errors.Is(err, passhash.ErrUnknownPepper) {
+			// Pepper was retired, this hash cannot match anymore
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		err = p.hasher.Verify(h.hash, peppered)
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, passhash.ErrMismatch) && !errors.Is(err, passhash.ErrUnknownAlgorithm) {
+			return false, fmt.Errorf("verify password history: %w", err)
+		}
+	}
+
+	return false, nil
+}
+
+// changePassword stores the new hash, moves the old hash into the history
+// and trims the history to the configured size, all in one transaction.
+func (s *Sqlite) changePassword(user *models.User, hash string, pepperVersion int, email string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+		SET password = ?, pepper_version = ?, email = ?, password_changed_at = ?
+		WHERE id = ?
+	`
+	result, err := tx.Exec(query, hash, pepperVersion, email, time.Now(), user.ID)
+	if err != nil {
+		return fmt.Errorf("update user: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	if s.passwordHistory > 0 {
+		insert := `
+			INSERT INTO password_history (user_id, password, pepper_version)
+			VALUES (?, ?, ?)
+		`
+		if _, err := tx.Exec(insert, user.ID, user.Password, user.PepperVersion); err != nil {
+			return fmt.Errorf("insert password history: %w", err)
+		}
+	}
+
+	trim := `
+		DELETE FROM password_history
+		WHERE user_id = ? AND id NOT IN (
+			SELECT id FROM password_history WHERE user_id = ? ORDER BY id DESC LIMIT ?
+		)
+	`
+	if _, err := tx.Exec(trim, user.ID, user.ID, s.passwordHistory); err != nil {
+		return fmt.Errorf("trim password history: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func setupHistoryDB(t *testing.T) *Sqlite {
+	t.Helper()
+	repo, err := New(filepath.Join(t.TempDir(), "test_history.db"))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+
+	db := repo.(*Sqlite)
+	if err := db.InitSchema(); err != nil {
+		t.Fatalf("InitSchema() failed: %v", err)
+	}
+	return db
+}
+
+// TestUpdateUserRejectsReuse verifies that the current and recent passwords cannot be reused.
+func TestUpdateUserRejectsReuse(t *testing.T) {
+	db := setupHistoryDB(t)
+	db.SetPasswordHistory(2)
+
+	if _, err := db.CreateUser("historyuser", "password-0", "history@example.com"); err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+
+	// Same as current password
+	if _, err := db.UpdateUser("historyuser", "password-0", "history@example.com"); err != ErrPasswordReused {
+		t.Errorf("Expected ErrPasswordReused for current password, got %v", err)
+	}
+
+	for _, p := range []string{"password-1", "password-2", "password-3"} {
+		if _, err := db.UpdateUser("historyuser", p, "history@example.com"); err != nil {
+			t.Fatalf("UpdateUser(%q) failed: %v", p, err)
+		}
+	}
+
+	// History keeps password-1 and password-2, password-3 is current
+	for _, p := range []string{"password-1", "password-2", "password-3"} {
+		if _, err := db.UpdateUser("historyuser", p, "history@example.com"); err != ErrPasswordReused {
+			t.Errorf("Expected ErrPasswordReused for %q, got %v", p, err)
+		}
+	}
+
+	// password-0 dropped out of the history
+	if _, err := db.UpdateUser("historyuser", "password-0", "history@example.com"); err != nil {
+		t.Errorf("UpdateUser() with expired history entry failed: %v", err)
+	}
+
+	var count int
+	if err := db.DB().QueryRow("SELECT COUNT(*) FROM password_history").Scan(&count); err != nil {
+		t.Fatalf("Count query failed: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 history entries, got %d", count)
+	}
+
+	// Email-only updates don't touch the history
+	if _, err := db.UpdateUser("historyuser", "", "new@example.com"); err != nil {
+		t.Errorf("UpdateUser() email only failed: %v", err)
+	}
+}
+
+// TestIsPasswordExpired verifies the maximum password age.
+func TestIsPasswordExpired(t *testing.T) {
+	db := setupHistoryDB(t)
+
+	user, err := db.CreateUser("ageuser", "password-0", "age@example.com")
+	if err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+	if user.PasswordChangedAt == nil {
+		t.Fatal("Expected password_changed_at to be set on creation")
+	}
+
+	if db.IsPasswordExpired(user) {
+		t.Error("Password must not expire without a maximum age")
+	}
+
+	db.SetMaxPasswordAge(time.Hour)
+	if db.IsPasswordExpired(user) {
+		t.Error("Fresh password must not be expired")
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	if _, err := db.DB().Exec("UPDATE users SET password_changed_at = ? WHERE id = ?", old, user.ID); err != nil {
+		t.Fatalf("Failed to age password: %v", err)
+	}
+	user, _ = db.GetUserByID(user.ID)
+	if !db.IsPasswordExpired(user) {
+		t.Error("Password older than the maximum age should be expired")
+	}
+
+	// Changing the password resets the age
+	user, err = db.UpdateUser("ageuser", "password-1", "age@example.com")
+	if err != nil {
+		t.Fatalf("UpdateUser() failed: %v", err)
+	}
+	if db.IsPasswordExpired(user) {
+		t.Error("Password should not be expired after a change")
+	}
+}
@@ -0,0 +1,467 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"foodshop/internal/models"
+	"log/slog"
+	"time"
+)
+
+// The methods below follow their Sqlite counterparts; the differences are
+// the $n placeholders, RETURNING instead of LastInsertId, real booleans and
+// ON DELETE CASCADE for the password history.
+
+const pgUserColumns = `id, username, password, email, is_active, created_at, deactived_at,
+	failed_login_attempts, locked_until, pepper_version, password_changed_at, language`
+
+// scanPgUser reads a row of pgUserColumns.
+func scanPgUser(row *sql.Row) (*models.User, error) {
+	user := &models.User{}
+	var deactivedAt, lockedUntil, passwordChangedAt sql.NullTime
+
+	err := row.Scan(
+		&user.ID,
+		&user.Username,
+		&user.Password,
+		&user.Email,
+		&user.IsActive,
+		&user.CreatedAt,
+		&deactivedAt,
+		&user.FailedLoginAttempts,
+		&lockedUntil,
+		&user.PepperVersion,
+		&passwordChangedAt,
+		&user.Language,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query user: %w", err)
+	}
+
+	if deactivedAt.Valid {
+		user.DeactivedAt = &deactivedAt.Time
+	}
+	if lockedUntil.Valid {
+		user.LockedUntil = &lockedUntil.Time
+	}
+	if passwordChangedAt.Valid {
+		user.PasswordChangedAt = &passwordChangedAt.Time
+	}
+	return user, nil
+}
+
+// execAffectingUser runs an UPDATE or DELETE of one user and returns
+// ErrUserNotFound if no row matched.
+func (p *Postgres) execAffectingUser(operation, query string, args ...interface{}) error {
+	result, err := p.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// CreateUser creates a new user with hashed password.
+func (p *Postgres) CreateUser(username, password, email string) (_ *models.User, err error) {
+	p, span := p.trace("CreateUser")
+	defer finishSpan(span, &err)
+
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	hashedPassword, pepperVersion, err := p.hashPassword(p.context(), password)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO users (username, password, pepper_version, email, is_active, password_changed_at)
+		VALUES ($1, $2, $3, $4, TRUE, $5)
+		RETURNING id
+	`
+	var id int64
+	err = p.db.QueryRow(query, username, hashedPassword, pepperVersion, email, time.Now()).Scan(&id)
+	if err != nil {
+		if isPostgresUniqueViolation(err) {
+			return nil, ErrUserExists
+		}
+		return nil, fmt.Errorf("insert user: %w", err)
+	}
+
+	return p.GetUserByID(id)
+}
+
+// UpdateUser changes the password (optional) and email of a user; the
+// username cannot be changed. A new password must not match the current or
+// a recent one (ErrPasswordReused).
+func (p *Postgres) UpdateUser(username, password, email string) (_ *models.User, err error) {
+	p, span := p.trace("UpdateUser")
+	defer finishSpan(span, &err)
+
+	user, err := p.GetUserByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if password == "" {
+		if err := p.execAffectingUser("update user", `UPDATE users SET email = $1 WHERE username = $2`, email, username); err != nil {
+			return nil, err
+		}
+		return p.GetUserByUsername(username)
+	}
+
+	reused, err := p.isPasswordReused(user, password)
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		return nil, ErrPasswordReused
+	}
+
+	hashedPassword, pepperVersion, err := p.hashPassword(p.context(), password)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.changePassword(user, hashedPassword, pepperVersion, email); err != nil {
+		return nil, err
+	}
+	return p.GetUserByUsername(username)
+}
+
+// GetUserByUsername retrieves a user by username.
+func (p *Postgres) GetUserByUsername(username string) (_ *models.User, err error) {
+	_, span := p.trace("GetUserByUsername")
+	defer finishSpan(span, &err)
+
+	query := `SELECT ` + pgUserColumns + ` FROM users WHERE username = $1`
+	return scanPgUser(p.db.QueryRow(query, username))
+}
+
+// GetUserByID retrieves a user by ID.
+func (p *Postgres) GetUserByID(id int64) (_ *models.User, err error) {
+	_, span := p.trace("GetUserByID")
+	defer finishSpan(span, &err)
+
+	query := `SELECT ` + pgUserColumns + ` FROM users WHERE id = $1`
+	return scanPgUser(p.db.QueryRow(query, id))
+}
+
+// DeleteUser permanently deletes a user; the password history is removed
+// by ON DELETE CASCADE.
+func (p *Postgres) DeleteUser(id int64) error {
+	return p.execAffectingUser("delete user", `DELETE FROM users WHERE id = $1`, id)
+}
+
+// SetLanguage stores the preferred language of a user ("" = negotiate
+// from Accept-Language).
+func (p *Postgres) SetLanguage(id int64, language string) error {
+	return p.execAffectingUser("set language", `UPDATE users SET language = $1 WHERE id = $2`, language, id)
+}
+
+// DeactivateUser soft-deletes a user by setting is_active to false.
+func (p *Postgres) DeactivateUser(id int64) error {
+	query := `UPDATE users SET is_active = FALSE, deactived_at = $1 WHERE id = $2 AND is_active`
+	return p.execAffectingUser("deactivate user", query, time.Now(), id)
+}
+
+// ActivateUser reactivates a deactivated user.
+func (p *Postgres) ActivateUser(id int64) error {
+	query := `UPDATE users SET is_active = TRUE, deactived_at = NULL WHERE id = $1 AND NOT is_active`
+	return p.execAffectingUser("activate user", query, id)
+}
+
+// VerifyPassword checks if the provided password matches the stored hash.
+// Outdated hashes are upgraded like in Sqlite.VerifyPassword.
+func (p *Postgres) VerifyPassword(username, password string) (_ *models.User, err error) {
+	p, span := p.trace("VerifyPassword")
+	defer finishSpan(span, &err)
+
+	user, err := p.GetUserByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsActive {
+		return nil, ErrInvalidCredentials
+	}
+	if err := p.checkPassword(p.context(), user, password); err != nil {
+		return nil, err
+	}
+
+	if p.needsRehash(user) {
+		if err := p.rehashPassword(user, password); err != nil {
+			// Login still succeeds; the upgrade is retried on the next login
+			slog.WarnContext(p.context(), "Password rehash failed", "user_id", user.ID, "error", err)
+		}
+	}
+	return user, nil
+}
+
+// rehashPassword stores a new hash of the password using the current hasher and pepper.
+func (p *Postgres) rehashPassword(user *models.User, plain string) error {
+	hash, version, err := p.hashPassword(p.context(), plain)
+	if err != nil {
+		return err
+	}
+
+	// Only replace the hash we verified against, in case it changed concurrently
+	query := `UPDATE users SET password = $1, pepper_version = $2 WHERE id = $3 AND password = $4`
+	if _, err := p.db.Exec(query, hash, version, user.ID, user.Password); err != nil {
+		return fmt.Errorf("update password hash: %w", err)
+	}
+
+	user.Password = hash
+	user.PepperVersion = version
+	return nil
+}
+
+// isPasswordReused checks the plain password against the current hash and
+// the stored history of the user.
+func (p *Postgres) isPasswordReused(user *models.User, plain string) (bool, error) {
+	hashes := []storedHash{{user.Password, user.PepperVersion}}
+
+	if p.passwordHistory > 0 {
+		query := `
+			SELECT password, pepper_version
+			FROM password_history
+			WHERE user_id = $1
+			ORDER BY id DESC
+			LIMIT $2
+		`
+		rows, err := p.db.Query(query, user.ID, p.passwordHistory)
+		if err != nil {
+			return false, fmt.Errorf("query password history: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var h storedHash
+			if err := rows.Scan(&h.hash, &h.pepperVersion); err != nil {
+				return false, fmt.Errorf("scan password history: %w", err)
+			}
+			hashes = append(hashes, h)
+		}
+		if err := rows.Err(); err != nil {
+			return false, fmt.Errorf("query password history: %w", err)
+		}
+	}
+
+	return p.matchesAny(plain, hashes)
+}
+
+// changePassword stores the new hash, moves the old hash into the history
+// and trims the history to the configured size, all in one transaction.
+func (p *Postgres) changePassword(user *models.User, hash string, pepperVersion int, email string) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+		SET password = $1, pepper_version = $2, email = $3, password_changed_at = $4
+		WHERE id = $5
+	`
+	result, err := tx.Exec(query, hash, pepperVersion, email, time.Now(), user.ID)
+	if err != nil {
+		return fmt.Errorf("update user: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	if p.passwordHistory > 0 {
+		insert := `INSERT INTO password_history (user_id, password, pepper_version) VALUES ($1, $2, $3)`
+		if _, err := tx.Exec(insert, user.ID, user.Password, user.PepperVersion); err != nil {
+			return fmt.Errorf("insert password history: %w", err)
+		}
+	}
+
+	trim := `
+		DELETE FROM password_history
+		WHERE user_id = $1 AND id NOT IN (
+			SELECT id FROM password_history WHERE user_id = $1 ORDER BY id DESC LIMIT $2
+		)
+	`
+	if _, err := tx.Exec(trim, user.ID, p.passwordHistory); err != nil {
+		return fmt.Errorf("trim password history: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// IsAccountLocked checks if a user account is currently locked. An expired
+// lock is reset.
+func (p *Postgres) IsAccountLocked(username string) (bool, time.Time, error) {
+	user, err := p.GetUserByUsername(username)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	if user.LockedUntil == nil {
+		return false, time.Time{}, nil
+	}
+	if time.Now().After(*user.LockedUntil) {
+		p.UnlockAccount(username)
+		return false, time.Time{}, nil
+	}
+	return true, *user.LockedUntil, nil
+}
+
+// IncrementFailedAttempts increments the failed login counter for a user.
+func (p *Postgres) IncrementFailedAttempts(username string) error {
+	query := `UPDATE users SET failed_login_attempts = failed_login_attempts + 1 WHERE username = $1`
+	if _, err := p.db.Exec(query, username); err != nil {
+		return fmt.Errorf("increment failed attempts: %w", err)
+	}
+	return nil
+}
+
+// LockAccount locks a user account for the specified duration.
+func (p *Postgres) LockAccount(username string, duration time.Duration) error {
+	query := `UPDATE users SET locked_until = $1 WHERE username = $2`
+	return p.execAffectingUser("lock account", query, time.Now().Add(duration), username)
+}
+
+// ResetFailedAttempts resets the failed login counter to 0.
+func (p *Postgres) ResetFailedAttempts(username string) error {
+	query := `UPDATE users SET failed_login_attempts = 0, locked_until = NULL WHERE username = $1`
+	if _, err := p.db.Exec(query, username); err != nil {
+		return fmt.Errorf("reset failed attempts: %w", err)
+	}
+	return nil
+}
+
+// UnlockAccount unlocks a user account and resets failed attempts.
+func (p *Postgres) UnlockAccount(username string) error {
+	return p.ResetFailedAttempts(username)
+}
+
+// GetFailedAttempts returns the number of failed login attempts for a user.
+func (p *Postgres) GetFailedAttempts(username string) (int, error) {
+	user, err := p.GetUserByUsername(username)
+	if err != nil {
+		return 0, err
+	}
+	return user.FailedLoginAttempts, nil
+}
+
+// CheckLoginThrottle returns how long the client has to wait before the
+// next login attempt for username is allowed (0 if it is allowed now).
+func (p *Postgres) CheckLoginThrottle(username, ip string) (_ time.Duration, err error) {
+	_, span := p.trace("CheckLoginThrottle")
+	defer finishSpan(span, &err)
+
+	keys, _ := p.throttleKeys(username, ip)
+
+	query := `SELECT MAX(blocked_until) FROM login_throttle WHERE key IN ($1, $2, $3)`
+	var blockedUntil sql.NullInt64
+	if err := p.db.QueryRow(query, keys[0], keys[1], keys[2]).Scan(&blockedUntil); err != nil {
+		return 0, fmt.Errorf("check login throttle: %w", err)
+	}
+
+	if !blockedUntil.Valid {
+		return 0, nil
+	}
+	return max(time.Until(time.UnixMilli(blockedUntil.Int64)), 0), nil
+}
+
+// RecordLoginFailure atomically counts a failed login for all keys and
+// returns the resulting delay before the next attempt (0 if none).
+func (p *Postgres) RecordLoginFailure(username, ip string) (_ time.Duration, err error) {
+	_, span := p.trace("RecordLoginFailure")
+	defer finishSpan(span, &err)
+
+	keys, policies := p.throttleKeys(username, ip)
+	now := time.Now()
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The upsert locks the row, so concurrent failures are counted one by one
+	upsert := `
+		INSERT INTO login_throttle (key, failures, last_failure)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_throttle.last_failure < $3 THEN 1 ELSE login_throttle.failures + 1 END,
+			last_failure = excluded.last_failure
+		RETURNING failures
+	`
+	block := `UPDATE login_throttle SET blocked_until = $1 WHERE key = $2`
+
+	var longest time.Duration
+	for i, key := range keys {
+		policy := policies[i]
+		if policy.Threshold <= 0 {
+			continue
+		}
+
+		var failures int
+		windowStart := now.Add(-policy.Window).UnixMilli()
+		if err := tx.QueryRow(upsert, key, now.UnixMilli(), windowStart).Scan(&failures); err != nil {
+			return 0, fmt.Errorf("record login failure: %w", err)
+		}
+
+		if d := policy.delay(failures); d > 0 {
+			if _, err := tx.Exec(block, now.Add(d).UnixMilli(), key); err != nil {
+				return 0, fmt.Errorf("block login: %w", err)
+			}
+			longest = max(longest, d)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit transaction: %w", err)
+	}
+	return longest, nil
+}
+
+// PruneLoginThrottle deletes entries that are neither within a window nor
+// blocked (see Sqlite.PruneLoginThrottle). Running it outside of
+// RecordLoginFailure keeps the table-wide delete out of the transactions
+// that lock single throttle rows.
+func (p *Postgres) PruneLoginThrottle() (err error) {
+	_, span := p.trace("PruneLoginThrottle")
+	defer finishSpan(span, &err)
+
+	now := time.Now()
+	query := `DELETE FROM login_throttle WHERE last_failure < $1 AND (blocked_until IS NULL OR blocked_until < $2)`
+	if _, err := p.db.Exec(query, now.Add(-p.maxThrottleWindow()).UnixMilli(), now.UnixMilli()); err != nil {
+		return fmt.Errorf("prune login throttle: %w", err)
+	}
+	return nil
+}
+
+// ResetLoginFailures clears the (username, IP) counter after a successful
+// login. The username and IP counters are kept.
+func (p *Postgres) ResetLoginFailures(username, ip string) (err error) {
+	_, span := p.trace("ResetLoginFailures")
+	defer finishSpan(span, &err)
+
+	keys, _ := p.throttleKeys(username, ip)
+
+	query := `DELETE FROM login_throttle WHERE key = $1`
+	if _, err := p.db.Exec(query, keys[2]); err != nil {
+		return fmt.Errorf("reset login failures: %w", err)
+	}
+	return nil
+}
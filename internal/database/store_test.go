@@ -0,0 +1,354 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"foodshop/internal/passhash"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBackend is a Store whose policy the conformance tests can configure.
+type testBackend interface {
+	Store
+	SetPepper(p *passhash.Pepper)
+	SetPasswordHistory(n int)
+	SetMaxPasswordAge(d time.Duration)
+	SetLoginThrottle(cfg LoginThrottle)
+}
+
+func TestSqliteConformance(t *testing.T) {
+	testConformance(t, func(t *testing.T) testBackend { return setupHistoryDB(t) })
+}
+
+func TestMemoryConformance(t *testing.T) {
+	testConformance(t, func(t *testing.T) testBackend { return NewMemory() })
+}
+
+// testConformance is the behaviour every backend must share. The handlers
+// only see the Store interface, so the backends must be interchangeable.
+func testConformance(t *testing.T, open func(t *testing.T) testBackend) {
+	t.Run("Users", func(t *testing.T) {
+		db := open(t)
+		user, err := db.CreateUser("alice", "password123", "alice@example.com")
+		if err != nil {
+			t.Fatalf("CreateUser() failed: %v", err)
+		}
+		if user.ID == 0 || !user.IsActive || user.Password == "password123" || user.PasswordChangedAt == nil {
+			t.Errorf("CreateUser() = %+v, want active user with hashed password", user)
+		}
+		if _, err := db.CreateUser("alice", "other", ""); !errors.Is(err, ErrUserExists) {
+			t.Errorf("Duplicate CreateUser() error = %v, want ErrUserExists", err)
+		}
+		if _, err := db.CreateUser("", "password123", ""); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("CreateUser() without username error = %v, want ErrInvalidCredentials", err)
+		}
+
+		byID, err := db.GetUserByID(user.ID)
+		if err != nil || byID.Username != "alice" || byID.Email != "alice@example.com" {
+			t.Errorf("GetUserByID() = %+v, %v", byID, err)
+		}
+		byName, err := db.GetUserByUsername("alice")
+		if err != nil || byName.ID != user.ID {
+			t.Errorf("GetUserByUsername() = %+v, %v", byName, err)
+		}
+		if _, err := db.GetUserByUsername("Alice"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("GetUserByUsername() is case-insensitive, error = %v", err)
+		}
+		if _, err := db.GetUserByID(user.ID + 100); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("GetUserByID() error = %v, want ErrUserNotFound", err)
+		}
+
+		// Returned users are copies
+		byID.Email = "changed@example.com"
+		if again, _ := db.GetUserByID(user.ID); again.Email != "alice@example.com" {
+			t.Error("Modifying a returned user changed the stored user")
+		}
+
+		if err := db.SetLanguage(user.ID, "de"); err != nil {
+			t.Fatalf("SetLanguage() failed: %v", err)
+		}
+		if again, _ := db.GetUserByID(user.ID); again.Language != "de" {
+			t.Errorf("Language = %q, want de", again.Language)
+		}
+		if err := db.SetLanguage(user.ID+100, "de"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("SetLanguage() error = %v, want ErrUserNotFound", err)
+		}
+
+		if err := db.DeleteUser(user.ID); err != nil {
+			t.Fatalf("DeleteUser() failed: %v", err)
+		}
+		if err := db.DeleteUser(user.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Second DeleteUser() error = %v, want ErrUserNotFound", err)
+		}
+		if _, err := db.CreateUser("alice", "password123", ""); err != nil {
+			t.Errorf("CreateUser() after DeleteUser() failed: %v", err)
+		}
+	})
+
+	t.Run("Activation", func(t *testing.T) {
+		db := open(t)
+		user, _ := db.CreateUser("bob", "password123", "")
+		if err := db.ActivateUser(user.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("ActivateUser() of active user error = %v, want ErrUserNotFound", err)
+		}
+		if err := db.DeactivateUser(user.ID); err != nil {
+			t.Fatalf("DeactivateUser() failed: %v", err)
+		}
+		if got, _ := db.GetUserByID(user.ID); got.IsActive || got.DeactivedAt == nil {
+			t.Errorf("Deactivated user = %+v", got)
+		}
+		if _, err := db.VerifyPassword("bob", "password123"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("VerifyPassword() of deactivated user error = %v, want ErrInvalidCredentials", err)
+		}
+		if err := db.DeactivateUser(user.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Second DeactivateUser() error = %v, want ErrUserNotFound", err)
+		}
+		if err := db.ActivateUser(user.ID); err != nil {
+			t.Fatalf("ActivateUser() failed: %v", err)
+		}
+		if _, err := db.VerifyPassword("bob", "password123"); err != nil {
+			t.Errorf("VerifyPassword() after ActivateUser() failed: %v", err)
+		}
+	})
+
+	t.Run("VerifyPassword", func(t *testing.T) {
+		db := open(t)
+		db.CreateUser("carol", "password123", "")
+		if _, err := db.VerifyPassword("carol", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Wrong password error = %v, want ErrInvalidCredentials", err)
+		}
+		if _, err := db.VerifyPassword("nobody", "password123"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Unknown user error = %v, want ErrUserNotFound", err)
+		}
+
+		// Enabling a pepper migrates the hash on the next login
+		pepper, _ := passhash.ParsePepper("1:secret")
+		db.SetPepper(pepper)
+		user, err := db.VerifyPassword("carol", "password123")
+		if err != nil {
+			t.Fatalf("VerifyPassword() after enabling pepper failed: %v", err)
+		}
+		if stored, _ := db.GetUserByUsername("carol"); user.PepperVersion != 1 || stored.PepperVersion != 1 {
+			t.Errorf("Pepper version = %d (stored %d), want 1", user.PepperVersion, stored.PepperVersion)
+		}
+		if _, err := db.VerifyPassword("carol", "password123"); err != nil {
+			t.Errorf("VerifyPassword() after rehash failed: %v", err)
+		}
+	})
+
+	t.Run("PasswordHistory", func(t *testing.T) {
+		db := open(t)
+		db.SetPasswordHistory(2)
+		user, _ := db.CreateUser("dave", "password-0", "dave@example.com")
+
+		updated, err := db.UpdateUser("dave", "", "new@example.com")
+		if err != nil || updated.Email != "new@example.com" || updated.Password != user.Password {
+			t.Errorf("Email-only UpdateUser() = %+v, %v", updated, err)
+		}
+		if _, err := db.UpdateUser("dave", "password-0", ""); !errors.Is(err, ErrPasswordReused) {
+			t.Errorf("Current password error = %v, want ErrPasswordReused", err)
+		}
+		for i := 1; i <= 3; i++ {
+			if _, err := db.UpdateUser("dave", fmt.Sprintf("password-%d", i), ""); err != nil {
+				t.Fatalf("UpdateUser(password-%d) failed: %v", i, err)
+			}
+		}
+		for i := 1; i <= 3; i++ {
+			if _, err := db.UpdateUser("dave", fmt.Sprintf("password-%d", i), ""); !errors.Is(err, ErrPasswordReused) {
+				t.Errorf("password-%d error = %v, want ErrPasswordReused", i, err)
+			}
+		}
+		if _, err := db.UpdateUser("dave", "password-0", ""); err != nil {
+			t.Errorf("Password outside the history rejected: %v", err)
+		}
+		if _, err := db.VerifyPassword("dave", "password-0"); err != nil {
+			t.Errorf("VerifyPassword() with new password failed: %v", err)
+		}
+		if _, err := db.UpdateUser("nobody", "password-9", ""); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("UpdateUser() of unknown user error = %v, want ErrUserNotFound", err)
+		}
+	})
+
+	t.Run("PasswordExpiry", func(t *testing.T) {
+		db := open(t)
+		user, _ := db.CreateUser("erin", "password-0", "")
+		if db.IsPasswordExpired(user) {
+			t.Error("Password expired without a maximum age")
+		}
+		db.SetMaxPasswordAge(time.Millisecond)
+		time.Sleep(5 * time.Millisecond)
+		if !db.IsPasswordExpired(user) {
+			t.Error("Password older than the maximum age is not expired")
+		}
+		db.SetMaxPasswordAge(time.Hour)
+		user, err := db.UpdateUser("erin", "password-1", "")
+		if err != nil || db.IsPasswordExpired(user) {
+			t.Errorf("Password expired after a change: %v", err)
+		}
+	})
+
+	t.Run("Lockout", func(t *testing.T) {
+		db := open(t)
+		db.CreateUser("frank", "password123", "")
+		for i := 1; i <= 3; i++ {
+			db.IncrementFailedAttempts("frank")
+		}
+		if n, err := db.GetFailedAttempts("frank"); err != nil || n != 3 {
+			t.Errorf("GetFailedAttempts() = %d, %v, want 3", n, err)
+		}
+		if err := db.LockAccount("frank", time.Hour); err != nil {
+			t.Fatalf("LockAccount() failed: %v", err)
+		}
+		if locked, until, err := db.IsAccountLocked("frank"); err != nil || !locked || time.Until(until) <= 0 {
+			t.Errorf("IsAccountLocked() = %v, %v, %v, want locked", locked, until, err)
+		}
+		if err := db.UnlockAccount("frank"); err != nil {
+			t.Fatalf("UnlockAccount() failed: %v", err)
+		}
+		if locked, _, _ := db.IsAccountLocked("frank"); locked {
+			t.Error("Account still locked after UnlockAccount()")
+		}
+		if n, _ := db.GetFailedAttempts("frank"); n != 0 {
+			t.Errorf("GetFailedAttempts() after unlock = %d, want 0", n)
+		}
+
+		// Expired locks are lifted
+		db.LockAccount("frank", -time.Second)
+		if locked, _, _ := db.IsAccountLocked("frank"); locked {
+			t.Error("Expired lock still active")
+		}
+
+		// Unknown users
+		if _, _, err := db.IsAccountLocked("nobody"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("IsAccountLocked() error = %v, want ErrUserNotFound", err)
+		}
+		if err := db.LockAccount("nobody", time.Hour); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("LockAccount() error = %v, want ErrUserNotFound", err)
+		}
+		if _, err := db.GetFailedAttempts("nobody"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("GetFailedAttempts() error = %v, want ErrUserNotFound", err)
+		}
+		if err := db.IncrementFailedAttempts("nobody"); err != nil {
+			t.Errorf("IncrementFailedAttempts() of unknown user failed: %v", err)
+		}
+		if err := db.ResetFailedAttempts("nobody"); err != nil {
+			t.Errorf("ResetFailedAttempts() of unknown user failed: %v", err)
+		}
+	})
+
+	t.Run("LoginThrottle", func(t *testing.T) {
+		db := open(t)
+		db.SetLoginThrottle(LoginThrottle{
+			IP:     ThrottlePolicy{Threshold: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour},
+			UserIP: ThrottlePolicy{Threshold: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour},
+		})
+
+		if wait, err := db.RecordLoginFailure("gina", "10.0.0.1"); err != nil || wait != 0 {
+			t.Errorf("First failure = %v, %v, want no delay", wait, err)
+		}
+		if wait, _ := db.RecordLoginFailure("gina", "10.0.0.1"); wait != time.Minute {
+			t.Errorf("Second failure = %v, want 1m", wait)
+		}
+		if wait, err := db.CheckLoginThrottle("gina", "10.0.0.1"); err != nil || wait <= 0 || wait > time.Minute {
+			t.Errorf("CheckLoginThrottle() = %v, %v, want up to 1m", wait, err)
+		}
+		if wait, _ := db.CheckLoginThrottle("gina", "10.0.0.2"); wait != 0 {
+			t.Errorf("Other IP throttled for %v", wait)
+		}
+
+		// The pair counter is reset, the IP counter is not
+		if err := db.ResetLoginFailures("gina", "10.0.0.1"); err != nil {
+			t.Fatalf("ResetLoginFailures() failed: %v", err)
+		}
+		if wait, _ := db.CheckLoginThrottle("gina", "10.0.0.1"); wait != 0 {
+			t.Errorf("Throttled for %v after reset", wait)
+		}
+		if wait, _ := db.RecordLoginFailure("hank", "10.0.0.1"); wait != time.Minute {
+			t.Errorf("Third failure from the IP = %v, want 1m", wait)
+		}
+		if wait, _ := db.CheckLoginThrottle("ivan", "10.0.0.1"); wait == 0 {
+			t.Error("Spraying IP is not throttled for other accounts")
+		}
+	})
+
+	t.Run("LoginThrottleDistributed", func(t *testing.T) {
+		db := open(t)
+		db.SetLoginThrottle(LoginThrottle{
+			User: ThrottlePolicy{Threshold: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour},
+		})
+		if _, err := db.CreateUser("jane", "MyP@ssw0rd123", ""); err != nil {
+			t.Fatalf("CreateUser() failed: %v", err)
+		}
+
+		// An attacker tries each IP only once
+		const attempts = 10
+		for i := range attempts {
+			wait, err := db.RecordLoginFailure("jane", fmt.Sprintf("10.0.1.%d", i))
+			if err != nil {
+				t.Fatalf("RecordLoginFailure() failed: %v", err)
+			}
+			if wait > MaxUserDelay {
+				t.Errorf("Failure %d = %v, want at most %v", i+1, wait, MaxUserDelay)
+			}
+		}
+		for _, ip := range []string{"10.0.1.0", "10.0.2.1"} {
+			if wait, err := db.CheckLoginThrottle("jane", ip); err != nil || wait <= 0 || wait > MaxUserDelay {
+				t.Errorf("CheckLoginThrottle(%s) after %d failures from distinct IPs = %v, %v, want up to %v",
+					ip, attempts, wait, err, MaxUserDelay)
+			}
+		}
+
+		// A successful login of the owner does not reset the count of the attacking IPs
+		if err := db.ResetLoginFailures("jane", "10.0.3.1"); err != nil {
+			t.Fatalf("ResetLoginFailures() failed: %v", err)
+		}
+		if wait, _ := db.CheckLoginThrottle("jane", "10.0.1.5"); wait <= 0 {
+			t.Errorf("CheckLoginThrottle() after the owner logged in = %v, want > 0", wait)
+		}
+	})
+
+	t.Run("Concurrency", func(t *testing.T) {
+		db := open(t)
+		db.SetLoginThrottle(LoginThrottle{
+			UserIP: ThrottlePolicy{Threshold: 100, BaseDelay: time.Second, MaxDelay: time.Second, Window: time.Hour},
+		})
+		const workers = 8
+		var wg sync.WaitGroup
+		created := make(chan error, workers)
+		for i := range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := db.CreateUser("same", "password123", "")
+				created <- err
+				db.RecordLoginFailure(fmt.Sprintf("user%d", i), "10.0.0.1")
+				db.RecordLoginFailure("same", "10.0.0.1")
+			}()
+		}
+		wg.Wait()
+		close(created)
+
+		succeeded := 0
+		for err := range created {
+			switch {
+			case err == nil:
+				succeeded++
+			case !errors.Is(err, ErrUserExists):
+				t.Errorf("Concurrent CreateUser() error = %v, want ErrUserExists", err)
+			}
+		}
+		if succeeded != 1 {
+			t.Errorf("%d concurrent CreateUser() calls succeeded, want 1", succeeded)
+		}
+
+		// The 100th failure of the pair starts the delay
+		for i := workers; i < 99; i++ {
+			db.RecordLoginFailure("same", "10.0.0.1")
+		}
+		if wait, _ := db.RecordLoginFailure("same", "10.0.0.1"); wait != time.Second {
+			t.Errorf("100th failure = %v, want 1s (lost updates?)", wait)
+		}
+	})
+}
@@ -0,0 +1,238 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ThrottlePolicy configures the backoff for one kind of throttle key.
+type ThrottlePolicy struct {
+	// Threshold is the number of failures before delays start (0 disables the policy).
+	Threshold int
+	// BaseDelay is the delay after reaching the threshold; it doubles with every further failure.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay.
+	MaxDelay time.Duration
+	// Window is how long failures are remembered; the counter starts over afterwards.
+	Window time.Duration
+}
+
+// delay returns the backoff for the given number of failures.
+func (p ThrottlePolicy) delay(failures int) time.Duration {
+	if p.Threshold <= 0 || failures < p.Threshold {
+		return 0
+	}
+	d := p.BaseDelay
+	for i := p.Threshold; i < failures && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxDelay)
+}
+
+// LoginThrottle configures adaptive login throttling. Failures are counted
+// per username, per client IP and per (username, IP) pair:
+//   - the pair catches brute force against one account from one client,
+//   - the IP catches password spraying across many accounts,
+//   - the username catches distributed attacks against one account.
+//
+// Every key blocks every attempt, so rotating IPs does not escape the
+// username counter. To keep an attacker from locking the owner out, the
+// username delay is capped at MaxUserDelay, and clients with repeated
+// failures get a challenge before they can try again. A successful login
+// only clears the pair counter: the username and IP counters keep counting
+// the other clients.
+type LoginThrottle struct {
+	User   ThrottlePolicy
+	IP     ThrottlePolicy
+	UserIP ThrottlePolicy
+}
+
+// MaxUserDelay caps the delay of the username policy.
+const MaxUserDelay = time.Minute
+
+// DefaultLoginThrottle is used unless SetLoginThrottle is called.
+var DefaultLoginThrottle = LoginThrottle{
+	User:   ThrottlePolicy{Threshold: 10, BaseDelay: 1 * time.Second, MaxDelay: MaxUserDelay, Window: 1 * time.Hour},
+	IP:     ThrottlePolicy{Threshold: 20, BaseDelay: 1 * time.Second, MaxDelay: LockoutDuration, Window: 1 * time.Hour},
+	UserIP: ThrottlePolicy{Threshold: MaxLoginAttempts, BaseDelay: 2 * time.Second, MaxDelay: LockoutDuration, Window: 1 * time.Hour},
+}
+
+// SetLoginThrottle replaces the login throttle configuration.
+func (p *policy) SetLoginThrottle(cfg LoginThrottle) { p.throttle = cfg }
+
+// throttleKeys returns the keys (user, IP, pair) and policies for a login
+// attempt. The username delay is capped at MaxUserDelay.
+func (p *policy) throttleKeys(username, ip string) ([]string, []ThrottlePolicy) {
+	user := p.throttle.User
+	user.MaxDelay = min(user.MaxDelay, MaxUserDelay)
+	return []string{
+		"user:" + username,
+		"ip:" + ip,
+		"pair:" + username + "|" + ip,
+	}, []ThrottlePolicy{
+		user,
+		p.throttle.IP,
+		p.throttle.UserIP,
+	}
+}
+
+// CheckLoginThrottle returns how long the client has to wait before the
+// next login attempt for username is allowed (0 if it is allowed now).
+func (s *Sqlite) CheckLoginThrottle(username, ip string) (_ time.Duration, err error) {
+	_, span := s.trace("CheckLoginThrottle")
+	defer finishSpan(span, &err)
+
+	keys, _ := s.throttleKeys(username, ip)
+
+	query := `SELECT MAX(blocked_until) FROM login_throttle WHERE key IN (?, ?, ?)`
+	var blockedUntil sql.NullInt64
+	if err := s.db.QueryRow(query, keys[0], keys[1], keys[2]).Scan(&blockedUntil); err != nil {
+		return 0, fmt.Errorf("check login throttle: %w", err)
+	}
+
+	if !blockedUntil.Valid {
+		return 0, nil
+	}
+	return max(time.Until(time.UnixMilli(blockedUntil.Int64)), 0), nil
+}
+
+// RecordLoginFailure atomically counts a failed login for all keys and
+// returns the resulting delay before the next attempt (0 if none).
+// Failures are recorded for unknown usernames too, so the throttle does
+// not reveal which accounts exist.
+func (s *Sqlite) RecordLoginFailure(username, ip string) (_ time.Duration, err error) {
+	_, span := s.trace("RecordLoginFailure")
+	defer finishSpan(span, &err)
+
+	keys, policies := s.throttleKeys(username, ip)
+	now := time.Now()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Failures outside the window start a new count
+	upsert := `
+		INSERT INTO login_throttle (key, failures, last_failure)
+		VALUES (?, 1, ?)
+		ON CONFLICT(key) DO UPDATE SET
+			failures = CASE WHEN login_throttle.last_failure < ? THEN 1 ELSE login_throttle.failures + 1 END,
+			last_failure = excluded.last_failure
+		RETURNING failures
+	`
+	block := `UPDATE login_throttle SET blocked_until = ? WHERE key = ?`
+
+	var longest time.Duration
+	for i, key := range keys {
+		policy := policies[i]
+		if policy.Threshold <= 0 {
+			continue
+		}
+
+		var failures int
+		windowStart := now.Add(-policy.Window).UnixMilli()
+		if err := tx.QueryRow(upsert, key, now.UnixMilli(), windowStart).Scan(&failures); err != nil {
+			return 0, fmt.Errorf("record login failure: %w", err)
+		}
+
+		if d := policy.delay(failures); d > 0 {
+			if _, err := tx.Exec(block, now.Add(d).UnixMilli(), key); err != nil {
+				return 0, fmt.Errorf("block login: %w", err)
+			}
+			longest = max(longest, d)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit transaction: %w", err)
+	}
+	return longest, nil
+}
+
+// ResetLoginFailures clears the (username, IP) counter after a successful
+// login. The username and IP counters are kept, so neither the owner nor an
+// attacker logging into an own account resets the count of other clients.
+func (s *Sqlite) ResetLoginFailures(username, ip string) (err error) {
+	_, span := s.trace("ResetLoginFailures")
+	defer finishSpan(span, &err)
+
+	keys, _ := s.throttleKeys(username, ip)
+
+	query := `DELETE FROM login_throttle WHERE key = ?`
+	if _, err := s.db.Exec(query, keys[2]); err != nil {
+		return fmt.Errorf("reset login failures: %w", err)
+	}
+	return nil
+}
+
+// PruneLoginThrottle deletes entries that are neither within a window nor
+// blocked. It runs in the background every throttlePruneInterval, not on
+// failed logins, so attackers cannot make every failure pay for a scan.
+func (s *Sqlite) PruneLoginThrottle() (err error) {
+	_, span := s.trace("PruneLoginThrottle")
+	defer finishSpan(span, &err)
+
+	now := time.Now()
+	query := `DELETE FROM login_throttle WHERE last_failure < ? AND (blocked_until IS NULL OR blocked_until < ?)`
+	if _, err := s.db.Exec(query, now.Add(-s.maxThrottleWindow()).UnixMilli(), now.UnixMilli()); err != nil {
+		return fmt.Errorf("prune login throttle: %w", err)
+	}
+	return nil
+}
+
+// throttlePruneInterval is how often stale throttle entries are deleted.
+const throttlePruneInterval = time.Minute
+
+// pruner calls prune every throttlePruneInterval until it is closed.
+type pruner struct {
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func startPruner(prune func() error) *pruner {
+	p := &pruner{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+
+		ticker := time.NewTicker(throttlePruneInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+			}
+			if err := prune(); err != nil {
+				slog.Warn("Failed to prune login throttle", "error", err)
+			}
+		}
+	}()
+	return p
+}
+
+// Close stops the goroutine and waits until it has exited.
+func (p *pruner) Close() {
+	p.closeOnce.Do(func() { close(p.stop) })
+	<-p.done
+}
+
+// Running reports whether the goroutine is still running.
+func (p *pruner) Running() bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (p *policy) maxThrottleWindow() time.Duration {
+	return max(p.throttle.User.Window, p.throttle.IP.Window, p.throttle.UserIP.Window)
+}
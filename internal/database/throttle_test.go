@@ -0,0 +1,189 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func setupThrottleDB(t *testing.T, cfg LoginThrottle) *Sqlite {
+	t.Helper()
+	repo, err := New(filepath.Join(t.TempDir(), "test_throttle.db"))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+
+	db := repo.(*Sqlite)
+	if err := db.InitSchema(); err != nil {
+		t.Fatalf("InitSchema() failed: %v", err)
+	}
+	db.SetLoginThrottle(cfg)
+	return db
+}
+
+func TestThrottlePolicyDelay(t *testing.T) {
+	p := ThrottlePolicy{Threshold: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, 1 * time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{6, 8 * time.Second},
+		{7, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+
+	if got := (ThrottlePolicy{}).delay(100); got != 0 {
+		t.Errorf("Disabled policy delay = %v, want 0", got)
+	}
+}
+
+// TestLoginThrottlePerPair verifies exponential backoff for one user from one IP.
+func TestLoginThrottlePerPair(t *testing.T) {
+	db := setupThrottleDB(t, LoginThrottle{
+		UserIP: ThrottlePolicy{Threshold: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour},
+	})
+
+	if wait, _ := db.RecordLoginFailure("alice", "10.0.0.1"); wait != 0 {
+		t.Errorf("First failure: expected no delay, got %v", wait)
+	}
+	if wait, _ := db.RecordLoginFailure("alice", "10.0.0.1"); wait != time.Minute {
+		t.Errorf("Second failure: expected 1m delay, got %v", wait)
+	}
+	if wait, _ := db.RecordLoginFailure("alice", "10.0.0.1"); wait != 2*time.Minute {
+		t.Errorf("Third failure: expected 2m delay, got %v", wait)
+	}
+
+	wait, err := db.CheckLoginThrottle("alice", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("CheckLoginThrottle() failed: %v", err)
+	}
+	if wait <= time.Minute || wait > 2*time.Minute {
+		t.Errorf("Expected remaining wait of about 2m, got %v", wait)
+	}
+
+	// The victim can still log in from another IP
+	if wait, _ := db.CheckLoginThrottle("alice", "10.0.0.2"); wait != 0 {
+		t.Errorf("Other IP should not be throttled, got %v", wait)
+	}
+
+	if err := db.ResetLoginFailures("alice", "10.0.0.1"); err != nil {
+		t.Fatalf("ResetLoginFailures() failed: %v", err)
+	}
+	if wait, _ := db.CheckLoginThrottle("alice", "10.0.0.1"); wait != 0 {
+		t.Errorf("After reset: expected no delay, got %v", wait)
+	}
+}
+
+// TestLoginThrottlePasswordSpray verifies that one IP is throttled across many accounts.
+func TestLoginThrottlePasswordSpray(t *testing.T) {
+	db := setupThrottleDB(t, LoginThrottle{
+		IP:     ThrottlePolicy{Threshold: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour},
+		UserIP: ThrottlePolicy{Threshold: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour},
+	})
+
+	for _, user := range []string{"u1", "u2", "u3"} {
+		db.RecordLoginFailure(user, "10.0.0.9")
+	}
+
+	// A fourth, never-tried account is blocked from the same IP
+	if wait, _ := db.CheckLoginThrottle("u4", "10.0.0.9"); wait == 0 {
+		t.Error("Spraying IP should be throttled")
+	}
+	if wait, _ := db.CheckLoginThrottle("u4", "10.0.0.10"); wait != 0 {
+		t.Errorf("Other IP should not be throttled, got %v", wait)
+	}
+
+	// A successful login does not reset the IP counter
+	db.ResetLoginFailures("u1", "10.0.0.9")
+	if wait, _ := db.CheckLoginThrottle("u1", "10.0.0.9"); wait == 0 {
+		t.Error("IP counter must survive a successful login")
+	}
+}
+
+// TestLoginThrottleWindow verifies that old failures are forgotten.
+func TestLoginThrottleWindow(t *testing.T) {
+	db := setupThrottleDB(t, LoginThrottle{
+		User: ThrottlePolicy{Threshold: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour},
+	})
+
+	db.RecordLoginFailure("bob", "10.0.0.1")
+
+	// Move the failure out of the window
+	old := time.Now().Add(-2 * time.Hour).UnixMilli()
+	if _, err := db.DB().Exec("UPDATE login_throttle SET last_failure = ?", old); err != nil {
+		t.Fatalf("Failed to age failure: %v", err)
+	}
+
+	if wait, _ := db.RecordLoginFailure("bob", "10.0.0.2"); wait != 0 {
+		t.Errorf("Failure outside the window must start a new count, got delay %v", wait)
+	}
+}
+
+// throttlePruner is a backend that prunes its login throttle in the background.
+type throttlePruner interface {
+	Backend
+	PruneLoginThrottle() error
+	Running() bool
+}
+
+// testPruneLoginThrottle checks that failed logins leave stale rows alone
+// and PruneLoginThrottle deletes them.
+func testPruneLoginThrottle(t *testing.T, db throttlePruner) {
+	t.Helper()
+	db.SetLoginThrottle(LoginThrottle{
+		IP: ThrottlePolicy{Threshold: 100, BaseDelay: time.Second, MaxDelay: time.Second, Window: time.Hour},
+	})
+	if !db.Running() {
+		t.Error("Running() = false, want the pruner running")
+	}
+
+	count := func() int {
+		var n int
+		if err := db.DB().QueryRow(`SELECT COUNT(*) FROM login_throttle`).Scan(&n); err != nil {
+			t.Fatalf("Failed to count throttle rows: %v", err)
+		}
+		return n
+	}
+
+	if _, err := db.RecordLoginFailure("alice", "10.0.0.1"); err != nil {
+		t.Fatalf("RecordLoginFailure() failed: %v", err)
+	}
+	// Older than every window and not blocked
+	if _, err := db.DB().Exec(`UPDATE login_throttle SET last_failure = 0, blocked_until = NULL`); err != nil {
+		t.Fatalf("Failed to age throttle rows: %v", err)
+	}
+	if _, err := db.RecordLoginFailure("bob", "10.0.0.2"); err != nil {
+		t.Fatalf("RecordLoginFailure() failed: %v", err)
+	}
+	if got := count(); got != 2 {
+		t.Errorf("Rows after failed logins = %d, want 2 (no pruning on the hot path)", got)
+	}
+
+	if err := db.PruneLoginThrottle(); err != nil {
+		t.Fatalf("PruneLoginThrottle() failed: %v", err)
+	}
+	if got := count(); got != 1 {
+		t.Errorf("Rows after PruneLoginThrottle() = %d, want 1", got)
+	}
+
+	db.Close()
+	if db.Running() {
+		t.Error("Running() after Close() = true, want false")
+	}
+}
+
+func TestPruneLoginThrottle(t *testing.T) {
+	testPruneLoginThrottle(t, setupThrottleDB(t, LoginThrottle{}))
+}
@@ -1,13 +1,14 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"foodshop/internal/models"
+	"foodshop/internal/passhash"
+	"log/slog"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
@@ -19,6 +20,8 @@ var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	// ErrAccountLocked is returned when account is locked due to failed attempts.
 	ErrAccountLocked = errors.New("account locked")
+	// ErrPasswordReused is returned when a new password matches the current or a recent one.
+	ErrPasswordReused = errors.New("password was used recently")
 )
 
 const (
@@ -38,45 +41,88 @@ type UserRepository interface {
 	DeactivateUser(id int64) error
 	ActivateUser(id int64) error
 	VerifyPassword(username, password string) (*models.User, error)
+	SetLanguage(id int64, language string) error
+
+	// Account lockout
+	IsAccountLocked(username string) (bool, time.Time, error)
+	IncrementFailedAttempts(username string) error
+	LockAccount(username string, duration time.Duration) error
+	ResetFailedAttempts(username string) error
+	UnlockAccount(username string) error
+	GetFailedAttempts(username string) (int, error)
+}
+
+// AuthRepository defines the login throttle and password policy checks.
+type AuthRepository interface {
+	CheckLoginThrottle(username, ip string) (time.Duration, error)
+	RecordLoginFailure(username, ip string) (time.Duration, error)
+	ResetLoginFailures(username, ip string) error
+	IsPasswordExpired(user *models.User) bool
+}
+
+// Store is what the HTTP handlers depend on. Every backend (Sqlite,
+// Postgres, Memory) implements it and must pass the conformance tests in
+// store_test.go.
+type Store interface {
+	UserRepository
+	AuthRepository
+	// WithContext returns a Store whose spans are children of the span
+	// in ctx, typically the request context.
+	WithContext(ctx context.Context) Store
 }
 
 // UpdateUser aktualisiert Passwort (optional) und E-Mail eines Users anhand des Usernames.
 // Der Username bleibt unveränderbar.
-func (s *Sqlite) UpdateUser(username, password, email string) (*models.User, error) {
+// Ein neues Passwort darf weder dem aktuellen noch einem der letzten
+// Passwörter aus der Historie entsprechen (ErrPasswordReused).
+func (s *Sqlite) UpdateUser(username, password, email string) (_ *models.User, err error) {
+	s, span := s.trace("UpdateUser")
+	defer finishSpan(span, &err)
+
 	// Hole aktuellen User
 	user, err := s.GetUserByUsername(username)
 	if err != nil {
 		return nil, err
 	}
 
-	// Passwort: Wenn leer, nicht ändern, sonst hashen
-	var hashedPassword string
+	// Nur E-Mail ändern
 	if password == "" {
-		hashedPassword = user.Password
-	} else {
-		hp, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		query := `
+		       UPDATE users
+		       SET email = ?
+		       WHERE username = ?
+	       `
+		result, err := s.db.Exec(query, email, username)
+		if err != nil {
+			return nil, fmt.Errorf("update user: %w", err)
+		}
+		rowsAffected, err := result.RowsAffected()
 		if err != nil {
-			return nil, fmt.Errorf("hash password: %w", err)
+			return nil, fmt.Errorf("get rows affected: %w", err)
 		}
-		hashedPassword = string(hp)
+		if rowsAffected == 0 {
+			return nil, ErrUserNotFound
+		}
+		return s.GetUserByUsername(username)
 	}
 
-	// Update durchführen (Username bleibt gleich)
-	query := `
-	       UPDATE users
-	       SET password = ?, email = ?
-	       WHERE username = ?
-       `
-	result, err := s.db.Exec(query, hashedPassword, email, username)
+	// Passwort-Wiederverwendung verhindern
+	reused, err := s.isPasswordReused(user, password)
 	if err != nil {
-		return nil, fmt.Errorf("update user: %w", err)
+		return nil, err
 	}
-	rowsAffected, err := result.RowsAffected()
+	if reused {
+		return nil, ErrPasswordReused
+	}
+
+	hashedPassword, pepperVersion, err := s.hashPassword(s.context(), password)
 	if err != nil {
-		return nil, fmt.Errorf("get rows affected: %w", err)
+		return nil, err
 	}
-	if rowsAffected == 0 {
-		return nil, ErrUserNotFound
+
+	// Update und Historie in einer Transaktion (Username bleibt gleich)
+	if err := s.changePassword(user, hashedPassword, pepperVersion, email); err != nil {
+		return nil, err
 	}
 
 	// Aktualisierten User zurückgeben
@@ -84,27 +130,30 @@ func (s *Sqlite) UpdateUser(username, password, email string) (*models.User, err
 }
 
 // CreateUser creates a new user with hashed password.
-func (s *Sqlite) CreateUser(username, password, email string) (*models.User, error) {
+func (s *Sqlite) CreateUser(username, password, email string) (_ *models.User, err error) {
+	s, span := s.trace("CreateUser")
+	defer finishSpan(span, &err)
+
 	// Validate input
 	if username == "" || password == "" {
 		return nil, ErrInvalidCredentials
 	}
 
-	// Hash password using bcrypt
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	// Hash password (argon2id by default, peppered if configured)
+	hashedPassword, pepperVersion, err := s.hashPassword(s.context(), password)
 	if err != nil {
-		return nil, fmt.Errorf("hash password: %w", err)
+		return nil, err
 	}
 
 	// Insert user
 	query := `
-		INSERT INTO users (username, password, email, is_active)
-		VALUES (?, ?, ?, 1)
+		INSERT INTO users (username, password, pepper_version, email, is_active, password_changed_at)
+		VALUES (?, ?, ?, ?, 1, ?)
 	`
-	result, err := s.db.Exec(query, username, string(hashedPassword), email)
+	result, err := s.db.Exec(query, username, hashedPassword, pepperVersion, email, time.Now())
 	if err != nil {
 		// Check for unique constraint violation (username already exists)
-		if err.Error() == "UNIQUE constraint failed: users.username" {
+		if isSqliteUniqueViolation(err) {
 			return nil, ErrUserExists
 		}
 		return nil, fmt.Errorf("insert user: %w", err)
@@ -120,10 +169,13 @@ func (s *Sqlite) CreateUser(username, password, email string) (*models.User, err
 }
 
 // GetUserByUsername retrieves a user by username.
-func (s *Sqlite) GetUserByUsername(username string) (*models.User, error) {
+func (s *Sqlite) GetUserByUsername(username string) (_ *models.User, err error) {
+	_, span := s.trace("GetUserByUsername")
+	defer finishSpan(span, &err)
+
 	query := `
 		SELECT id, username, password, email, is_active, created_at, deactived_at,
-		       failed_login_attempts, locked_until
+		       failed_login_attempts, locked_until, pepper_version, password_changed_at, language
 		FROM users
 		WHERE username = ?
 	`
@@ -131,8 +183,9 @@ func (s *Sqlite) GetUserByUsername(username string) (*models.User, error) {
 	user := &models.User{}
 	var deactivedAt sql.NullTime
 	var lockedUntil sql.NullTime
+	var passwordChangedAt sql.NullTime
 
-	err := s.db.QueryRow(query, username).Scan(
+	err = s.db.QueryRow(query, username).Scan(
 		&user.ID,
 		&user.Username,
 		&user.Password,
@@ -142,6 +195,9 @@ func (s *Sqlite) GetUserByUsername(username string) (*models.User, error) {
 		&deactivedAt,
 		&user.FailedLoginAttempts,
 		&lockedUntil,
+		&user.PepperVersion,
+		&passwordChangedAt,
+		&user.Language,
 	)
 
 	if err == sql.ErrNoRows {
@@ -157,15 +213,21 @@ func (s *Sqlite) GetUserByUsername(username string) (*models.User, error) {
 	if lockedUntil.Valid {
 		user.LockedUntil = &lockedUntil.Time
 	}
+	if passwordChangedAt.Valid {
+		user.PasswordChangedAt = &passwordChangedAt.Time
+	}
 
 	return user, nil
 }
 
 // GetUserByID retrieves a user by ID.
-func (s *Sqlite) GetUserByID(id int64) (*models.User, error) {
+func (s *Sqlite) GetUserByID(id int64) (_ *models.User, err error) {
+	_, span := s.trace("GetUserByID")
+	defer finishSpan(span, &err)
+
 	query := `
 		SELECT id, username, password, email, is_active, created_at, deactived_at,
-		       failed_login_attempts, locked_until
+		       failed_login_attempts, locked_until, pepper_version, password_changed_at, language
 		FROM users
 		WHERE id = ?
 	`
@@ -173,8 +235,9 @@ func (s *Sqlite) GetUserByID(id int64) (*models.User, error) {
 	user := &models.User{}
 	var deactivedAt sql.NullTime
 	var lockedUntil sql.NullTime
+	var passwordChangedAt sql.NullTime
 
-	err := s.db.QueryRow(query, id).Scan(
+	err = s.db.QueryRow(query, id).Scan(
 		&user.ID,
 		&user.Username,
 		&user.Password,
@@ -184,6 +247,9 @@ func (s *Sqlite) GetUserByID(id int64) (*models.User, error) {
 		&deactivedAt,
 		&user.FailedLoginAttempts,
 		&lockedUntil,
+		&user.PepperVersion,
+		&passwordChangedAt,
+		&user.Language,
 	)
 
 	if err == sql.ErrNoRows {
@@ -199,6 +265,9 @@ func (s *Sqlite) GetUserByID(id int64) (*models.User, error) {
 	if lockedUntil.Valid {
 		user.LockedUntil = &lockedUntil.Time
 	}
+	if passwordChangedAt.Valid {
+		user.PasswordChangedAt = &passwordChangedAt.Time
+	}
 
 	return user, nil
 }
@@ -206,6 +275,11 @@ func (s *Sqlite) GetUserByID(id int64) (*models.User, error) {
 // DeleteUser permanently deletes a user from the database.
 // Note: This is a hard delete. Consider using DeactivateUser for soft deletes.
 func (s *Sqlite) DeleteUser(id int64) error {
+	// Remove the password history first (sqlite foreign keys are not enforced by default)
+	if _, err := s.db.Exec(`DELETE FROM password_history WHERE user_id = ?`, id); err != nil {
+		return fmt.Errorf("delete password history: %w", err)
+	}
+
 	query := `DELETE FROM users WHERE id = ?`
 
 	result, err := s.db.Exec(query, id)
@@ -225,6 +299,26 @@ func (s *Sqlite) DeleteUser(id int64) error {
 	return nil
 }
 
+// SetLanguage stores the preferred language of a user ("" = negotiate
+// from Accept-Language).
+func (s *Sqlite) SetLanguage(id int64, language string) error {
+	result, err := s.db.Exec(`UPDATE users SET language = ? WHERE id = ?`, language, id)
+	if err != nil {
+		return fmt.Errorf("set language: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
 // DeactivateUser soft-deletes a user by setting is_active to false.
 func (s *Sqlite) DeactivateUser(id int64) error {
 	query := `
@@ -277,7 +371,12 @@ func (s *Sqlite) ActivateUser(id int64) error {
 
 // VerifyPassword checks if the provided password matches the stored hash.
 // Returns the user if credentials are valid.
-func (s *Sqlite) VerifyPassword(username, password string) (*models.User, error) {
+// Outdated hashes (bcrypt, old argon2id parameters or an old pepper version)
+// are transparently upgraded after a successful check.
+func (s *Sqlite) VerifyPassword(username, password string) (_ *models.User, err error) {
+	s, span := s.trace("VerifyPassword")
+	defer finishSpan(span, &err)
+
 	user, err := s.GetUserByUsername(username)
 	if err != nil {
 		return nil, err
@@ -288,13 +387,85 @@ func (s *Sqlite) VerifyPassword(username, password string) (*models.User, error)
 		return nil, ErrInvalidCredentials
 	}
 
+	if err := s.checkPassword(s.context(), user, password); err != nil {
+		return nil, err
+	}
+
+	// Rehash on login: the plain password is only available right now
+	if s.needsRehash(user) {
+		if err := s.rehashPassword(user, password); err != nil {
+			// Login still succeeds; the upgrade is retried on the next login
+			slog.WarnContext(s.context(), "Password rehash failed", "user_id", user.ID, "error", err)
+		}
+	}
+
+	return user, nil
+}
+
+// checkPassword compares the plain password with the user's hash. Every
+// failure is reported as ErrInvalidCredentials; unexpected ones are logged.
+func (p *policy) checkPassword(ctx context.Context, user *models.User, plain string) error {
+	// Mix in the pepper the hash was created with
+	peppered, err := p.pepper.Apply(plain, user.PepperVersion)
+	if err != nil {
+		slog.ErrorContext(ctx, "Cannot verify password hash", "user_id", user.ID, "error", err)
+		return ErrInvalidCredentials
+	}
+
 	// Compare password with hash
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	_, span := tracer.Start(ctx, "passhash.Verify")
+	err = p.hasher.Verify(user.Password, peppered)
+	span.End()
 	if err != nil {
-		return nil, ErrInvalidCredentials
+		if !errors.Is(err, passhash.ErrMismatch) {
+			slog.ErrorContext(ctx, "Cannot verify password hash", "user_id", user.ID, "error", err)
+		}
+		return ErrInvalidCredentials
 	}
+	return nil
+}
 
-	return user, nil
+// needsRehash reports whether the user's hash uses outdated hasher
+// parameters or an old pepper version.
+func (p *policy) needsRehash(user *models.User) bool {
+	return p.hasher.NeedsRehash(user.Password) || user.PepperVersion != p.pepper.Current()
+}
+
+// hashPassword peppers the password with the current pepper version and
+// hashes it. It returns the hash and the pepper version to store with it.
+func (p *policy) hashPassword(ctx context.Context, plain string) (string, int, error) {
+	version := p.pepper.Current()
+	peppered, err := p.pepper.Apply(plain, version)
+	if err != nil {
+		return "", 0, fmt.Errorf("pepper password: %w", err)
+	}
+
+	_, span := tracer.Start(ctx, "passhash.Hash")
+	hash, err := p.hasher.Hash(peppered)
+	span.End()
+	if err != nil {
+		return "", 0, fmt.Errorf("hash password: %w", err)
+	}
+
+	return hash, version, nil
+}
+
+// rehashPassword stores a new hash of the password using the current hasher and pepper.
+func (s *Sqlite) rehashPassword(user *models.User, plain string) error {
+	hash, version, err := s.hashPassword(s.context(), plain)
+	if err != nil {
+		return err
+	}
+
+	// Only replace the hash we verified against, in case it changed concurrently
+	query := `UPDATE users SET password = ?, pepper_version = ? WHERE id = ? AND password = ?`
+	if _, err := s.db.Exec(query, hash, version, user.ID, user.Password); err != nil {
+		return fmt.Errorf("update password hash: %w", err)
+	}
+
+	user.Password = hash
+	user.PepperVersion = version
+	return nil
 }
 
 // IsAccountLocked checks if a user account is currently locked.
@@ -1,9 +1,17 @@
 package database
 
 import (
+	"context"
+	"foodshop/internal/passhash"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestUpdateUser(t *testing.T) {
@@ -338,6 +346,108 @@ func TestVerifyPassword(t *testing.T) {
 	}
 }
 
+// TestVerifyPasswordRehash verifies that legacy bcrypt hashes are upgraded to argon2id on login.
+func TestVerifyPasswordRehash(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test_verify_rehash.db")
+
+	repo, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer repo.Close()
+
+	db := repo.(*Sqlite)
+	if err := db.InitSchema(); err != nil {
+		t.Fatalf("InitSchema() failed: %v", err)
+	}
+
+	// Simulate a row created before argon2id was introduced
+	legacy, err := passhash.NewBcrypt(4).Hash("password123")
+	if err != nil {
+		t.Fatalf("bcrypt Hash() failed: %v", err)
+	}
+	if _, err := db.DB().Exec("INSERT INTO users (username, password, email) VALUES (?, ?, ?)", "legacyuser", legacy, "legacy@example.com"); err != nil {
+		t.Fatalf("Failed to insert legacy user: %v", err)
+	}
+
+	// Wrong password must not touch the hash
+	if _, err := db.VerifyPassword("legacyuser", "wrongpassword"); err != ErrInvalidCredentials {
+		t.Errorf("Expected ErrInvalidCredentials, got %v", err)
+	}
+
+	user, err := db.VerifyPassword("legacyuser", "password123")
+	if err != nil {
+		t.Fatalf("VerifyPassword() with legacy hash failed: %v", err)
+	}
+	if !strings.HasPrefix(user.Password, "$argon2id$") {
+		t.Errorf("Expected upgraded argon2id hash, got %s", user.Password)
+	}
+
+	stored, err := db.GetUserByUsername("legacyuser")
+	if err != nil {
+		t.Fatalf("GetUserByUsername() failed: %v", err)
+	}
+	if !strings.HasPrefix(stored.Password, "$argon2id$") {
+		t.Errorf("Expected stored hash to be argon2id, got %s", stored.Password)
+	}
+
+	// Login still works with the upgraded hash
+	if _, err := db.VerifyPassword("legacyuser", "password123"); err != nil {
+		t.Errorf("VerifyPassword() after rehash failed: %v", err)
+	}
+}
+
+// TestVerifyPasswordPepperRotation verifies that hashes move to the current pepper version on login.
+func TestVerifyPasswordPepperRotation(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test_verify_pepper.db")
+
+	repo, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer repo.Close()
+
+	db := repo.(*Sqlite)
+	if err := db.InitSchema(); err != nil {
+		t.Fatalf("InitSchema() failed: %v", err)
+	}
+
+	// User created without pepper
+	if _, err := db.CreateUser("pepperuser", "password123", "pepper@example.com"); err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+
+	// Enable pepper version 1: the old hash still verifies and is migrated
+	v1, _ := passhash.ParsePepper("1:first")
+	db.SetPepper(v1)
+	user, err := db.VerifyPassword("pepperuser", "password123")
+	if err != nil {
+		t.Fatalf("VerifyPassword() after enabling pepper failed: %v", err)
+	}
+	if user.PepperVersion != 1 {
+		t.Errorf("Expected pepper version 1, got %d", user.PepperVersion)
+	}
+
+	// Rotate to version 2, keeping version 1 for migration
+	v2, _ := passhash.ParsePepper("1:first,2:second")
+	db.SetPepper(v2)
+	if _, err := db.VerifyPassword("pepperuser", "password123"); err != nil {
+		t.Fatalf("VerifyPassword() after rotation failed: %v", err)
+	}
+	stored, _ := db.GetUserByUsername("pepperuser")
+	if stored.PepperVersion != 2 {
+		t.Errorf("Expected stored pepper version 2, got %d", stored.PepperVersion)
+	}
+
+	// Without the pepper the hash can no longer be verified
+	db.SetPepper(nil)
+	if _, err := db.VerifyPassword("pepperuser", "password123"); err != ErrInvalidCredentials {
+		t.Errorf("Expected ErrInvalidCredentials without pepper, got %v", err)
+	}
+}
+
 // TestVerifyPasswordDeactivatedUser verifies that deactivated users can't login.
 func TestVerifyPasswordDeactivatedUser(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -565,3 +675,45 @@ func TestAccountLockoutNonExistentUser(t *testing.T) {
 		t.Errorf("UnlockAccount should succeed silently for non-existent user, got %v", err)
 	}
 }
+
+// TestVerifyPasswordSpans verifies that repository spans nest below the
+// span of the context passed to WithContext.
+func TestVerifyPasswordSpans(t *testing.T) {
+	db := setupHistoryDB(t)
+	if _, err := db.CreateUser("traced", "password123", ""); err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	defer provider.Shutdown(context.Background())
+
+	ctx, request := provider.Tracer("test").Start(context.Background(), "POST /login")
+	if _, err := db.WithContext(ctx).VerifyPassword("traced", "wrong"); err != ErrInvalidCredentials {
+		t.Fatalf("VerifyPassword() error = %v, want ErrInvalidCredentials", err)
+	}
+	request.End()
+
+	parents := make(map[string]string)
+	ids := make(map[string]string)
+	for _, s := range recorder.Ended() {
+		ids[s.Name()] = s.SpanContext().SpanID().String()
+		parents[s.Name()] = s.Parent().SpanID().String()
+	}
+	want := map[string]string{
+		"db.VerifyPassword":    "POST /login",
+		"db.GetUserByUsername": "db.VerifyPassword",
+		"passhash.Verify":      "db.VerifyPassword",
+	}
+	for name, parent := range want {
+		if parents[name] == "" || parents[name] != ids[parent] {
+			t.Errorf("Span %s is not a child of %s (spans: %v)", name, parent, ids)
+		}
+	}
+	for _, s := range recorder.Ended() {
+		if s.Name() == "db.VerifyPassword" && s.Status().Code != codes.Error {
+			t.Error("db.VerifyPassword span not marked as error for invalid credentials")
+		}
+	}
+}
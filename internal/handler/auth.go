@@ -3,112 +3,103 @@ package handler
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"foodshop/internal/auth"
 	"foodshop/internal/database"
+	"foodshop/internal/i18n"
+	"foodshop/internal/logging"
+	"foodshop/internal/metrics"
+	"foodshop/internal/middleware"
 	"foodshop/internal/models"
+	"foodshop/internal/problem"
 	"foodshop/internal/validator"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer starts the span for token signing.
+var tracer = otel.Tracer("foodshop/internal/handler")
+
+var (
+	loginAttempts = metrics.Default.NewCounterVec("auth_login_attempts_total",
+		"Login attempts with syntactically valid input by result (success, failure, throttled, password_expired).", "result")
+	loginLockouts = metrics.Default.NewCounter("auth_lockouts_total",
+		"Failed credential checks that blocked further attempts (login throttle).")
+	tokenRefreshes = metrics.Default.NewCounterVec("auth_token_refreshes_total",
+		"Refresh token exchanges by result (success, failure).", "result")
 )
 
 // LoginHandler handles user login and returns JWT token
-func LoginHandler(db *database.Sqlite) http.HandlerFunc {
+func LoginHandler(db database.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "POST" {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
+		db := db.WithContext(r.Context())
 		w.Header().Set("Content-Type", "application/json")
 		var loginReq struct {
 			Username string `json:"username"`
 			Password string `json:"password"`
 		}
-		if err := json.NewDecoder(r.Body).Decode(&loginReq); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: "Invalid request data",
-			})
+		if !decodeJSON(w, r, &loginReq) {
 			return
 		}
 		loginReq.Username = validator.SanitizeInput(loginReq.Username)
 		if loginReq.Username == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: "Username is required",
-			})
+			problem.Write(w, r, required("username"))
 			return
 		}
 		if loginReq.Password == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: "Password is required",
-			})
+			problem.Write(w, r, required("password"))
 			return
 		}
-		isLocked, lockedUntil, err := db.IsAccountLocked(loginReq.Username)
-		if err != nil && !errors.Is(err, database.ErrUserNotFound) {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: "Internal server error",
-			})
+		ip := middleware.ClientIP(r)
+		wait, err := db.CheckLoginThrottle(loginReq.Username, ip)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to check login throttle", "error", err)
+			problem.Error(w, r, problem.CodeInternal)
 			return
 		}
-		if isLocked {
-			remainingTime := time.Until(lockedUntil)
-			minutes := int(remainingTime.Minutes())
-			w.WriteHeader(http.StatusLocked)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: fmt.Sprintf("Account locked due to too many failed login attempts. Try again in %d minutes.", minutes),
-			})
+		if wait > 0 {
+			loginAttempts.With("throttled").Inc()
+			writeLoginThrottled(w, r, wait)
 			return
 		}
 		user, err := db.VerifyPassword(loginReq.Username, loginReq.Password)
 		if err != nil {
-			if !errors.Is(err, database.ErrUserNotFound) {
-				db.IncrementFailedAttempts(loginReq.Username)
-				attempts, _ := db.GetFailedAttempts(loginReq.Username)
-				if attempts >= database.MaxLoginAttempts {
-					db.LockAccount(loginReq.Username, database.LockoutDuration)
-					w.WriteHeader(http.StatusLocked)
-					json.NewEncoder(w).Encode(models.ErrUserLogin{
-						Message: fmt.Sprintf("Account locked due to too many failed login attempts. Try again in %d minutes.", int(database.LockoutDuration.Minutes())),
-					})
-					return
-				}
-				remainingAttempts := database.MaxLoginAttempts - attempts
-				w.WriteHeader(http.StatusUnauthorized)
-				json.NewEncoder(w).Encode(models.ErrUserLogin{
-					Message: fmt.Sprintf("Invalid username or password. %d attempts remaining.", remainingAttempts),
-				})
-				return
-			}
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: "Invalid username or password",
-			})
+			loginAttempts.With("failure").Inc()
+			writeLoginFailure(w, r, db, loginReq.Username, ip)
+			return
+		}
+		db.ResetLoginFailures(loginReq.Username, ip)
+		logging.SetUserID(r.Context(), user.ID)
+		r = withUserLanguage(r, user)
+		if db.IsPasswordExpired(user) {
+			loginAttempts.With("password_expired").Inc()
+			problem.Write(w, r, problem.New(problem.CodePasswordExpired).
+				With("password_change_required", true))
 			return
 		}
-		db.ResetFailedAttempts(loginReq.Username)
-		token, err := auth.GenerateToken(user.ID, user.Username)
+		token, err := signToken(r, "access", auth.GenerateToken, user)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: "Failed to generate authentication token",
-			})
+			slog.ErrorContext(r.Context(), "Failed to generate access token", "error", err)
+			problem.Error(w, r, problem.CodeInternal)
 			return
 		}
-		refreshToken, err := auth.GenerateRefreshToken(user.ID, user.Username)
+		refreshToken, err := signToken(r, "refresh", auth.GenerateRefreshToken, user)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: "Failed to generate refresh token",
-			})
+			slog.ErrorContext(r.Context(), "Failed to generate refresh token", "error", err)
+			problem.Error(w, r, problem.CodeInternal)
 			return
 		}
+		loginAttempts.With("success").Inc()
 		response := models.LoginResponse{
-			Message:      "Login successful",
+			Message:      message(r, "login_successful"),
 			Token:        token,
 			RefreshToken: refreshToken,
 		}
@@ -120,54 +111,139 @@ func LoginHandler(db *database.Sqlite) http.HandlerFunc {
 	}
 }
 
-// LogoutHandler handles user logout by blacklisting the token
-func LogoutHandler(tokenBlacklist *auth.TokenBlacklist) http.HandlerFunc {
+// writeLoginFailure records a failed password check for the throttle and
+// writes the matching error response. The response is the same for unknown
+// users and wrong passwords.
+func writeLoginFailure(w http.ResponseWriter, r *http.Request, db database.Store, username, ip string) {
+	wait, err := db.RecordLoginFailure(username, ip)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to record login failure", "error", err)
+	}
+	if wait > 0 {
+		loginLockouts.Inc()
+		writeLoginThrottled(w, r, wait)
+		return
+	}
+	problem.Error(w, r, problem.CodeInvalidCredentials)
+}
+
+// signToken issues a token for user inside a jwt.Sign span.
+func signToken(r *http.Request, tokenType string, generate func(int64, string) (string, error), user *models.User) (string, error) {
+	_, span := tracer.Start(r.Context(), "jwt.Sign", trace.WithAttributes(attribute.String("jwt.type", tokenType)))
+	token, err := generate(user.ID, user.Username)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	return token, err
+}
+
+// writeLoginThrottled tells the client to back off before the next attempt.
+func writeLoginThrottled(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	problem.Write(w, r, problem.New(problem.CodeLoginThrottled).
+		Args(i18n.Args{"seconds": seconds}).
+		With("retry_after", seconds))
+}
+
+// ChangePasswordHandler changes the password with the current credentials.
+// It works without a token, so users with an expired password can set a new one.
+func ChangePasswordHandler(db database.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "POST" {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		db := db.WithContext(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		var req models.PasswordChange
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		req.Username = validator.SanitizeInput(req.Username)
+		if req.Username == "" || req.Password == "" {
+			p := problem.New(problem.CodeValidationFailed)
+			if req.Username == "" {
+				p.Field("username", "required", i18n.Message{Key: "validation.username.required"})
+			}
+			if req.Password == "" {
+				p.Field("password", "required", i18n.Message{Key: "validation.password.required"})
+			}
+			problem.Write(w, r, p)
+			return
+		}
+		ip := middleware.ClientIP(r)
+		wait, err := db.CheckLoginThrottle(req.Username, ip)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to check login throttle", "error", err)
+			problem.Error(w, r, problem.CodeInternal)
+			return
+		}
+		if wait > 0 {
+			writeLoginThrottled(w, r, wait)
+			return
+		}
+		user, err := db.VerifyPassword(req.Username, req.Password)
+		if err != nil {
+			writeLoginFailure(w, r, db, req.Username, ip)
+			return
+		}
+		db.ResetLoginFailures(req.Username, ip)
+		r = withUserLanguage(r, user)
+		if err := validator.ValidatePassword(req.NewPassword, user.Username, user.Email); err != nil {
+			problem.Write(w, r, invalidAs("new_password", err))
 			return
 		}
+		if req.NewPassword != req.NewPasswordVerification {
+			problem.Write(w, r, mismatch("new_password_verification"))
+			return
+		}
+		if _, err := db.UpdateUser(user.Username, req.NewPassword, user.Email); err != nil {
+			if errors.Is(err, database.ErrPasswordReused) {
+				problem.Write(w, r, reused("new_password"))
+				return
+			}
+			slog.ErrorContext(r.Context(), "Failed to change password", "error", err)
+			problem.Error(w, r, problem.CodeInternal)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": message(r, "password_changed"),
+		})
+	}
+}
+
+// LogoutHandler handles user logout by blacklisting the token
+func LogoutHandler(tokenBlacklist *auth.TokenBlacklist) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: "Missing authorization header",
-			})
+			problem.Error(w, r, problem.CodeMissingToken)
 			return
 		}
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: "Invalid authorization header format",
-			})
+			problem.Write(w, r, problem.New(problem.CodeInvalidToken).Variant("format"))
 			return
 		}
 		tokenString := parts[1]
 		claims, err := auth.ValidateToken(tokenString)
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: "Invalid or expired token",
-			})
+			problem.Error(w, r, problem.CodeInvalidToken)
 			return
 		}
 		tokenBlacklist.Add(tokenString, claims.ExpiresAt.Time)
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]string{
-			"message": "Logout successful",
+			"message": message(r, "logout_successful"),
 		})
 	}
 }
 
 // RegistrationHandler handles user registration requests.
-func RegistrationHandler(db *database.Sqlite) http.HandlerFunc {
+func RegistrationHandler(db database.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "POST" {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
+		db := db.WithContext(r.Context())
 		w.Header().Set("Content-Type", "application/json")
 		var reg struct {
 			Username             string `json:"username"`
@@ -175,68 +251,44 @@ func RegistrationHandler(db *database.Sqlite) http.HandlerFunc {
 			PasswordVerification string `json:"password_verification"`
 			Email                string `json:"email"`
 		}
-		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: "Invalid request data",
-			})
+		if !decodeJSON(w, r, &reg) {
 			return
 		}
 		reg.Username = validator.SanitizeInput(reg.Username)
 		reg.Email = validator.SanitizeInput(reg.Email)
 		if err := validator.ValidateUsername(reg.Username); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: err.Error(),
-			})
+			problem.Write(w, r, problem.Validation(err))
 			return
 		}
-		if err := validator.ValidatePassword(reg.Password); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: err.Error(),
-			})
+		if err := validator.ValidatePassword(reg.Password, reg.Username, reg.Email); err != nil {
+			problem.Write(w, r, problem.Validation(err))
 			return
 		}
 		if reg.PasswordVerification == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: "Password verification is required",
-			})
+			problem.Write(w, r, required("password_verification"))
 			return
 		}
 		if reg.Password != reg.PasswordVerification {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: "Passwords do not match",
-			})
+			problem.Write(w, r, mismatch("password_verification"))
 			return
 		}
 		if err := validator.ValidateEmail(reg.Email); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: err.Error(),
-			})
+			problem.Write(w, r, problem.Validation(err))
 			return
 		}
 		user, err := db.CreateUser(reg.Username, reg.Password, reg.Email)
 		if err != nil {
 			if errors.Is(err, database.ErrUserExists) {
-				w.WriteHeader(http.StatusConflict)
-				json.NewEncoder(w).Encode(models.ErrUserLogin{
-					Message: "Username already exists",
-				})
+				problem.Error(w, r, problem.CodeUserExists)
 				return
 			}
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: "Failed to create user",
-			})
+			slog.ErrorContext(r.Context(), "Failed to create user", "error", err)
+			problem.Error(w, r, problem.CodeInternal)
 			return
 		}
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"message": "User created successfully",
+			"message": message(r, "user_created"),
 			"user": map[string]interface{}{
 				"id":       user.ID,
 				"username": user.Username,
@@ -246,66 +298,55 @@ func RegistrationHandler(db *database.Sqlite) http.HandlerFunc {
 	}
 }
 
-func RefreshHandler(db *database.Sqlite) http.HandlerFunc {
+func RefreshHandler(db database.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "POST" {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
+		db := db.WithContext(r.Context())
 		w.Header().Set("Content-Type", "application/json")
 		var req struct {
 			RefreshToken string `json:"refresh_token"`
 		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: "Missing or invalid refresh_token",
-			})
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.RefreshToken == "" {
+			problem.Write(w, r, required("refresh_token"))
 			return
 		}
 		claims, err := auth.ValidateToken(req.RefreshToken)
 		if err != nil || claims == nil || claims.Issuer != "foodshop-refresh" {
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: "Invalid or expired refresh token",
-			})
+			tokenRefreshes.With("failure").Inc()
+			problem.Error(w, r, problem.CodeInvalidRefreshToken)
 			return
 		}
 		// Optional: Prüfe, ob User noch existiert/aktiv ist
 		user, err := db.GetUserByID(claims.UserID)
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: "User not found",
-			})
+			tokenRefreshes.With("failure").Inc()
+			problem.Write(w, r, problem.New(problem.CodeInvalidRefreshToken).Variant("user_not_found"))
 			return
 		}
+		r = withUserLanguage(r, user)
 		if !user.IsActive {
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: "User is not active",
-			})
+			tokenRefreshes.With("failure").Inc()
+			problem.Write(w, r, problem.New(problem.CodeInvalidRefreshToken).Variant("inactive"))
 			return
 		}
 		// Neue Tokens generieren
-		token, err := auth.GenerateToken(user.ID, user.Username)
+		token, err := signToken(r, "access", auth.GenerateToken, user)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: "Failed to generate token",
-			})
+			slog.ErrorContext(r.Context(), "Failed to generate access token", "error", err)
+			problem.Error(w, r, problem.CodeInternal)
 			return
 		}
-		refreshToken, err := auth.GenerateRefreshToken(user.ID, user.Username)
+		refreshToken, err := signToken(r, "refresh", auth.GenerateRefreshToken, user)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(models.ErrUserLogin{
-				Message: "Failed to generate refresh token",
-			})
+			slog.ErrorContext(r.Context(), "Failed to generate refresh token", "error", err)
+			problem.Error(w, r, problem.CodeInternal)
 			return
 		}
+		tokenRefreshes.With("success").Inc()
 		resp := models.LoginResponse{
-			Message:      "Token refreshed successfully",
+			Message:      message(r, "token_refreshed"),
 			Token:        token,
 			RefreshToken: refreshToken,
 		}
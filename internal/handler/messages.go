@@ -0,0 +1,21 @@
+package handler
+
+import (
+	"foodshop/internal/i18n"
+	"foodshop/internal/models"
+	"net/http"
+)
+
+// message returns the catalog message "message.<name>" in the request language.
+func message(r *http.Request, name string) string {
+	return i18n.Text(i18n.Language(r.Context()), "message."+name, nil)
+}
+
+// withUserLanguage applies the user's language preference to the rest of
+// the request. Without a preference the negotiated language stays.
+func withUserLanguage(r *http.Request, user *models.User) *http.Request {
+	if user == nil || !i18n.Supported(user.Language) {
+		return r
+	}
+	return r.WithContext(i18n.WithLanguage(r.Context(), user.Language))
+}
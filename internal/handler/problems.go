@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"encoding/json"
+	"foodshop/internal/i18n"
+	"foodshop/internal/problem"
+	"net/http"
+)
+
+// decodeJSON reads the request body into v. On failure it writes the
+// problem response (413 for oversized bodies, 400 otherwise) and returns
+// false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		problem.Write(w, r, problem.BodyError(err))
+		return false
+	}
+	return true
+}
+
+// required reports a missing input field.
+func required(field string) *problem.Problem {
+	return validationFailed(field, "required", field)
+}
+
+// mismatch reports a password verification field that differs from the password.
+func mismatch(field string) *problem.Problem {
+	return validationFailed(field, "mismatch", "password")
+}
+
+// reused reports a new password that is still in the password history.
+func reused(field string) *problem.Problem {
+	return validationFailed(field, "reused", "password")
+}
+
+// validationFailed reports field with the catalog message
+// "validation.<rule>.<code>".
+func validationFailed(field, code, rule string) *problem.Problem {
+	return problem.New(problem.CodeValidationFailed).
+		Field(field, code, i18n.Message{Key: "validation." + rule + "." + code})
+}
+
+// invalidAs reports a validator error under the field name of the request,
+// e.g. "new_password" when the password rules fail on a password change.
+func invalidAs(field string, err error) *problem.Problem {
+	p := problem.Validation(err)
+	for i := range p.Errors {
+		p.Errors[i].Field = field
+	}
+	return p
+}
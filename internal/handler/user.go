@@ -2,121 +2,154 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"foodshop/internal/database"
+	"foodshop/internal/i18n"
 	"foodshop/internal/middleware"
-	"foodshop/internal/models"
-	"log"
+	"foodshop/internal/problem"
+	"foodshop/internal/validator"
+	"log/slog"
 	"net/http"
+	"strings"
 )
 
 // UpdateUserRequest repräsentiert das Update-Request-Objekt
 type UpdateUserRequest struct {
 	Password string `json:"password,omitempty"`
 	Email    string `json:"email,omitempty"`
+	// Language setzt die bevorzugte Sprache ("" = Accept-Language verwenden)
+	Language *string `json:"language,omitempty"`
 }
 
-// IndexHandler returns a welcome message
+// IndexHandler returns a welcome message (GET /).
 func IndexHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "GET" {
-			return
-		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{
-			"message": "Welcome to Foodshop API",
+			"message": message(r, "welcome"),
 			"version": "1.0.0",
 		})
 	}
 }
 
 // ProfileHandler returns the authenticated user's profile (protected endpoint)
-func ProfileHandler(db *database.Sqlite) http.HandlerFunc {
+func ProfileHandler(db database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		db := db.WithContext(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			problem.Error(w, r, problem.CodeMissingToken)
+			return
+		}
+		user, err := db.GetUserByID(userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to fetch user profile", "error", err)
+			problem.Error(w, r, problem.CodeInternal)
+			return
+		}
+		r = withUserLanguage(r, user)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"user": map[string]interface{}{
+				"id":         user.ID,
+				"username":   user.Username,
+				"email":      user.Email,
+				"is_active":  user.IsActive,
+				"language":   user.Language,
+				"created_at": user.CreatedAt,
+			},
+		})
+	}
+}
+
+// UpdateProfileHandler updates password, email and language of the
+// authenticated user (PUT and PATCH, protected endpoint)
+func UpdateProfileHandler(db database.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case "POST":
-			w.Header().Set("Content-Type", "application/json")
-			userID, ok := middleware.GetUserID(r)
-			if !ok {
-				w.WriteHeader(http.StatusUnauthorized)
-				json.NewEncoder(w).Encode(models.ErrUserLogin{
-					Message: "Unauthorized",
-				})
+		db := db.WithContext(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			problem.Error(w, r, problem.CodeMissingToken)
+			return
+		}
+
+		user, err := db.GetUserByID(userID)
+		if err != nil {
+			slog.WarnContext(r.Context(), "Profile update for unknown user", "error", err)
+			problem.Error(w, r, problem.CodeUserNotFound)
+			return
+		}
+		r = withUserLanguage(r, user)
+
+		var req UpdateUserRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		var language string
+		if req.Language != nil {
+			language = strings.ToLower(strings.TrimSpace(*req.Language))
+			if language != "" && !i18n.Supported(language) {
+				problem.Write(w, r, problem.New(problem.CodeValidationFailed).
+					Field("language", "unsupported", i18n.Message{
+						Key:  "validation.language.unsupported",
+						Args: i18n.Args{"language": language, "supported": strings.Join(i18n.Languages(), ", ")},
+					}))
 				return
 			}
-			user, err := db.GetUserByID(userID)
-			if err != nil {
-				log.Printf("Error fetching user profile: %v", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(models.ErrUserLogin{
-					Message: "Failed to fetch profile",
-				})
+		}
+
+		// Ein neues Passwort muss dieselben Regeln erfüllen wie bei der Registrierung
+		if req.Password != "" {
+			if err := validator.ValidatePassword(req.Password, user.Username, user.Email, req.Email); err != nil {
+				problem.Write(w, r, problem.Validation(err))
 				return
 			}
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"user": map[string]interface{}{
-					"id":         user.ID,
-					"username":   user.Username,
-					"email":      user.Email,
-					"is_active":  user.IsActive,
-					"created_at": user.CreatedAt,
-				},
-			})
-		case "PUT", "PATCH":
-			w.Header().Set("Content-Type", "application/json")
+		}
 
-			userID, ok := middleware.GetUserID(r)
-			if !ok {
-				w.WriteHeader(http.StatusUnauthorized)
-				json.NewEncoder(w).Encode(models.ErrUserLogin{
-					Message: "Unauthorized",
-				})
+		// Ein Request nur mit der Sprache lässt E-Mail und Passwort unverändert
+		updated := user
+		if req.Language == nil || req.Password != "" || req.Email != "" {
+			updated, err = db.UpdateUser(user.Username, req.Password, req.Email)
+			if errors.Is(err, database.ErrPasswordReused) {
+				problem.Write(w, r, reused("password"))
 				return
 			}
-
-			user, err := db.GetUserByID(userID)
 			if err != nil {
-				log.Printf("UpdateUserHandler: user not found: %v", err)
-				w.WriteHeader(http.StatusNotFound)
-				json.NewEncoder(w).Encode(models.ErrUserLogin{
-					Message: "User not found",
-				})
+				slog.ErrorContext(r.Context(), "Failed to update profile", "error", err)
+				problem.Error(w, r, problem.CodeInternal)
 				return
 			}
+		}
 
-			var req UpdateUserRequest
-			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(models.ErrUserLogin{
-					Message: "Invalid request body",
-				})
+		if req.Language != nil {
+			if err := db.SetLanguage(user.ID, language); err != nil {
+				slog.ErrorContext(r.Context(), "Failed to update language", "error", err)
+				problem.Error(w, r, problem.CodeInternal)
 				return
 			}
-
-			updated, err := db.UpdateUser(user.Username, req.Password, req.Email)
-			if err != nil {
-				log.Printf("UpdateUserHandler: update failed: %v", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(models.ErrUserLogin{
-					Message: "Update failed",
-				})
-				return
+			updated.Language = language
+			// Die Antwort kommt bereits in der neuen Sprache
+			if language == "" {
+				language = i18n.Negotiate(r.Header.Get("Accept-Language"))
 			}
-
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"message": "User updated successfully",
-				"user": map[string]interface{}{
-					"id":         updated.ID,
-					"username":   updated.Username,
-					"email":      updated.Email,
-					"is_active":  updated.IsActive,
-					"created_at": updated.CreatedAt,
-				},
-			})
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+			r = r.WithContext(i18n.WithLanguage(r.Context(), language))
 		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": message(r, "user_updated"),
+			"user": map[string]interface{}{
+				"id":         updated.ID,
+				"username":   updated.Username,
+				"email":      updated.Email,
+				"is_active":  updated.IsActive,
+				"language":   updated.Language,
+				"created_at": updated.CreatedAt,
+			},
+		})
 	}
 }
@@ -0,0 +1,200 @@
+// Package health provides liveness (/healthz) and readiness (/readyz)
+// endpoints for load balancers and orchestrators.
+//
+// Liveness only says that the process serves HTTP; Loopback lets the process
+// verify that itself for the systemd watchdog. Readiness runs the
+// registered dependency checks (database, signing key, schema version,
+// background workers) and turns not-ready as soon as the graceful shutdown
+// begins, so no new traffic is routed to a draining instance.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeout bounds a readiness run, so a hanging dependency turns the
+// probe into a failure instead of a timeout on the load balancer.
+const DefaultTimeout = 2 * time.Second
+
+// ErrShuttingDown is reported once Shutdown has been called.
+var ErrShuttingDown = errors.New("shutting down")
+
+// Check reports whether a dependency is usable.
+type Check func(ctx context.Context) error
+
+// Runner is implemented by types with a background worker
+// (e.g. auth.TokenBlacklist, challenge.Guard).
+type Runner interface {
+	Running() bool
+}
+
+// Worker returns a check that fails once r's worker has stopped.
+func Worker(r Runner) Check {
+	return func(context.Context) error {
+		if !r.Running() {
+			return errors.New("worker stopped")
+		}
+		return nil
+	}
+}
+
+// SchemaVersion returns a check that compares the database schema version
+// with the version this build expects.
+func SchemaVersion(want int, current func(context.Context) (int, error)) Check {
+	return func(ctx context.Context) error {
+		got, err := current(ctx)
+		if err != nil {
+			return err
+		}
+		if got != want {
+			return fmt.Errorf("schema version %d, want %d", got, want)
+		}
+		return nil
+	}
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker holds the readiness checks.
+type Checker struct {
+	timeout      time.Duration
+	checks       []namedCheck
+	shuttingDown atomic.Bool
+}
+
+// NewChecker creates a Checker whose runs are limited to timeout
+// (DefaultTimeout if timeout <= 0).
+func NewChecker(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a readiness check. Call it before serving requests.
+func (c *Checker) Add(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Shutdown marks the instance as not ready. It cannot be undone.
+func (c *Checker) Shutdown() {
+	c.shuttingDown.Store(true)
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Status     string `json:"status"`
+	DurationMS int64  `json:"duration_ms"`
+	err        error
+}
+
+// Report is the outcome of a readiness run.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Ready reports whether all checks passed and no shutdown is in progress.
+func (r Report) Ready() bool { return r.Status == "ready" }
+
+// Err returns the failed checks as one error, or nil if r is ready.
+func (r Report) Err() error {
+	if r.Ready() {
+		return nil
+	}
+	var errs []error
+	for name, res := range r.Checks {
+		if res.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, res.err))
+		}
+	}
+	if len(errs) == 0 {
+		return ErrShuttingDown
+	}
+	return errors.Join(errs...)
+}
+
+// Check runs all checks concurrently.
+func (c *Checker) Check(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	report := Report{Status: "ready", Checks: make(map[string]Result, len(c.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, nc := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := run(ctx, nc.check)
+			res := Result{Status: "ok", DurationMS: time.Since(start).Milliseconds(), err: err}
+			if err != nil {
+				res.Status = "fail"
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[nc.name] = res
+			if err != nil {
+				report.Status = "not_ready"
+			}
+		}()
+	}
+	wg.Wait()
+
+	if c.shuttingDown.Load() {
+		report.Status = "shutting_down"
+	}
+	return report
+}
+
+// run executes check, but returns when ctx expires even if check ignores it.
+func run(ctx context.Context, check Check) error {
+	result := make(chan error, 1)
+	go func() { result <- check(ctx) }()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LivenessHandler answers 200 as long as the process serves requests.
+func LivenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}
+}
+
+// ReadinessHandler answers 200 with the check results if the instance is
+// ready and 503 otherwise. Error details are only logged, not returned.
+func (c *Checker) ReadinessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := c.Check(r.Context())
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if !report.Ready() {
+			if err := report.Err(); !errors.Is(err, ErrShuttingDown) {
+				slog.WarnContext(r.Context(), "Readiness check failed", "error", err)
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	}
+}
@@ -0,0 +1,213 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeWorker bool
+
+func (w fakeWorker) Running() bool { return bool(w) }
+
+func readyz(t *testing.T, c *Checker) (int, Report) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c.ReadinessHandler()(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var report Report
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return w.Code, report
+}
+
+func TestReadiness(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	version := func(v int) func(context.Context) (int, error) {
+		return func(context.Context) (int, error) { return v, nil }
+	}
+
+	tests := []struct {
+		name       string
+		checks     map[string]Check
+		wantStatus int
+		wantReport string
+		wantFailed []string
+	}{
+		{
+			name: "all ok",
+			checks: map[string]Check{
+				"database": ok,
+				"schema":   SchemaVersion(1, version(1)),
+				"worker":   Worker(fakeWorker(true)),
+			},
+			wantStatus: http.StatusOK,
+			wantReport: "ready",
+		},
+		{
+			name: "database down",
+			checks: map[string]Check{
+				"database": func(context.Context) error { return errors.New("connection refused") },
+				"worker":   Worker(fakeWorker(true)),
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantReport: "not_ready",
+			wantFailed: []string{"database"},
+		},
+		{
+			name: "old schema and stopped worker",
+			checks: map[string]Check{
+				"schema": SchemaVersion(2, version(1)),
+				"worker": Worker(fakeWorker(false)),
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantReport: "not_ready",
+			wantFailed: []string{"schema", "worker"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewChecker(time.Second)
+			for name, check := range tt.checks {
+				c.Add(name, check)
+			}
+
+			code, report := readyz(t, c)
+			if code != tt.wantStatus {
+				t.Errorf("Status code = %d, want %d", code, tt.wantStatus)
+			}
+			if report.Status != tt.wantReport {
+				t.Errorf("Report status = %q, want %q", report.Status, tt.wantReport)
+			}
+			if len(report.Checks) != len(tt.checks) {
+				t.Errorf("Got %d check results, want %d", len(report.Checks), len(tt.checks))
+			}
+			for _, name := range tt.wantFailed {
+				if report.Checks[name].Status != "fail" {
+					t.Errorf("Check %s = %q, want fail", name, report.Checks[name].Status)
+				}
+			}
+		})
+	}
+}
+
+func TestReadinessTimeout(t *testing.T) {
+	c := NewChecker(50 * time.Millisecond)
+	block := make(chan struct{})
+	defer close(block)
+	// A check that ignores its context must not hang the probe
+	c.Add("hanging", func(context.Context) error {
+		<-block
+		return nil
+	})
+
+	start := time.Now()
+	code, report := readyz(t, c)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Probe took %v, want it bounded by the timeout", elapsed)
+	}
+	if code != http.StatusServiceUnavailable || report.Checks["hanging"].Status != "fail" {
+		t.Errorf("Got %d with %+v, want 503 and a failed check", code, report.Checks)
+	}
+}
+
+func TestReadinessShutdown(t *testing.T) {
+	c := NewChecker(0)
+	c.Add("database", func(context.Context) error { return nil })
+
+	if code, _ := readyz(t, c); code != http.StatusOK {
+		t.Fatalf("Status code before shutdown = %d, want 200", code)
+	}
+
+	c.Shutdown()
+	code, report := readyz(t, c)
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("Status code during shutdown = %d, want 503", code)
+	}
+	if report.Status != "shutting_down" {
+		t.Errorf("Report status = %q, want shutting_down", report.Status)
+	}
+	if !errors.Is(c.Check(context.Background()).Err(), ErrShuttingDown) {
+		t.Error("Err() during shutdown, want ErrShuttingDown")
+	}
+
+	// Liveness is unaffected by the shutdown
+	w := httptest.NewRecorder()
+	LivenessHandler()(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Liveness status code = %d, want 200", w.Code)
+	}
+}
+
+func TestLoopback(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	loopback := NewLoopback(ln)
+
+	block := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.Handle("GET /healthz", LivenessHandler())
+	mux.HandleFunc("GET /stuck", func(http.ResponseWriter, *http.Request) { <-block })
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(loopback)
+	defer srv.Close()
+	defer close(block)
+
+	check := func(path string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		return loopback.Check(path)(ctx)
+	}
+
+	if err := check("/healthz"); err != nil {
+		t.Errorf("Check(/healthz) = %v, want nil", err)
+	}
+	if err := check("/stuck"); err == nil {
+		t.Error("Check(/stuck) succeeded, want timeout")
+	}
+	if err := check("/missing"); err == nil {
+		t.Error("Check(/missing) succeeded, want error for 404")
+	}
+
+	// Real clients are still served through the wrapped listener
+	resp, err := http.Get("http://" + loopback.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatalf("GET /healthz failed: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Status code = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestLoopbackNotAccepting(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	// Nobody calls Accept, like a serve loop that hangs
+	loopback := NewLoopback(ln)
+	defer loopback.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := loopback.Check("/healthz")(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Check() = %v, want deadline exceeded", err)
+	}
+
+	loopback.Close()
+	if err := loopback.Check("/healthz")(context.Background()); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Check() after Close = %v, want net.ErrClosed", err)
+	}
+}
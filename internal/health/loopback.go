@@ -0,0 +1,122 @@
+package health
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// Loopback wraps the server's listener, so a liveness check can send a
+// request through the same accept loop, middleware chain and handler as a
+// real client. Worker checks alone cannot see a server whose listener or
+// handlers are stuck.
+//
+// The probe connections are in-memory pipes handed out by Accept after the
+// TLS layer, so the check needs neither a client certificate nor a port
+// reachable from the process itself (e.g. with socket activation).
+type Loopback struct {
+	net.Listener
+
+	probes    chan net.Conn
+	accepted  chan acceptResult
+	done      chan struct{}
+	startOnce sync.Once
+	closeOnce sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// NewLoopback wraps ln. Serve the returned listener instead of ln.
+func NewLoopback(ln net.Listener) *Loopback {
+	return &Loopback{
+		Listener: ln,
+		probes:   make(chan net.Conn),
+		accepted: make(chan acceptResult),
+		done:     make(chan struct{}),
+	}
+}
+
+// Accept returns the next client or probe connection.
+func (l *Loopback) Accept() (net.Conn, error) {
+	l.startOnce.Do(func() { go l.acceptLoop() })
+
+	select {
+	case res := <-l.accepted:
+		return res.conn, res.err
+	case conn := <-l.probes:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// acceptLoop forwards the connections of the wrapped listener to Accept.
+func (l *Loopback) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		select {
+		case l.accepted <- acceptResult{conn: conn, err: err}:
+		case <-l.done:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+// Close closes the wrapped listener. Pending probes fail.
+func (l *Loopback) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// Check returns a check that requests path over a probe connection and
+// fails unless the server answers 200 before ctx expires.
+func (l *Loopback) Check(path string) Check {
+	return func(ctx context.Context) error {
+		client, server := net.Pipe()
+		defer client.Close()
+
+		select {
+		case l.probes <- server:
+		case <-l.done:
+			server.Close()
+			return net.ErrClosed
+		case <-ctx.Done():
+			server.Close()
+			return fmt.Errorf("connection not accepted: %w", ctx.Err())
+		}
+
+		if deadline, ok := ctx.Deadline(); ok {
+			client.SetDeadline(deadline)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://loopback"+path, nil)
+		if err != nil {
+			return err
+		}
+		req.Close = true
+		if err := req.Write(client); err != nil {
+			return fmt.Errorf("request not read: %w", err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(client), req)
+		if err != nil {
+			return fmt.Errorf("no response: %w", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s answered %d", path, resp.StatusCode)
+		}
+		return nil
+	}
+}
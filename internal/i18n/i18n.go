@@ -0,0 +1,201 @@
+// Package i18n translates API messages. Messages live in one catalog per
+// language (locales/*.json), keyed by error or message code; placeholders
+// like {min} are filled from Args. The request language is negotiated from
+// Accept-Language and can be overridden by the user's preference.
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Supported languages.
+const (
+	German  = "de"
+	English = "en"
+)
+
+//go:embed locales/*.json
+var locales embed.FS
+
+// catalogs maps language to message key to text.
+var catalogs = mustLoad()
+
+var (
+	defaultMu   sync.RWMutex
+	defaultLang = English
+)
+
+func mustLoad() map[string]map[string]string {
+	files, err := locales.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	catalogs := make(map[string]map[string]string, len(files))
+	for _, f := range files {
+		data, err := locales.ReadFile(path.Join("locales", f.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("i18n: %s: %v", f.Name(), err))
+		}
+		catalogs[strings.TrimSuffix(f.Name(), ".json")] = messages
+	}
+	return catalogs
+}
+
+// Languages returns the supported languages, sorted.
+func Languages() []string {
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Supported reports whether lang has a catalog.
+func Supported(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// SetDefault sets the language used when the client accepts none of the
+// supported languages.
+func SetDefault(lang string) error {
+	if !Supported(lang) {
+		return fmt.Errorf("unsupported language %q (supported: %s)", lang, strings.Join(Languages(), ", "))
+	}
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLang = lang
+	return nil
+}
+
+// Default returns the fallback language.
+func Default() string {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultLang
+}
+
+// Args holds the placeholder values of a message.
+type Args map[string]interface{}
+
+// Key is a message key used as an argument value; it is translated into the
+// language of the surrounding message.
+type Key string
+
+// Message is a message key with its arguments, translated once the
+// language is known.
+type Message struct {
+	Key  string
+	Args Args
+}
+
+// Text returns m in lang.
+func (m Message) Text(lang string) string {
+	return Text(lang, m.Key, m.Args)
+}
+
+// Text returns the message key in lang with the placeholders replaced.
+// Missing translations fall back to English, unknown keys to the key itself.
+func Text(lang, key string, args Args) string {
+	text, ok := catalogs[lang][key]
+	if !ok {
+		if text, ok = catalogs[English][key]; !ok {
+			return key
+		}
+	}
+	if len(args) == 0 || !strings.Contains(text, "{") {
+		return text
+	}
+
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(text, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(text[start:], '}')
+		if end < 0 {
+			break
+		}
+		b.WriteString(text[:start])
+		name := text[start+1 : start+end]
+		if v, ok := args[name]; ok {
+			b.WriteString(format(lang, v))
+		} else {
+			b.WriteString(text[start : start+end+1])
+		}
+		text = text[start+end+1:]
+	}
+	b.WriteString(text)
+	return b.String()
+}
+
+func format(lang string, v interface{}) string {
+	switch v := v.(type) {
+	case Key:
+		return Text(lang, string(v), nil)
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// Negotiate picks the best supported language from an Accept-Language
+// header (RFC 9110). Region subtags are ignored ("de-AT" matches "de");
+// without a match the default language is returned.
+func Negotiate(acceptLanguage string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if primary == "*" {
+			primary = Default()
+		}
+		// First one wins on equal quality, as the client listed it first
+		if q > bestQ && Supported(primary) {
+			best, bestQ = primary, q
+		}
+	}
+	if best == "" {
+		return Default()
+	}
+	return best
+}
+
+type contextKey struct{}
+
+// WithLanguage returns a context carrying lang.
+func WithLanguage(ctx context.Context, lang string) context.Context {
+	return context.WithValue(ctx, contextKey{}, lang)
+}
+
+// Language returns the language of ctx, or the default language.
+func Language(ctx context.Context) string {
+	if lang, ok := ctx.Value(contextKey{}).(string); ok && lang != "" {
+		return lang
+	}
+	return Default()
+}
@@ -0,0 +1,98 @@
+package i18n
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var placeholder = regexp.MustCompile(`\{\w+\}`)
+
+func TestCatalogsComplete(t *testing.T) {
+	if got := strings.Join(Languages(), ","); got != "de,en" {
+		t.Fatalf("Languages() = %s, want de,en", got)
+	}
+	for key, text := range catalogs[English] {
+		for _, lang := range Languages() {
+			translated, ok := catalogs[lang][key]
+			if !ok {
+				t.Errorf("%s: missing %q", lang, key)
+				continue
+			}
+			want, got := placeholders(text), placeholders(translated)
+			if want != got {
+				t.Errorf("%s: %q has placeholders %s, want %s", lang, key, got, want)
+			}
+		}
+	}
+	for _, lang := range Languages() {
+		for key := range catalogs[lang] {
+			if _, ok := catalogs[English][key]; !ok {
+				t.Errorf("%s: %q is not in the English catalog", lang, key)
+			}
+		}
+	}
+}
+
+func placeholders(text string) string {
+	found := placeholder.FindAllString(text, -1)
+	sort.Strings(found)
+	return strings.Join(found, ",")
+}
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		lang, key string
+		args      Args
+		want      string
+	}{
+		{English, "validation.password.too_short", Args{"min": 8}, "Password must be at least 8 characters long"},
+		{German, "validation.password.too_short", Args{"min": 12}, "Das Passwort muss mindestens 12 Zeichen lang sein"},
+		{German, "validation.password.too_weak", Args{"warning": Key("strength.date")}, "Das Passwort ist zu schwach: Datumsangaben sind oft leicht zu erraten"},
+		{German, "error.not_found", nil, "Unter {path} gibt es keine Ressource"},
+		{"fr", "error.internal_error", nil, "Internal server error"},
+		{German, "no.such.key", nil, "no.such.key"},
+	}
+	for _, tt := range tests {
+		if got := Text(tt.lang, tt.key, tt.args); got != tt.want {
+			t.Errorf("Text(%s, %s) = %q, want %q", tt.lang, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		header, want string
+	}{
+		{"", English},
+		{"de", German},
+		{"de-AT,de;q=0.9,en;q=0.8", German},
+		{"fr-CH, fr;q=0.9, en;q=0.8, de;q=0.7", English},
+		{"en;q=0.5, DE;q=0.8", German},
+		{"de;q=0, en", English},
+		{"fr, *;q=0.5", English},
+		{"fr", English},
+		{"de;q=bogus, en;q=0.1", English},
+	}
+	for _, tt := range tests {
+		if got := Negotiate(tt.header); got != tt.want {
+			t.Errorf("Negotiate(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+
+	if err := SetDefault(German); err != nil {
+		t.Fatalf("SetDefault() failed: %v", err)
+	}
+	defer SetDefault(English)
+	if got := Negotiate("fr"); got != German {
+		t.Errorf("Negotiate(fr) with default de = %s, want de", got)
+	}
+	if got := Language(context.Background()); got != German {
+		t.Errorf("Language() without request language = %s, want de", got)
+	}
+	if err := SetDefault("fr"); err == nil {
+		t.Error("SetDefault(fr) succeeded, want error")
+	}
+}
@@ -0,0 +1,200 @@
+// Package logging configures log/slog for the server: JSON or text output,
+// request attributes taken from the context and redaction of secrets.
+//
+// Log with the request context, so the line carries the request ID, route,
+// user ID and trace ID:
+//
+//	slog.WarnContext(r.Context(), "Failed to record login failure", "error", err)
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Options configures New.
+type Options struct {
+	// Level is debug, info, warn or error (default info).
+	Level string
+	// Format is json or text (default json).
+	Format string
+}
+
+// New returns a logger writing to w. All attributes, including the
+// message, pass through Redact.
+func New(w io.Writer, opts Options) (*slog.Logger, error) {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	handlerOpts := &slog.HandlerOptions{Level: level, ReplaceAttr: Redact}
+
+	var h slog.Handler
+	switch opts.Format {
+	case "", "json":
+		h = slog.NewJSONHandler(w, handlerOpts)
+	case "text":
+		h = slog.NewTextHandler(w, handlerOpts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", opts.Format)
+	}
+	return slog.New(contextHandler{h}), nil
+}
+
+// ParseLevel parses debug, info, warn or error ("" is info).
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", s)
+	}
+	return level, nil
+}
+
+// contextHandler adds the request attributes (see WithRequestID) and the
+// trace and span ID to every record logged with a request context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if info := requestFromContext(ctx); info != nil {
+		r.AddAttrs(info.attrs()...)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// requestInfo is shared by everything handling one request. Route and user
+// are filled in while the request passes the chain, so the access log
+// written on the way out sees them too.
+type requestInfo struct {
+	id string
+
+	mu      sync.Mutex
+	route   string
+	userID  int64
+	hasUser bool
+}
+
+func (info *requestInfo) attrs() []slog.Attr {
+	attrs := []slog.Attr{slog.String("request_id", info.id)}
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	if info.route != "" {
+		attrs = append(attrs, slog.String("route", info.route))
+	}
+	if info.hasUser {
+		attrs = append(attrs, slog.Int64("user_id", info.userID))
+	}
+	return attrs
+}
+
+type requestKey struct{}
+
+func requestFromContext(ctx context.Context) *requestInfo {
+	if ctx == nil {
+		return nil
+	}
+	info, _ := ctx.Value(requestKey{}).(*requestInfo)
+	return info
+}
+
+// WithRequestID returns ctx carrying the request ID for all log lines.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestKey{}, &requestInfo{id: id})
+}
+
+// RequestID returns the request ID of ctx ("" if none).
+func RequestID(ctx context.Context) string {
+	if info := requestFromContext(ctx); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// SetRoute records the matched route pattern for the request of ctx.
+func SetRoute(ctx context.Context, route string) {
+	if info := requestFromContext(ctx); info != nil {
+		info.mu.Lock()
+		info.route = route
+		info.mu.Unlock()
+	}
+}
+
+// SetUserID records the authenticated user for the request of ctx.
+func SetUserID(ctx context.Context, id int64) {
+	if info := requestFromContext(ctx); info != nil {
+		info.mu.Lock()
+		info.userID, info.hasUser = id, true
+		info.mu.Unlock()
+	}
+}
+
+// Redacted replaces removed values.
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute key fragments whose values are never logged.
+var sensitiveKeys = []string{
+	"password", "passwd", "secret", "token", "authorization", "cookie",
+	"jwt", "pepper", "credential", "api_key", "apikey", "email",
+}
+
+var (
+	// Credentials in header values, e.g. "Bearer eyJ..." or "Basic dXNlcjpw..."
+	authPattern = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`)
+	// JWTs (base64url header starting with {"...)
+	jwtPattern   = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+)
+
+// Redact is the slog ReplaceAttr function of New. Values of sensitive keys
+// are replaced completely; in other strings, errors and the message,
+// bearer tokens, JWTs and email addresses are masked.
+func Redact(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindGroup {
+		return a
+	}
+	key := strings.ToLower(a.Key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return slog.String(a.Key, Redacted)
+		}
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(RedactString(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			a.Value = slog.StringValue(RedactString(err.Error()))
+		}
+	}
+	return a
+}
+
+// RedactString masks credentials and email addresses in s.
+func RedactString(s string) string {
+	s = authPattern.ReplaceAllString(s, "$1 "+Redacted)
+	s = jwtPattern.ReplaceAllString(s, Redacted)
+	return emailPattern.ReplaceAllString(s, Redacted)
+}
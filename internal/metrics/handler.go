@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// ContentType is the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler serves the registry for Prometheus scrapes. If token is not empty,
+// requests must send "Authorization: Bearer <token>" (Prometheus:
+// authorization.credentials_file in the scrape config).
+func Handler(r *Registry, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if token != "" && !validBearer(req, token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", ContentType)
+		w.Header().Set("Cache-Control", "no-store")
+		if req.Method == http.MethodHead {
+			return
+		}
+		if _, err := r.WriteTo(w); err != nil {
+			slog.WarnContext(req.Context(), "Failed to write metrics", "error", err)
+		}
+	})
+}
+
+func validBearer(r *http.Request, token string) bool {
+	scheme, credentials, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(credentials), []byte(token)) == 1
+}
+
+// RegisterProcessMetrics adds the start time and goroutine count, so restarts
+// and goroutine leaks are visible.
+func RegisterProcessMetrics(r *Registry) {
+	start := float64(time.Now().UnixNano()) / 1e9
+	r.NewGaugeFunc("process_start_time_seconds", "Start time of the process since unix epoch in seconds.",
+		func() float64 { return start })
+	r.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.",
+		func() float64 { return float64(runtime.NumGoroutine()) })
+}
@@ -0,0 +1,348 @@
+// Package metrics is a small Prometheus instrumentation library: counters,
+// histograms and gauge functions, exposed in the text exposition format
+// (version 0.0.4). It covers what the server needs without pulling in the
+// full client library.
+//
+// Instrumented packages declare their metrics as package variables on the
+// Default registry, like with the Prometheus client:
+//
+//	var logins = metrics.Default.NewCounterVec("auth_login_attempts_total", "Login attempts by result.", "result")
+//	logins.With("success").Inc()
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets are the default histogram buckets for request latencies in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var nameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// Default is the registry the server exposes on /metrics.
+var Default = NewRegistry()
+
+// family is one metric family (all series with the same name).
+type family interface {
+	header() (name, help, typ string)
+	// write writes all samples of the family.
+	write(w *bufio.Writer)
+}
+
+// Registry holds metric families and writes them in exposition format.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]family
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]family)}
+}
+
+// register adds f. Like prometheus.MustRegister it panics on invalid or
+// duplicate names, which are programming errors.
+func (r *Registry) register(f family) {
+	name, _, _ := f.header()
+	if !nameRe.MatchString(name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.families[name]; exists {
+		panic(fmt.Sprintf("metrics: duplicate metric %q", name))
+	}
+	r.families[name] = f
+}
+
+// WriteTo writes all metrics sorted by name.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	families := r.families
+	r.mu.Unlock()
+	sort.Strings(names)
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, name := range names {
+		f := families[name]
+		_, help, typ := f.header()
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, typ)
+		f.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	bits atomic.Uint64
+}
+
+// Inc adds 1.
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add adds v, which must not be negative.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	for {
+		old := c.bits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if c.bits.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// Value returns the current value.
+func (c *Counter) Value() float64 { return math.Float64frombits(c.bits.Load()) }
+
+// Histogram counts observations in cumulative buckets.
+type Histogram struct {
+	upper []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{upper: buckets, counts: make([]uint64, len(buckets))}
+}
+
+// Observe records one value.
+func (h *Histogram) Observe(v float64) {
+	// Buckets are cumulative when written; here each value lands in one
+	i := sort.SearchFloat64s(h.upper, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) write(bw *bufio.Writer, name, labels string) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var cumulative uint64
+	for i, upper := range h.upper {
+		cumulative += counts[i]
+		fmt.Fprintf(bw, "%s_bucket{%s%sle=%q} %d\n", name, labels, sep, formatFloat(upper), cumulative)
+	}
+	fmt.Fprintf(bw, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, count)
+	fmt.Fprintf(bw, "%s_sum%s %s\n", name, braces(labels), formatFloat(sum))
+	fmt.Fprintf(bw, "%s_count%s %d\n", name, braces(labels), count)
+}
+
+// vec holds one child metric per combination of label values.
+type vec[M any] struct {
+	name, help, typ string
+	labels          []string
+	newChild        func() *M
+
+	mu       sync.RWMutex
+	children map[string]*M
+}
+
+func newVec[M any](name, help, typ string, labels []string, newChild func() *M) *vec[M] {
+	for _, l := range labels {
+		if !nameRe.MatchString(l) || strings.Contains(l, ":") || strings.HasPrefix(l, "__") {
+			panic(fmt.Sprintf("metrics: invalid label name %q", l))
+		}
+	}
+	return &vec[M]{name: name, help: help, typ: typ, labels: labels, newChild: newChild, children: make(map[string]*M)}
+}
+
+func (v *vec[M]) header() (string, string, string) { return v.name, v.help, v.typ }
+
+// with returns the child for the label values, creating it on first use.
+func (v *vec[M]) with(values []string) *M {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	var b strings.Builder
+	for i, l := range v.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", l, escapeLabel(values[i]))
+	}
+	key := b.String()
+
+	v.mu.RLock()
+	child, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return child
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if child, ok = v.children[key]; !ok {
+		child = v.newChild()
+		v.children[key] = child
+	}
+	return child
+}
+
+// each calls fn for all children sorted by their rendered labels.
+func (v *vec[M]) each(fn func(labels string, child *M)) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.children))
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	children := make(map[string]*M, len(v.children))
+	for k, c := range v.children {
+		children[k] = c
+	}
+	v.mu.RUnlock()
+
+	sort.Strings(keys)
+	for _, k := range keys {
+		fn(k, children[k])
+	}
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	*vec[Counter]
+}
+
+// NewCounterVec registers a counter with the given label names. Without
+// labels, With() returns the single series.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	cv := &CounterVec{newVec(name, help, "counter", labels, func() *Counter { return &Counter{} })}
+	r.register(cv)
+	return cv
+}
+
+// NewCounter registers a counter without labels.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+// With returns the counter for the label values (in the order of the label names).
+func (cv *CounterVec) With(values ...string) *Counter { return cv.with(values) }
+
+func (cv *CounterVec) write(bw *bufio.Writer) {
+	cv.each(func(labels string, c *Counter) {
+		fmt.Fprintf(bw, "%s%s %s\n", cv.name, braces(labels), formatFloat(c.Value()))
+	})
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	*vec[Histogram]
+}
+
+// NewHistogramVec registers a histogram with the given upper bucket bounds
+// (sorted ascending; DefBuckets if nil) and label names.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("metrics: buckets of %s are not sorted", name))
+	}
+	hv := &HistogramVec{newVec(name, help, "histogram", labels, func() *Histogram { return newHistogram(buckets) })}
+	r.register(hv)
+	return hv
+}
+
+// With returns the histogram for the label values.
+func (hv *HistogramVec) With(values ...string) *Histogram { return hv.with(values) }
+
+func (hv *HistogramVec) write(bw *bufio.Writer) {
+	hv.each(func(labels string, h *Histogram) {
+		h.write(bw, hv.name, labels)
+	})
+}
+
+// gaugeFunc is a gauge whose value is read on every scrape.
+type gaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+// NewGaugeFunc registers a gauge that calls fn on every scrape, e.g. the
+// size of an in-memory structure.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&gaugeFunc{name: name, help: help, fn: fn})
+}
+
+func (g *gaugeFunc) header() (string, string, string) { return g.name, g.help, "gauge" }
+
+func (g *gaugeFunc) write(bw *bufio.Writer) {
+	fmt.Fprintf(bw, "%s %s\n", g.name, formatFloat(g.fn()))
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	logins := r.NewCounterVec("auth_login_attempts_total", "Login attempts by result.", "result")
+	lockouts := r.NewCounter("auth_lockouts_total", "Logins blocked by the throttle.")
+	latency := r.NewHistogramVec("http_request_duration_seconds", "Request latency.", []float64{0.1, 1}, "route", "status")
+	r.NewGaugeFunc("auth_blacklisted_tokens", "Tokens on the blacklist.", func() float64 { return 3 })
+
+	logins.With("success").Inc()
+	logins.With("success").Inc()
+	logins.With("failure").Add(1)
+	lockouts.Inc()
+	h := latency.With(`/say "hi"`, "200")
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(2)
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo() failed: %v", err)
+	}
+
+	want := `# HELP auth_blacklisted_tokens Tokens on the blacklist.
+# TYPE auth_blacklisted_tokens gauge
+auth_blacklisted_tokens 3
+# HELP auth_lockouts_total Logins blocked by the throttle.
+# TYPE auth_lockouts_total counter
+auth_lockouts_total 1
+# HELP auth_login_attempts_total Login attempts by result.
+# TYPE auth_login_attempts_total counter
+auth_login_attempts_total{result="failure"} 1
+auth_login_attempts_total{result="success"} 2
+# HELP http_request_duration_seconds Request latency.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{route="/say \"hi\"",status="200",le="0.1"} 1
+http_request_duration_seconds_bucket{route="/say \"hi\"",status="200",le="1"} 2
+http_request_duration_seconds_bucket{route="/say \"hi\"",status="200",le="+Inf"} 3
+http_request_duration_seconds_sum{route="/say \"hi\"",status="200"} 2.55
+http_request_duration_seconds_count{route="/say \"hi\"",status="200"} 3
+`
+	if b.String() != want {
+		t.Errorf("Exposition mismatch.\nGot:\n%s\nWant:\n%s", b.String(), want)
+	}
+}
+
+func TestRegistryPanics(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(r *Registry)
+	}{
+		{"duplicate", func(r *Registry) { r.NewCounter("x_total", ""); r.NewCounter("x_total", "") }},
+		{"invalid name", func(r *Registry) { r.NewCounter("bad-name", "") }},
+		{"invalid label", func(r *Registry) { r.NewCounterVec("y_total", "", "__reserved") }},
+		{"label count", func(r *Registry) { r.NewCounterVec("z_total", "", "a").With("1", "2") }},
+		{"negative add", func(r *Registry) { r.NewCounter("n_total", "").Add(-1) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("Expected panic")
+				}
+			}()
+			tt.fn(NewRegistry())
+		})
+	}
+}
+
+func TestHandlerAuth(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("requests_total", "Requests.").Inc()
+
+	tests := []struct {
+		name       string
+		token      string
+		header     string
+		wantStatus int
+	}{
+		{"no token configured", "", "", http.StatusOK},
+		{"missing header", "s3cret", "", http.StatusUnauthorized},
+		{"wrong token", "s3cret", "Bearer wrong", http.StatusUnauthorized},
+		{"basic instead of bearer", "s3cret", "Basic s3cret", http.StatusUnauthorized},
+		{"valid token", "s3cret", "Bearer s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			Handler(r, tt.token).ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Status code = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code != http.StatusOK {
+				if w.Header().Get("WWW-Authenticate") == "" {
+					t.Error("Missing WWW-Authenticate header")
+				}
+				return
+			}
+			if ct := w.Header().Get("Content-Type"); ct != ContentType {
+				t.Errorf("Content-Type = %q, want %q", ct, ContentType)
+			}
+			if !strings.Contains(w.Body.String(), "requests_total 1\n") {
+				t.Errorf("Body does not contain the counter:\n%s", w.Body.String())
+			}
+		})
+	}
+}
@@ -3,10 +3,18 @@ package middleware
 import (
 	"context"
 	"foodshop/internal/auth"
+	"foodshop/internal/logging"
+	"foodshop/internal/problem"
 	"net/http"
 	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
 )
 
+// tracer starts the spans for token verification and rate limit lookups.
+var tracer = otel.Tracer("foodshop/internal/middleware")
+
 // ContextKey type for context keys
 type ContextKey string
 
@@ -24,14 +32,14 @@ func AuthMiddleware(blacklist *auth.TokenBlacklist) func(http.Handler) http.Hand
 			// Extract token from Authorization header
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
-				http.Error(w, "Missing authorization header", http.StatusUnauthorized)
+				problem.Error(w, r, problem.CodeMissingToken)
 				return
 			}
 
 			// Bearer token format: "Bearer <token>"
 			parts := strings.Split(authHeader, " ")
 			if len(parts) != 2 || parts[0] != "Bearer" {
-				http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+				problem.Write(w, r, problem.New(problem.CodeInvalidToken).Variant("format"))
 				return
 			}
 
@@ -39,18 +47,25 @@ func AuthMiddleware(blacklist *auth.TokenBlacklist) func(http.Handler) http.Hand
 
 			// Check if token is blacklisted (logged out)
 			if blacklist.IsBlacklisted(tokenString) {
-				http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+				problem.Error(w, r, problem.CodeTokenRevoked)
 				return
 			}
 
 			// Validate token
+			_, span := tracer.Start(r.Context(), "jwt.Verify")
 			claims, err := auth.ValidateToken(tokenString)
 			if err != nil {
-				http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.End()
+			if err != nil {
+				problem.Error(w, r, problem.CodeInvalidToken)
 				return
 			}
 
-			// Add user info to context
+			// Add user info to context and log lines
+			logging.SetUserID(r.Context(), claims.UserID)
 			ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
 			ctx = context.WithValue(ctx, UsernameKey, claims.Username)
 
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"context"
+	"foodshop/internal/problem"
+	"log/slog"
+	"net/http"
+)
+
+// APIClientKey is the context key for the authenticated API client (service identity)
+const APIClientKey ContextKey = "api_client"
+
+// ClientCertAuth maps verified client certificates (mTLS) to service
+// identities. identities maps the certificate subject in RFC 2253 form
+// (e.g. "CN=billing,O=Foodshop") to the identity name. The identity is stored
+// under APIClientKey, so rate limits can key on it (KeyByAPIClient).
+//
+// Requests without a client certificate pass unchanged; a verified
+// certificate with an unknown subject is rejected with 403.
+func ClientCertAuth(identities map[string]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// VerifiedChains is only set if the TLS stack validated the certificate
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			subject := r.TLS.VerifiedChains[0][0].Subject.String()
+			identity, ok := identities[subject]
+			if !ok {
+				slog.WarnContext(r.Context(), "Rejected client certificate with unknown subject", "subject", subject, "ip", ClientIP(r))
+				problem.Error(w, r, problem.CodeClientCertRejected)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), APIClientKey, identity)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// GetAPIClient returns the service identity of an mTLS client.
+func GetAPIClient(r *http.Request) (string, bool) {
+	client, ok := r.Context().Value(APIClientKey).(string)
+	return client, ok && client != ""
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientCertAuth(t *testing.T) {
+	identities := map[string]string{"CN=billing,O=Foodshop": "billing"}
+
+	var gotClient string
+	handler := ClientCertAuth(identities)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotClient, _ = GetAPIClient(r)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	verified := func(subject pkix.Name) *tls.ConnectionState {
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: subject}}}}
+	}
+
+	tests := []struct {
+		name       string
+		tls        *tls.ConnectionState
+		wantStatus int
+		wantClient string
+	}{
+		{"Plain HTTP", nil, http.StatusOK, ""},
+		{"TLS without client certificate", &tls.ConnectionState{}, http.StatusOK, ""},
+		{"Known subject", verified(pkix.Name{CommonName: "billing", Organization: []string{"Foodshop"}}), http.StatusOK, "billing"},
+		{"Unknown subject", verified(pkix.Name{CommonName: "intruder"}), http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotClient = ""
+			req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+			req.TLS = tt.tls
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if gotClient != tt.wantClient {
+				t.Errorf("API client = %q, want %q", gotClient, tt.wantClient)
+			}
+		})
+	}
+}
+
+func TestSecurityHeadersHSTS(t *testing.T) {
+	handler := SecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if got := rr.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("HSTS on plain HTTP = %q, want none", got)
+	}
+
+	req.TLS = &tls.ConnectionState{}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if got := rr.Header().Get("Strict-Transport-Security"); got != "max-age=31536000; includeSubDomains" {
+		t.Errorf("HSTS on TLS = %q", got)
+	}
+}
@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIPKey is the context key for the resolved client IP
+const ClientIPKey ContextKey = "client_ip"
+
+// IPResolver determines the real client IP. Forwarding headers are only
+// trusted when the request comes from a configured proxy; otherwise any
+// client could spoof its address.
+type IPResolver struct {
+	trusted []*net.IPNet
+}
+
+// NewIPResolver creates a resolver that trusts the given proxy CIDRs
+// (e.g. "10.0.0.0/8"). Plain IPs are treated as single-host networks.
+// Without trusted proxies the connection address is always used.
+func NewIPResolver(trustedProxies []string) (*IPResolver, error) {
+	res := &IPResolver{}
+	for _, cidr := range trustedProxies {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", cidr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			cidr = fmt.Sprintf("%s/%d", cidr, bits)
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+		res.trusted = append(res.trusted, network)
+	}
+	return res, nil
+}
+
+// Middleware resolves the client IP once and stores it in the request
+// context, so every later middleware and handler sees the same value.
+func (res *IPResolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), ClientIPKey, res.Resolve(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Resolve returns the client IP of the request.
+// The chain of forwarded addresses (RFC 7239 Forwarded or X-Forwarded-For)
+// is walked from right to left, skipping trusted proxies; the first
+// untrusted address is the client.
+func (res *IPResolver) Resolve(r *http.Request) string {
+	remote := stripPort(r.RemoteAddr)
+	if !res.isTrusted(remote) {
+		return remote
+	}
+
+	var chain []string
+	if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+		chain = parseForwarded(forwarded)
+	} else if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		for _, header := range xff {
+			for _, hop := range strings.Split(header, ",") {
+				chain = append(chain, stripPort(strings.TrimSpace(hop)))
+			}
+		}
+	} else if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+		chain = []string{stripPort(strings.TrimSpace(realIP))}
+	}
+
+	client := remote
+	for i := len(chain) - 1; i >= 0; i-- {
+		hop := chain[i]
+		if net.ParseIP(hop) == nil {
+			// Unknown or obfuscated hop: nothing to the left can be trusted
+			break
+		}
+		client = hop
+		if !res.isTrusted(hop) {
+			break
+		}
+	}
+	return client
+}
+
+func (res *IPResolver) isTrusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range res.trusted {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwarded extracts the "for" addresses of RFC 7239 Forwarded headers,
+// e.g. `for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"`.
+func parseForwarded(headers []string) []string {
+	var chain []string
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if !found || !strings.EqualFold(key, "for") {
+					continue
+				}
+				value = strings.Trim(value, `"`)
+				chain = append(chain, stripPort(value))
+			}
+		}
+	}
+	return chain
+}
+
+// stripPort removes the port from "ip:port" and "[ipv6]:port" and the
+// brackets from "[ipv6]". Bare IPv6 addresses are returned unchanged.
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// ClientIP returns the client IP resolved by IPResolver.Middleware.
+// Without the middleware the connection address is used (headers are never trusted).
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(ClientIPKey).(string); ok {
+		return ip
+	}
+	return stripPort(r.RemoteAddr)
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPResolver(t *testing.T) {
+	res, err := NewIPResolver([]string{"10.0.0.0/8", "2001:db8:ffff::/48", "192.0.2.10"})
+	if err != nil {
+		t.Fatalf("NewIPResolver() failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"Direct client, port stripped", "203.0.113.5:54321", nil, "203.0.113.5"},
+		{"Direct IPv6 client", "[2001:db8::1]:443", nil, "2001:db8::1"},
+		{"Spoofed XFF from untrusted client", "203.0.113.5:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "203.0.113.5"},
+		{"Single trusted proxy", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.7"}, "203.0.113.7"},
+		{"Client prepends fake hop", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, 203.0.113.7"}, "203.0.113.7"},
+		{"Proxy chain", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.7, 10.0.0.2, 192.0.2.10"}, "203.0.113.7"},
+		{"Only trusted hops", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.0.0.3"}, "10.0.0.3"},
+		{"XFF with port", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.7:9999"}, "203.0.113.7"},
+		{"Garbage hop", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "evil, 203.0.113.7"}, "203.0.113.7"},
+		{"Garbage last hop", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.7, evil"}, "10.0.0.1"},
+		{"Forwarded header", "10.0.0.1:1234", map[string]string{"Forwarded": "for=1.2.3.4, for=203.0.113.7;proto=https"}, "203.0.113.7"},
+		{"Forwarded IPv6", "10.0.0.1:1234", map[string]string{"Forwarded": `for="[2001:db8:cafe::17]:4711"`}, "2001:db8:cafe::17"},
+		{"Forwarded wins over XFF", "10.0.0.1:1234", map[string]string{"Forwarded": "for=203.0.113.8", "X-Forwarded-For": "203.0.113.9"}, "203.0.113.8"},
+		{"Forwarded unknown", "10.0.0.1:1234", map[string]string{"Forwarded": "for=unknown"}, "10.0.0.1"},
+		{"X-Real-IP from trusted proxy", "10.0.0.1:1234", map[string]string{"X-Real-IP": "203.0.113.7"}, "203.0.113.7"},
+		{"X-Real-IP from untrusted client", "203.0.113.5:1234", map[string]string{"X-Real-IP": "1.2.3.4"}, "203.0.113.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if got := res.Resolve(req); got != tt.want {
+				t.Errorf("Resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPResolverInvalidCIDR(t *testing.T) {
+	if _, err := NewIPResolver([]string{"not-an-ip"}); err == nil {
+		t.Error("NewIPResolver() should reject invalid proxies")
+	}
+}
+
+func TestClientIPFromContext(t *testing.T) {
+	res, _ := NewIPResolver([]string{"10.0.0.0/8"})
+
+	var got string
+	handler := res.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = ClientIP(r)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.1.2.3:80"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got != "203.0.113.7" {
+		t.Errorf("ClientIP() = %q, want 203.0.113.7", got)
+	}
+
+	// Without the middleware headers are ignored
+	if ip := ClientIP(req); ip != "10.1.2.3" {
+		t.Errorf("ClientIP() without middleware = %q, want 10.1.2.3", ip)
+	}
+}
@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"foodshop/internal/i18n"
+	"net/http"
+)
+
+// Language negotiates the response language from Accept-Language and
+// stores it in the request context (i18n.Language). Handlers may switch to
+// the user's preference once the user is known.
+func Language(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Language")
+		lang := i18n.Negotiate(r.Header.Get("Accept-Language"))
+		next.ServeHTTP(w, r.WithContext(i18n.WithLanguage(r.Context(), lang)))
+	})
+}
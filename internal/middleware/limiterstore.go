@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// LimitResult is the outcome of one LimiterStore.Take call.
+type LimitResult struct {
+	// Allowed reports whether the request may pass.
+	Allowed bool
+	// Limit is the quota reported in RateLimit-Limit.
+	Limit int
+	// Remaining is the number of requests left in the current quota.
+	Remaining int
+	// Reset is the time until the full quota is available again.
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed (only if !Allowed).
+	RetryAfter time.Duration
+}
+
+// LimiterStore keeps rate limit counters. The in-memory MemoryStore is the
+// default; shared stores (SQLite, Redis, see package ratelimit) let several
+// instances enforce one common limit.
+type LimiterStore interface {
+	// Take counts one request for key under policy and reports whether it is allowed.
+	Take(ctx context.Context, key string, policy RateLimitPolicy) (LimitResult, error)
+}
+
+// MemoryStore is a token bucket per key in process memory.
+type MemoryStore struct {
+	visitors map[string]*visitor
+	mu       sync.Mutex
+	cleanup  time.Duration
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type visitor struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+	ttl      time.Duration
+}
+
+// NewMemoryStore creates an in-memory token bucket store.
+func NewMemoryStore() *MemoryStore {
+	ms := &MemoryStore{
+		visitors: make(map[string]*visitor),
+		cleanup:  time.Minute * 5,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	// Start cleanup goroutine
+	go ms.cleanupVisitors()
+
+	return ms
+}
+
+// Take implements LimiterStore.
+func (ms *MemoryStore) Take(ctx context.Context, key string, policy RateLimitPolicy) (LimitResult, error) {
+	limiter := ms.getVisitor(key, policy)
+	burst := limiter.Burst()
+	every := float64(limiter.Limit())
+
+	now := time.Now()
+	reservation := limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		reservation.CancelAt(now)
+	}
+
+	tokens := math.Max(limiter.TokensAt(now), 0)
+	result := LimitResult{
+		Allowed:    delay == 0,
+		Limit:      burst,
+		Remaining:  int(tokens),
+		RetryAfter: delay,
+	}
+	if missing := float64(burst) - tokens; missing > 0 && every > 0 {
+		result.Reset = time.Duration(missing / every * float64(time.Second))
+	}
+	return result, nil
+}
+
+// getVisitor returns the rate limiter for a visitor (creating if needed).
+func (ms *MemoryStore) getVisitor(key string, policy RateLimitPolicy) *rate.Limiter {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	v, exists := ms.visitors[key]
+	if !exists {
+		burst := policy.Burst
+		if burst <= 0 {
+			burst = policy.Requests
+		}
+		limiter := rate.NewLimiter(rate.Limit(float64(policy.Requests)/policy.Period.Seconds()), burst)
+		// Keep the bucket at least one period, otherwise slow policies would reset early
+		ms.visitors[key] = &visitor{limiter, time.Now(), max(ms.cleanup, policy.Period)}
+		return limiter
+	}
+
+	v.lastSeen = time.Now()
+	return v.limiter
+}
+
+// Close stops the cleanup goroutine and waits until it has exited.
+func (ms *MemoryStore) Close() error {
+	ms.closeOnce.Do(func() { close(ms.stop) })
+	<-ms.done
+	return nil
+}
+
+// Running reports whether the cleanup goroutine is still running.
+func (ms *MemoryStore) Running() bool {
+	select {
+	case <-ms.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// cleanupVisitors removes old visitors to prevent memory leak.
+func (ms *MemoryStore) cleanupVisitors() {
+	defer close(ms.done)
+
+	ticker := time.NewTicker(ms.cleanup)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ms.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ms.mu.Lock()
+		for key, v := range ms.visitors {
+			if time.Since(v.lastSeen) > v.ttl {
+				delete(ms.visitors, key)
+			}
+		}
+		ms.mu.Unlock()
+	}
+}
@@ -1,11 +1,16 @@
 package middleware
 
 import (
-	"log"
+	"foodshop/internal/metrics"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
 )
 
+var requestDuration = metrics.Default.NewHistogramVec("http_request_duration_seconds",
+	"HTTP request latency by method, route and status code.", metrics.DefBuckets, "method", "route", "status")
+
 // responseWriter wraps http.ResponseWriter to capture status code.
 type responseWriter struct {
 	http.ResponseWriter
@@ -24,7 +29,9 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
-// Logger logs HTTP requests with structured information.
+// Logger writes one access log line per request and records it in the
+// http_request_duration_seconds histogram. It never logs headers, query
+// strings or bodies.
 func Logger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -38,17 +45,42 @@ func Logger(next http.Handler) http.Handler {
 		// Call the next handler
 		next.ServeHTTP(wrapped, r)
 
-		// Log request details
+		// Log request details; server errors at error level
 		duration := time.Since(start)
-		log.Printf(
-			"[%s] %s %s - Status: %d - Duration: %v - IP: %s - Bytes: %d",
-			r.Method,
-			r.URL.Path,
-			r.Proto,
-			wrapped.statusCode,
-			duration,
-			getIP(r),
-			wrapped.written,
+		level := slog.LevelInfo
+		if wrapped.statusCode >= 500 {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(r.Context(), level, "Request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("proto", r.Proto),
+			slog.Int("status", wrapped.statusCode),
+			slog.Float64("duration_ms", float64(duration.Microseconds())/1000),
+			slog.String("ip", ClientIP(r)),
+			slog.Int64("bytes", wrapped.written),
 		)
+		requestDuration.With(metricMethod(r.Method), metricRoute(r), strconv.Itoa(wrapped.statusCode)).Observe(duration.Seconds())
 	})
 }
+
+// metricMethod limits the method label to standard methods, so clients
+// cannot create arbitrary series.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
+
+// metricRoute returns the matched ServeMux pattern instead of the raw path,
+// which would create one series per URL. The mux sets r.Pattern on the
+// request it receives, so Logger must wrap the mux without replacing r.
+func metricRoute(r *http.Request) string {
+	if r.Pattern == "" {
+		return "unmatched"
+	}
+	return r.Pattern
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoggerMetrics(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") == "0" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	handler := Logger(Recovery(mux))
+
+	tests := []struct {
+		method, path string
+		route        string
+		status       string
+	}{
+		// The pattern, not the path, so IDs don't create new series
+		{http.MethodGet, "/orders/1", "/orders/{id}", "200"},
+		{http.MethodGet, "/orders/2", "/orders/{id}", "200"},
+		{http.MethodGet, "/orders/0", "/orders/{id}", "404"},
+		{http.MethodGet, "/panic", "/panic", "500"},
+		{http.MethodGet, "/nothing", "unmatched", "404"},
+		{"BREW", "/orders/1", "/orders/{id}", "200"},
+	}
+	before := make([]uint64, len(tests))
+	for i, tt := range tests {
+		before[i] = requestDuration.With(metricMethod(tt.method), tt.route, tt.status).Count()
+	}
+
+	for _, tt := range tests {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+	}
+
+	want := map[[3]string]uint64{}
+	for _, tt := range tests {
+		want[[3]string{metricMethod(tt.method), tt.route, tt.status}]++
+	}
+	for i, tt := range tests {
+		key := [3]string{metricMethod(tt.method), tt.route, tt.status}
+		got := requestDuration.With(key[0], key[1], key[2]).Count() - before[i]
+		if got != want[key] {
+			t.Errorf("Observations for %v = %d, want %d", key, got, want[key])
+		}
+	}
+	if metricMethod("BREW") != "OTHER" {
+		t.Errorf("metricMethod(BREW) = %q, want OTHER", metricMethod("BREW"))
+	}
+}
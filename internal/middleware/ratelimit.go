@@ -1,52 +1,159 @@
 package middleware
 
 import (
+	"fmt"
+	"foodshop/internal/metrics"
+	"foodshop/internal/problem"
+	"log/slog"
+	"math"
 	"net/http"
-	"sync"
+	"strconv"
+	"strings"
 	"time"
 
-	"golang.org/x/time/rate"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// RateLimiter implements token bucket rate limiting per IP address.
-type RateLimiter struct {
-	visitors map[string]*visitor
-	mu       sync.RWMutex
-	rate     rate.Limit
-	burst    int
-	cleanup  time.Duration
+var rateLimitedRequests = metrics.Default.NewCounterVec("ratelimit_rejected_requests_total",
+	"Requests rejected with 429 by rate limit policy.", "policy")
+
+// KeyFunc derives the rate limit key from a request.
+// It returns "" if the request has no such identity.
+type KeyFunc func(r *http.Request) string
+
+// KeyByIP keys requests by client IP.
+func KeyByIP(r *http.Request) string {
+	return "ip:" + ClientIP(r)
 }
 
-type visitor struct {
-	limiter  *rate.Limiter
-	lastSeen time.Time
+// KeyByUser keys requests by authenticated user ID (requires AuthMiddleware before the limiter).
+func KeyByUser(r *http.Request) string {
+	if userID, ok := GetUserID(r); ok {
+		return "user:" + strconv.FormatInt(userID, 10)
+	}
+	return ""
 }
 
-// NewRateLimiter creates a new rate limiter.
+// KeyByAPIClient keys requests by authenticated API client.
+func KeyByAPIClient(r *http.Request) string {
+	if client, ok := GetAPIClient(r); ok {
+		return "client:" + client
+	}
+	return ""
+}
+
+// KeyCombine keys requests by all available identities, e.g. user and IP.
+func KeyCombine(keys ...KeyFunc) KeyFunc {
+	return func(r *http.Request) string {
+		var parts []string
+		for _, key := range keys {
+			if k := key(r); k != "" {
+				parts = append(parts, k)
+			}
+		}
+		return strings.Join(parts, "|")
+	}
+}
+
+// RateLimitPolicy describes a named rate limit.
+type RateLimitPolicy struct {
+	// Name is reported in the RateLimit-Policy header and the 429 body.
+	Name string
+	// Requests allowed per Period.
+	Requests int
+	Period   time.Duration
+	// Burst is the maximum number of requests at once (default: Requests).
+	// Only the token bucket store uses it.
+	Burst int
+	// Key derives the bucket; requests without that identity fall back to the client IP.
+	Key KeyFunc
+	// Store keeps the counters (default: in-memory token bucket of this process).
+	Store LimiterStore
+}
+
+// RateLimiter enforces one policy. The counters live in the policy's LimiterStore.
+type RateLimiter struct {
+	policy RateLimitPolicy
+	// owned is the default store created by NewPolicyRateLimiter (closed by Close)
+	owned *MemoryStore
+}
+
+// NewRateLimiter creates a new rate limiter per IP address.
 // rate: requests per second allowed
 // burst: maximum burst size
 func NewRateLimiter(rps int, burst int) *RateLimiter {
-	rl := &RateLimiter{
-		visitors: make(map[string]*visitor),
-		rate:     rate.Limit(rps),
-		burst:    burst,
-		cleanup:  time.Minute * 5,
-	}
-
-	// Start cleanup goroutine
-	go rl.cleanupVisitors()
+	return NewPolicyRateLimiter(RateLimitPolicy{
+		Name:     "default",
+		Requests: rps,
+		Period:   time.Second,
+		Burst:    burst,
+		Key:      KeyByIP,
+	})
+}
 
+// NewPolicyRateLimiter creates a rate limiter for a named policy.
+func NewPolicyRateLimiter(policy RateLimitPolicy) *RateLimiter {
+	if policy.Burst <= 0 {
+		policy.Burst = policy.Requests
+	}
+	if policy.Key == nil {
+		policy.Key = KeyByIP
+	}
+	rl := &RateLimiter{policy: policy}
+	if policy.Store == nil {
+		rl.owned = NewMemoryStore()
+		rl.policy.Store = rl.owned
+	}
 	return rl
 }
 
-// Limit returns a middleware that rate limits requests per IP.
+// Close stops the default in-memory store. Stores passed in the policy
+// belong to the caller and are not closed.
+func (rl *RateLimiter) Close() error {
+	if rl.owned == nil {
+		return nil
+	}
+	return rl.owned.Close()
+}
+
+// Limit returns a middleware that rate limits requests per policy key.
+// Every response carries RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset
+// and RateLimit-Policy headers; rejected requests get 429 with Retry-After.
 func (rl *RateLimiter) Limit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := getIP(r)
+		key := rl.policy.Key(r)
+		if key == "" {
+			key = KeyByIP(r)
+		}
 
-		limiter := rl.getVisitor(ip)
-		if !limiter.Allow() {
-			http.Error(w, "Rate limit exceeded. Please try again later.", http.StatusTooManyRequests)
+		// Policy name in the key, so several policies can share one store
+		ctx, span := tracer.Start(r.Context(), "ratelimit.Take",
+			trace.WithAttributes(attribute.String("ratelimit.policy", rl.policy.Name)))
+		result, err := rl.policy.Store.Take(ctx, rl.policy.Name+"|"+key, rl.policy)
+		span.SetAttributes(attribute.Bool("ratelimit.allowed", result.Allowed))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		if err != nil {
+			// Fail open: an unavailable store must not take the whole API down
+			slog.ErrorContext(r.Context(), "Rate limit store error", "policy", rl.policy.Name, "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rl.setHeaders(w, result)
+
+		if !result.Allowed {
+			rateLimitedRequests.With(rl.policy.Name).Inc()
+			retryAfter := max(int(math.Ceil(result.RetryAfter.Seconds())), 1)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			problem.Write(w, r, problem.New(problem.CodeRateLimited).
+				With("policy", rl.policy.Name).
+				With("retry_after", retryAfter))
 			return
 		}
 
@@ -54,53 +161,51 @@ func (rl *RateLimiter) Limit(next http.Handler) http.Handler {
 	})
 }
 
-// getVisitor returns the rate limiter for a visitor (creating if needed).
-func (rl *RateLimiter) getVisitor(ip string) *rate.Limiter {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	v, exists := rl.visitors[ip]
-	if !exists {
-		limiter := rate.NewLimiter(rl.rate, rl.burst)
-		rl.visitors[ip] = &visitor{limiter, time.Now()}
-		return limiter
-	}
-
-	v.lastSeen = time.Now()
-	return v.limiter
+// setHeaders writes the RateLimit-* headers (IETF draft "RateLimit header fields for HTTP").
+func (rl *RateLimiter) setHeaders(w http.ResponseWriter, result LimitResult) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(max(result.Remaining, 0)))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d;name=%q", rl.policy.Requests, int(math.Ceil(rl.policy.Period.Seconds())), rl.policy.Name))
 }
 
-// cleanupVisitors removes old visitors to prevent memory leak.
-func (rl *RateLimiter) cleanupVisitors() {
-	ticker := time.NewTicker(rl.cleanup)
-	defer ticker.Stop()
+// RateLimitPolicies holds named rate limiters, so routes can attach
+// policies by name.
+type RateLimitPolicies map[string]*RateLimiter
 
-	for range ticker.C {
-		rl.mu.Lock()
-		for ip, v := range rl.visitors {
-			if time.Since(v.lastSeen) > rl.cleanup {
-				delete(rl.visitors, ip)
-			}
-		}
-		rl.mu.Unlock()
+// NewRateLimitPolicies creates a limiter for every policy.
+func NewRateLimitPolicies(policies ...RateLimitPolicy) RateLimitPolicies {
+	p := make(RateLimitPolicies, len(policies))
+	for _, policy := range policies {
+		p[policy.Name] = NewPolicyRateLimiter(policy)
 	}
+	return p
 }
 
-// getIP extracts the real IP address from the request.
-func getIP(r *http.Request) string {
-	// Check X-Forwarded-For header (proxy/load balancer)
-	forwarded := r.Header.Get("X-Forwarded-For")
-	if forwarded != "" {
-		// Take first IP if multiple
-		return forwarded
+// Close closes all limiters.
+func (p RateLimitPolicies) Close() error {
+	for _, rl := range p {
+		rl.Close()
 	}
+	return nil
+}
 
-	// Check X-Real-IP header
-	realIP := r.Header.Get("X-Real-IP")
-	if realIP != "" {
-		return realIP
+// Attach returns a middleware that applies the named policies in order.
+// It panics on unknown names, which is a configuration error at startup.
+func (p RateLimitPolicies) Attach(names ...string) func(http.Handler) http.Handler {
+	limiters := make([]*RateLimiter, 0, len(names))
+	for _, name := range names {
+		rl, ok := p[name]
+		if !ok {
+			panic(fmt.Sprintf("unknown rate limit policy %q", name))
+		}
+		limiters = append(limiters, rl)
 	}
 
-	// Fallback to RemoteAddr
-	return r.RemoteAddr
+	return func(next http.Handler) http.Handler {
+		for i := len(limiters) - 1; i >= 0; i-- {
+			next = limiters[i].Limit(next)
+		}
+		return next
+	}
 }
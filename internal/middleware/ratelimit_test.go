@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterPolicy(t *testing.T) {
+	rl := NewPolicyRateLimiter(RateLimitPolicy{Name: "login", Requests: 3, Period: time.Minute})
+	handler := rl.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.RemoteAddr = "203.0.113.5:1234"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want %d", i+1, rr.Code, http.StatusOK)
+		}
+		if got, want := rr.Header().Get("RateLimit-Remaining"), strconv.Itoa(2-i); got != want {
+			t.Errorf("request %d: RateLimit-Remaining = %q, want %q", i+1, got, want)
+		}
+		if got := rr.Header().Get("RateLimit-Limit"); got != "3" {
+			t.Errorf("RateLimit-Limit = %q, want %q", got, "3")
+		}
+		if got := rr.Header().Get("RateLimit-Policy"); got != `3;w=60;name="login"` {
+			t.Errorf("RateLimit-Policy = %q", got)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	req.RemoteAddr = "203.0.113.5:1234"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusTooManyRequests)
+	}
+	retryAfter, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+	if err != nil || retryAfter < 1 || retryAfter > 20 {
+		t.Errorf("Retry-After = %q, want 1..20 seconds", rr.Header().Get("Retry-After"))
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("Content-Type = %q, want application/problem+json", ct)
+	}
+
+	var body struct {
+		Code       string `json:"code"`
+		Detail     string `json:"detail"`
+		Policy     string `json:"policy"`
+		RetryAfter int    `json:"retry_after"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode 429 body: %v", err)
+	}
+	if body.Policy != "login" || body.RetryAfter != retryAfter || body.Code != "rate_limited" || body.Detail == "" {
+		t.Errorf("429 body = %+v", body)
+	}
+
+	// Another client has its own bucket
+	req = httptest.NewRequest(http.MethodPost, "/login", nil)
+	req.RemoteAddr = "203.0.113.6:1234"
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("other client: status = %d, want %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestRateLimiterKeyByUser(t *testing.T) {
+	rl := NewPolicyRateLimiter(RateLimitPolicy{Name: "authenticated", Requests: 1, Period: time.Minute, Key: KeyByUser})
+	handler := rl.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	request := func(userID int64, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+		req.RemoteAddr = remoteAddr
+		if userID != 0 {
+			req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	tests := []struct {
+		name       string
+		userID     int64
+		remoteAddr string
+		want       int
+	}{
+		{"User 1 first request", 1, "203.0.113.5:1", http.StatusOK},
+		{"User 1 from another IP", 1, "203.0.113.9:1", http.StatusTooManyRequests},
+		{"User 2 behind same IP", 2, "203.0.113.5:1", http.StatusOK},
+		{"Anonymous falls back to IP", 0, "203.0.113.5:1", http.StatusOK},
+		{"Anonymous same IP again", 0, "203.0.113.5:1", http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := request(tt.userID, tt.remoteAddr); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyCombine(t *testing.T) {
+	key := KeyCombine(KeyByAPIClient, KeyByUser, KeyByIP)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.5:1"
+	if got := key(req); got != "ip:203.0.113.5" {
+		t.Errorf("key = %q", got)
+	}
+
+	ctx := context.WithValue(req.Context(), UserIDKey, int64(7))
+	ctx = context.WithValue(ctx, APIClientKey, "billing")
+	if got := key(req.WithContext(ctx)); got != "client:billing|user:7|ip:203.0.113.5" {
+		t.Errorf("key = %q", got)
+	}
+}
+
+func TestRateLimitPoliciesAttach(t *testing.T) {
+	policies := NewRateLimitPolicies(
+		RateLimitPolicy{Name: "outer", Requests: 10, Period: time.Minute},
+		RateLimitPolicy{Name: "inner", Requests: 1, Period: time.Minute},
+	)
+	handler := policies.Attach("outer", "inner")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	codes := make([]int, 2)
+	for i := range codes {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		codes[i] = rr.Code
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("status codes = %v, want [200 429]", codes)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Attach() with unknown policy did not panic")
+		}
+	}()
+	policies.Attach("missing")
+}
@@ -1,7 +1,9 @@
 package middleware
 
 import (
-	"log"
+	"fmt"
+	"foodshop/internal/problem"
+	"log/slog"
 	"net/http"
 	"runtime/debug"
 )
@@ -12,10 +14,13 @@ func Recovery(next http.Handler) http.Handler {
 		defer func() {
 			if err := recover(); err != nil {
 				// Log the panic and stack trace
-				log.Printf("PANIC: %v\n%s", err, debug.Stack())
+				slog.ErrorContext(r.Context(), "Panic recovered",
+					"panic", fmt.Sprint(err),
+					"stack", string(debug.Stack()),
+				)
 
 				// Return 500 Internal Server Error
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				problem.Error(w, r, problem.CodeInternal)
 			}
 		}()
 
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"foodshop/internal/logging"
+	"net/http"
+)
+
+// RequestIDHeader carries the request ID in requests and responses.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits client supplied IDs.
+const maxRequestIDLength = 128
+
+// RequestID assigns every request an ID for the log lines it produces.
+// An X-Request-ID from the client or a proxy is kept if it is well-formed,
+// otherwise a random one is generated; either way it is echoed in the
+// response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// Mux matches requests to route patterns, like http.ServeMux or
+// router.Router.
+type Mux interface {
+	http.Handler
+	Handler(r *http.Request) (h http.Handler, pattern string)
+}
+
+// LogRoute stores the route pattern mux will match in the log context
+// before dispatching, so log lines written by handlers carry it as well.
+func LogRoute(mux Mux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			logging.SetRoute(r.Context(), pattern)
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID accepts IDs like UUIDs or trace IDs. Everything else is
+// replaced, so clients cannot inject arbitrary text into the logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"foodshop/internal/logging"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"Generated", "", false},
+		{"Client UUID kept", "0f8fad5b-d9cb-469f-a165-70867728950e", true},
+		{"Log injection replaced", "abc\n{\"level\":\"ERROR\"}", false},
+		{"Too long replaced", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = logging.RequestID(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			echoed := rec.Header().Get(RequestIDHeader)
+			if echoed == "" || echoed != seen {
+				t.Fatalf("Response ID %q, context ID %q, want the same non-empty ID", echoed, seen)
+			}
+			if tt.keep && echoed != tt.incoming {
+				t.Errorf("Request ID = %q, want incoming %q", echoed, tt.incoming)
+			}
+			if !tt.keep && (echoed == tt.incoming || len(echoed) != 32) {
+				t.Errorf("Request ID = %q, want a new 32 character ID", echoed)
+			}
+		})
+	}
+}
+
+func TestRequestLogAttributes(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.Options{})
+	if err != nil {
+		t.Fatalf("logging.New() failed: %v", err)
+	}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		logging.SetUserID(r.Context(), 7)
+		slog.WarnContext(r.Context(), "Handler line")
+	})
+	handler := RequestID(Logger(LogRoute(mux)))
+
+	req := httptest.NewRequest(http.MethodPost, "/orders/1", nil)
+	req.Header.Set(RequestIDHeader, "req-42")
+	req.Header.Set("Authorization", "Bearer secret-token")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Got %d log lines, want handler and access log:\n%s", len(lines), buf.String())
+	}
+	for _, l := range lines {
+		var line map[string]interface{}
+		if err := json.Unmarshal([]byte(l), &line); err != nil {
+			t.Fatalf("Invalid JSON log line %q: %v", l, err)
+		}
+		if line["request_id"] != "req-42" || line["route"] != "POST /orders/{id}" || line["user_id"] != float64(7) {
+			t.Errorf("Missing request attributes in %v", line)
+		}
+	}
+	if strings.Contains(buf.String(), "secret-token") {
+		t.Errorf("Authorization header leaked into the log:\n%s", buf.String())
+	}
+}
@@ -17,8 +17,10 @@ func SecurityHeaders(next http.Handler) http.Handler {
 		w.Header().Set("X-XSS-Protection", "1; mode=block")
 
 		// Strict Transport Security (HSTS) - force HTTPS
-		// Note: Only enable in production with HTTPS
-		// w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		// Only sent over TLS; browsers ignore it on plain HTTP (RFC 6797)
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		}
 
 		// Content Security Policy - prevent XSS
 		w.Header().Set("Content-Security-Policy", "default-src 'self'")
@@ -52,8 +54,10 @@ func CORS(allowedOrigins []string) func(http.Handler) http.Handler {
 				w.Header().Set("Access-Control-Allow-Origin", origin)
 			}
 
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Challenge-Response, X-Request-ID")
+			w.Header().Set("Access-Control-Expose-Headers",
+				"X-Request-ID, Deprecation, Link, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, RateLimit-Policy, Retry-After")
 			w.Header().Set("Access-Control-Max-Age", "3600")
 
 			// Handle preflight requests
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"foodshop/internal/problem"
 	"net/http"
 	"time"
 )
@@ -27,7 +28,7 @@ func Timeout(timeout time.Duration) func(http.Handler) http.Handler {
 				return
 			case <-ctx.Done():
 				// Request timed out
-				http.Error(w, "Request timeout", http.StatusRequestTimeout)
+				problem.Error(w, r, problem.CodeRequestTimeout)
 				return
 			}
 		})
@@ -0,0 +1,525 @@
+// Package migrate applies numbered SQL migrations. Every migration is a
+// pair of files "<version>_<name>.up.sql" and "<version>_<name>.down.sql";
+// applied versions are recorded with a checksum in the schema_migrations
+// table. Each step runs in its own transaction together with its
+// schema_migrations row, so a failed migration leaves no trace. Migrator.To
+// holds a database lock, so instances starting together migrate one after
+// the other.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Table records the applied migrations.
+const Table = "schema_migrations"
+
+const (
+	// advisoryLockID is the PostgreSQL advisory lock held while migrating
+	// (the first bytes of "foodshop").
+	advisoryLockID = 0x666f6f6473686f70
+	// lockTimeout limits how long To waits for another instance.
+	lockTimeout = 5 * time.Minute
+)
+
+// ErrChecksumMismatch is returned when an applied migration was edited
+// afterwards. Migrations are immutable once released; add a new one instead.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrUnknownVersion is returned when the database has a migration applied
+// that this binary does not know, e.g. after a rollback to an older release.
+var ErrUnknownVersion = errors.New("unknown migration version")
+
+// Migration is one schema change.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// String returns the file name prefix, e.g. "0002_user_language".
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// Checksum identifies the content of both scripts.
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.Up + "\x00" + m.Down))
+	return hex.EncodeToString(sum[:])
+}
+
+// Load reads the migrations from the top level of fsys, sorted by version.
+// Every version needs an up and a down script.
+func Load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, file := range files {
+		base, direction, ok := cutDirection(file)
+		if !ok {
+			return nil, fmt.Errorf("migration %s: name must end in .up.sql or .down.sql", file)
+		}
+		number, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(number)
+		if err != nil || version <= 0 || name == "" {
+			return nil, fmt.Errorf("migration %s: name must be <version>_<name>", file)
+		}
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d: conflicting names %q and %q", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			return nil, fmt.Errorf("migration %s: up and down script required", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func cutDirection(file string) (base, direction string, ok bool) {
+	if base, ok := strings.CutSuffix(file, ".up.sql"); ok {
+		return base, "up", true
+	}
+	if base, ok := strings.CutSuffix(file, ".down.sql"); ok {
+		return base, "down", true
+	}
+	return "", "", false
+}
+
+// Status describes one migration.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	// Checksum is the recorded checksum; it differs from
+	// Migration.Checksum() if the migration was edited after it was applied.
+	Checksum string
+	// Unknown marks an applied version without migration files.
+	Unknown bool
+}
+
+// Modified reports whether the applied migration differs from its files.
+func (s Status) Modified() bool {
+	return s.Applied && !s.Unknown && s.Checksum != s.Migration.Checksum()
+}
+
+// Dialect selects the placeholder syntax of the bookkeeping queries.
+type Dialect int
+
+const (
+	// SQLite uses ? placeholders.
+	SQLite Dialect = iota
+	// Postgres uses $1, $2, ... placeholders.
+	Postgres
+)
+
+// rebind rewrites the ? placeholders of query for the dialect. The
+// bookkeeping queries contain no string literals, so every ? is a
+// placeholder.
+func (d Dialect) rebind(query string) string {
+	if d != Postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// querier is implemented by *sql.DB and *sql.Conn.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Migrator applies migrations to a database.
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []Migration
+}
+
+// New returns a SQLite migrator for migrations (sorted, see Load).
+func New(db *sql.DB, migrations []Migration) *Migrator {
+	return NewDialect(db, SQLite, migrations)
+}
+
+// NewDialect returns a migrator for databases of the given dialect.
+func NewDialect(db *sql.DB, dialect Dialect, migrations []Migration) *Migrator {
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}
+}
+
+// Latest returns the highest known version (0 without migrations).
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// applied is a row of the schema_migrations table.
+type applied struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+func (m *Migrator) init(ctx context.Context, q querier) error {
+	_, err := q.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+Table+` (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", Table, err)
+	}
+	return nil
+}
+
+func (m *Migrator) applied(ctx context.Context, q querier) (map[int]applied, error) {
+	if err := m.init(ctx, q); err != nil {
+		return nil, err
+	}
+	rows, err := q.QueryContext(ctx, `SELECT version, checksum, applied_at FROM `+Table)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", Table, err)
+	}
+	defer rows.Close()
+	result := make(map[int]applied)
+	for rows.Next() {
+		var version int
+		var a applied
+		if err := rows.Scan(&version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, fmt.Errorf("read %s: %w", Table, err)
+		}
+		result[version] = a
+	}
+	return result, rows.Err()
+}
+
+// Status returns all known migrations and applied versions without files,
+// sorted by version.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	return m.status(ctx, m.db)
+}
+
+func (m *Migrator) status(ctx context.Context, q querier) ([]Status, error) {
+	done, err := m.applied(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	var states []Status
+	for _, mig := range m.migrations {
+		s := Status{Migration: mig}
+		if a, ok := done[mig.Version]; ok {
+			s.Applied, s.AppliedAt, s.Checksum = true, a.appliedAt, a.checksum
+			delete(done, mig.Version)
+		}
+		states = append(states, s)
+	}
+	for version, a := range done {
+		states = append(states, Status{Migration: Migration{Version: version}, Applied: true, AppliedAt: a.appliedAt, Checksum: a.checksum, Unknown: true})
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Version < states[j].Version })
+	return states, nil
+}
+
+// Version returns the highest applied version (0 for an empty database).
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	states, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	for _, s := range states {
+		if s.Applied {
+			version = s.Version
+		}
+	}
+	return version, nil
+}
+
+// verify fails if an applied migration is unknown or was modified.
+func verify(states []Status) error {
+	for _, s := range states {
+		switch {
+		case s.Unknown:
+			return fmt.Errorf("version %d is applied: %w", s.Version, ErrUnknownVersion)
+		case s.Modified():
+			return fmt.Errorf("migration %s: %w", s.Migration, ErrChecksumMismatch)
+		}
+	}
+	return nil
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.To(ctx, m.Latest())
+}
+
+// Down reverts the most recently applied migration.
+func (m *Migrator) Down(ctx context.Context) error {
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		return errors.New("no migration applied")
+	}
+	target := 0
+	for _, mig := range m.migrations {
+		if mig.Version < version {
+			target = mig.Version
+		}
+	}
+	return m.To(ctx, target)
+}
+
+// To migrates up or down until version is the highest applied one (0
+// reverts everything). Applied migrations are verified first; nothing is
+// changed if one is unknown or was modified. Concurrent calls, also from
+// other processes, wait for each other (see lock).
+func (m *Migrator) To(ctx context.Context, version int) (err error) {
+	if version != 0 && !m.known(version) {
+		return fmt.Errorf("version %d: %w", version, ErrUnknownVersion)
+	}
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	unlock, err := m.lock(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("lock migrations: %w", err)
+	}
+	defer func() {
+		if unlockErr := unlock(); unlockErr != nil {
+			// Don't return a connection that may still hold the lock to the pool
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+			if err == nil {
+				err = fmt.Errorf("unlock migrations: %w", unlockErr)
+			}
+		}
+	}()
+
+	// Read the state under the lock, another instance may have migrated meanwhile
+	states, err := m.status(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if err := verify(states); err != nil {
+		return err
+	}
+
+	// Revert newest first, then apply oldest first
+	for i := len(states) - 1; i >= 0; i-- {
+		if s := states[i]; s.Applied && s.Version > version {
+			if err := m.step(ctx, conn, s.Migration, false); err != nil {
+				return err
+			}
+		}
+	}
+	for _, s := range states {
+		if !s.Applied && s.Version <= version {
+			if err := m.step(ctx, conn, s.Migration, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lock serializes migrations across processes. PostgreSQL takes a session
+// advisory lock. SQLite has no such lock, so the whole run is one
+// BEGIN IMMEDIATE transaction (the write lock) and every step a savepoint
+// in it; the returned unlock commits the steps that succeeded.
+func (m *Migrator) lock(ctx context.Context, conn *sql.Conn) (unlock func() error, err error) {
+	// Release with a fresh context, ctx may be canceled by then
+	release := context.Background()
+
+	if m.dialect == Postgres {
+		lockCtx, cancel := context.WithTimeout(ctx, lockTimeout)
+		defer cancel()
+		if _, err := conn.ExecContext(lockCtx, `SELECT pg_advisory_lock($1)`, advisoryLockID); err != nil {
+			return nil, err
+		}
+		return func() error {
+			_, err := conn.ExecContext(release, `SELECT pg_advisory_unlock($1)`, advisoryLockID)
+			return err
+		}, nil
+	}
+
+	var busyTimeout int
+	if err := conn.QueryRowContext(ctx, "PRAGMA busy_timeout").Scan(&busyTimeout); err != nil {
+		return nil, err
+	}
+	restore := func() { conn.ExecContext(release, fmt.Sprintf("PRAGMA busy_timeout = %d", busyTimeout)) }
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA busy_timeout = %d", lockTimeout.Milliseconds())); err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		restore()
+		return nil, err
+	}
+	return func() error {
+		defer restore()
+		if _, err := conn.ExecContext(release, "COMMIT"); err != nil {
+			conn.ExecContext(release, "ROLLBACK")
+			return err
+		}
+		return nil
+	}, nil
+}
+
+// Baseline records the migrations up to version as applied without running
+// them. It is meant for databases whose schema was created before they
+// were tracked.
+func (m *Migrator) Baseline(ctx context.Context, version int) error {
+	if err := m.init(ctx, m.db); err != nil {
+		return err
+	}
+	for _, mig := range m.migrations {
+		if mig.Version > version {
+			break
+		}
+		if _, err := m.db.ExecContext(ctx, m.dialect.rebind(`INSERT INTO `+Table+` (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)`),
+			mig.Version, mig.Name, mig.Checksum(), time.Now().UTC()); err != nil {
+			return fmt.Errorf("baseline migration %s: %w", mig, err)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) known(version int) bool {
+	for _, mig := range m.migrations {
+		if mig.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// step applies (up) or reverts one migration in a transaction (a
+// savepoint on SQLite, see lock).
+func (m *Migrator) step(ctx context.Context, conn *sql.Conn, mig Migration, up bool) (err error) {
+	direction, script := "down", mig.Down
+	if up {
+		direction, script = "up", mig.Up
+	}
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("migration %s %s: %w", mig, direction, err)
+		}
+	}()
+
+	tx, err := m.begin(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if up {
+		_, err = tx.ExecContext(ctx, m.dialect.rebind(`INSERT INTO `+Table+` (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)`),
+			mig.Version, mig.Name, mig.Checksum(), time.Now().UTC())
+	} else {
+		_, err = tx.ExecContext(ctx, m.dialect.rebind(`DELETE FROM `+Table+` WHERE version = ?`), mig.Version)
+	}
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Migration "+direction, "version", mig.Version, "name", mig.Name)
+	return nil
+}
+
+// stepTx is the transaction of one step.
+type stepTx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	Commit() error
+	Rollback() error
+}
+
+func (m *Migrator) begin(ctx context.Context, conn *sql.Conn) (stepTx, error) {
+	if m.dialect == Postgres {
+		return conn.BeginTx(ctx, nil)
+	}
+	if _, err := conn.ExecContext(ctx, "SAVEPOINT migration_step"); err != nil {
+		return nil, err
+	}
+	return &savepoint{conn: conn}, nil
+}
+
+// savepoint is a step inside the BEGIN IMMEDIATE transaction of SQLite.
+type savepoint struct {
+	conn *sql.Conn
+	done bool
+}
+
+func (sp *savepoint) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return sp.conn.ExecContext(ctx, query, args...)
+}
+
+func (sp *savepoint) Commit() error {
+	if _, err := sp.conn.ExecContext(context.Background(), "RELEASE migration_step"); err != nil {
+		return err
+	}
+	sp.done = true
+	return nil
+}
+
+// Rollback undoes the step; the enclosing transaction stays open.
+func (sp *savepoint) Rollback() error {
+	if sp.done {
+		return sql.ErrTxDone
+	}
+	sp.done = true
+	_, err := sp.conn.ExecContext(context.Background(), "ROLLBACK TO migration_step; RELEASE migration_step")
+	return err
+}
@@ -0,0 +1,256 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var testFiles = fstest.MapFS{
+	"0001_items.up.sql":      {Data: []byte("CREATE TABLE items (id INTEGER PRIMARY KEY);")},
+	"0001_items.down.sql":    {Data: []byte("DROP TABLE items;")},
+	"0002_name.up.sql":       {Data: []byte("ALTER TABLE items ADD COLUMN name TEXT;")},
+	"0002_name.down.sql":     {Data: []byte("ALTER TABLE items DROP COLUMN name;")},
+	"0003_tags.up.sql":       {Data: []byte("CREATE TABLE tags (id INTEGER PRIMARY KEY);\nCREATE INDEX idx_tags ON tags(id);")},
+	"0003_tags.down.sql":     {Data: []byte("DROP TABLE tags;")},
+	"README.md":              {Data: []byte("ignored")},
+	"testdata/0004_x.up.sql": {Data: []byte("ignored, not top level")},
+}
+
+func newTestMigrator(t *testing.T, files fstest.MapFS) (*Migrator, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("sql.Open() failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrations, err := Load(files)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	return New(db, migrations), db
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&n); err != nil {
+		t.Fatalf("Query sqlite_master failed: %v", err)
+	}
+	return n > 0
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(testFiles)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if len(migrations) != 3 || migrations[0].Name != "items" || migrations[2].Version != 3 {
+		t.Errorf("Load() = %+v, want versions 1-3", migrations)
+	}
+
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		want  string
+	}{
+		{"Missing down", fstest.MapFS{"0001_a.up.sql": {Data: []byte("SELECT 1;")}}, "up and down"},
+		{"Bad version", fstest.MapFS{"one_a.up.sql": {Data: []byte("SELECT 1;")}}, "<version>_<name>"},
+		{"Bad suffix", fstest.MapFS{"0001_a.sql": {Data: []byte("SELECT 1;")}}, ".up.sql"},
+		{"Conflicting names", fstest.MapFS{
+			"0001_a.up.sql":   {Data: []byte("SELECT 1;")},
+			"0001_b.down.sql": {Data: []byte("SELECT 1;")},
+		}, "conflicting names"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Load(tt.files); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpDownTo(t *testing.T) {
+	m, db := newTestMigrator(t, testFiles)
+	ctx := context.Background()
+
+	steps := []struct {
+		name    string
+		run     func() error
+		version int
+		tags    bool
+	}{
+		{"Up", func() error { return m.Up(ctx) }, 3, true},
+		{"Up again", func() error { return m.Up(ctx) }, 3, true},
+		{"Down", func() error { return m.Down(ctx) }, 2, false},
+		{"To 1", func() error { return m.To(ctx, 1) }, 1, false},
+		{"To 3", func() error { return m.To(ctx, 3) }, 3, true},
+		{"To 0", func() error { return m.To(ctx, 0) }, 0, false},
+	}
+	for _, s := range steps {
+		if err := s.run(); err != nil {
+			t.Fatalf("%s failed: %v", s.name, err)
+		}
+		if version, err := m.Version(ctx); err != nil || version != s.version {
+			t.Errorf("%s: Version() = %d, %v, want %d", s.name, version, err, s.version)
+		}
+		if tableExists(t, db, "tags") != s.tags {
+			t.Errorf("%s: table tags exists = %v, want %v", s.name, !s.tags, s.tags)
+		}
+	}
+
+	if err := m.Down(ctx); err == nil {
+		t.Error("Down() without applied migrations succeeded")
+	}
+	if err := m.To(ctx, 7); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("To(7) error = %v, want ErrUnknownVersion", err)
+	}
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	files := fstest.MapFS{
+		"0001_items.up.sql":    testFiles["0001_items.up.sql"],
+		"0001_items.down.sql":  testFiles["0001_items.down.sql"],
+		"0002_broken.up.sql":   {Data: []byte("CREATE TABLE half (id INTEGER);\nINSERT INTO missing VALUES (1);")},
+		"0002_broken.down.sql": {Data: []byte("DROP TABLE half;")},
+	}
+	m, db := newTestMigrator(t, files)
+	ctx := context.Background()
+
+	err := m.Up(ctx)
+	if err == nil || !strings.Contains(err.Error(), "0002_broken up") {
+		t.Fatalf("Up() error = %v, want failure of 0002_broken", err)
+	}
+	if tableExists(t, db, "half") {
+		t.Error("Table of the failed migration was not rolled back")
+	}
+	if version, _ := m.Version(ctx); version != 1 {
+		t.Errorf("Version() = %d, want 1 (migrations before the failure stay applied)", version)
+	}
+}
+
+// TestConcurrentUp simulates replicas starting together: each has its own
+// connection pool on the same database and applies the migrations.
+func TestConcurrentUp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	migrations, err := Load(testFiles)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	const replicas = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, replicas)
+	for range replicas {
+		db, err := sql.Open("sqlite3", path)
+		if err != nil {
+			t.Fatalf("sql.Open() failed: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- New(db, migrations).Up(context.Background())
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Concurrent Up() failed: %v", err)
+		}
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("sql.Open() failed: %v", err)
+	}
+	defer db.Close()
+	if version, err := New(db, migrations).Version(context.Background()); err != nil || version != 3 {
+		t.Errorf("Version() = %d, %v, want 3", version, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Modified migration", func(t *testing.T) {
+		m, db := newTestMigrator(t, testFiles)
+		if err := m.To(ctx, 2); err != nil {
+			t.Fatalf("To(2) failed: %v", err)
+		}
+		edited := fstest.MapFS{}
+		for name, f := range testFiles {
+			edited[name] = f
+		}
+		edited["0002_name.up.sql"] = &fstest.MapFile{Data: []byte("ALTER TABLE items ADD COLUMN title TEXT;")}
+		migrations, _ := Load(edited)
+		m = New(db, migrations)
+
+		if err := m.Up(ctx); !errors.Is(err, ErrChecksumMismatch) {
+			t.Errorf("Up() error = %v, want ErrChecksumMismatch", err)
+		}
+		if tableExists(t, db, "tags") {
+			t.Error("Up() applied migrations despite the checksum mismatch")
+		}
+		states, err := m.Status(ctx)
+		if err != nil {
+			t.Fatalf("Status() failed: %v", err)
+		}
+		if !states[1].Modified() || states[0].Modified() || states[2].Applied {
+			t.Errorf("Status() = %+v, want only 0002 modified and 0003 pending", states)
+		}
+	})
+
+	t.Run("Unknown version", func(t *testing.T) {
+		m, db := newTestMigrator(t, testFiles)
+		if err := m.Up(ctx); err != nil {
+			t.Fatalf("Up() failed: %v", err)
+		}
+		migrations, _ := Load(testFiles)
+		m = New(db, migrations[:2])
+		if err := m.Down(ctx); !errors.Is(err, ErrUnknownVersion) {
+			t.Errorf("Down() error = %v, want ErrUnknownVersion", err)
+		}
+		states, _ := m.Status(ctx)
+		if len(states) != 3 || !states[2].Unknown {
+			t.Errorf("Status() = %+v, want version 3 as unknown", states)
+		}
+	})
+}
+
+func TestBaseline(t *testing.T) {
+	m, db := newTestMigrator(t, testFiles)
+	ctx := context.Background()
+	if _, err := db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatalf("Create table failed: %v", err)
+	}
+
+	if err := m.Baseline(ctx, 2); err != nil {
+		t.Fatalf("Baseline() failed: %v", err)
+	}
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("Up() after Baseline() failed: %v", err)
+	}
+	if version, _ := m.Version(ctx); version != 3 || !tableExists(t, db, "tags") {
+		t.Errorf("Version() = %d, want 3 with table tags", version)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	query := `INSERT INTO t (a, b) VALUES (?, ?)`
+	if got := SQLite.rebind(query); got != query {
+		t.Errorf("SQLite.rebind() = %q, want unchanged", got)
+	}
+	if got, want := Postgres.rebind(query), `INSERT INTO t (a, b) VALUES ($1, $2)`; got != want {
+		t.Errorf("Postgres.rebind() = %q, want %q", got, want)
+	}
+}
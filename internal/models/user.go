@@ -2,11 +2,6 @@ package models
 
 import "time"
 
-// ErrUserLogin represents an error response for user login/registration.
-type ErrUserLogin struct {
-	Message string `json:"message"`
-}
-
 // UserLogin represents login credentials.
 type UserLogin struct {
 	Username string `json:"username"`
@@ -25,6 +20,14 @@ type LoginResponse struct {
 	} `json:"user"`
 }
 
+// PasswordChange represents a password change request.
+type PasswordChange struct {
+	Username                string `json:"username"`
+	Password                string `json:"password"`
+	NewPassword             string `json:"new_password"`
+	NewPasswordVerification string `json:"new_password_verification"`
+}
+
 // UserRegistration represents registration data.
 type UserRegistration struct {
 	Username             string `json:"username"`
@@ -42,6 +45,9 @@ type User struct {
 	IsActive            bool       `json:"is_active"`
 	CreatedAt           time.Time  `json:"created_at"`
 	DeactivedAt         *time.Time `json:"deactived_at,omitempty"`
-	FailedLoginAttempts int        `json:"-"` // Don't expose in API
-	LockedUntil         *time.Time `json:"-"` // Don't expose in API
+	FailedLoginAttempts int        `json:"-"`                  // Don't expose in API
+	LockedUntil         *time.Time `json:"-"`                  // Don't expose in API
+	PepperVersion       int        `json:"-"`                  // Pepper version the password hash was created with
+	PasswordChangedAt   *time.Time `json:"-"`                  // Last password change (NULL for users created before tracking)
+	Language            string     `json:"language,omitempty"` // Preferred message language ("" = Accept-Language)
 }
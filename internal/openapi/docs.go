@@ -0,0 +1,70 @@
+package openapi
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"sort"
+)
+
+// docsTemplate renders the endpoints without scripts or inline styles, so
+// it works with the Content-Security-Policy of SecurityHeaders.
+var docsTemplate = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Info.Title}}</title>
+</head>
+<body>
+<h1>{{.Info.Title}} <small>{{.Info.Version}}</small></h1>
+<p>{{.Info.Description}}</p>
+<p>Machine-readable document: <a href="/openapi.json">/openapi.json</a></p>
+{{range .Endpoints}}
+<section id="{{.OperationID}}">
+<h2>{{if .Deprecated}}<del>{{.Method}} {{.Path}}</del> (deprecated){{else}}{{.Method}} {{.Path}}{{end}}</h2>
+<p><strong>{{.Summary}}</strong>{{if .Security}} &mdash; requires a bearer token{{end}}</p>
+{{with .Description}}<p>{{.}}</p>{{end}}
+{{with .RequestBody}}<p>Request body: <code>{{.Name}}</code></p>{{end}}
+<p>Responses: {{range $i, $code := .Codes}}{{if $i}}, {{end}}<code>{{$code}}</code>{{end}}</p>
+</section>
+{{end}}
+</body>
+</html>
+`))
+
+// docsEndpoint adds the sorted response codes for the template.
+type docsEndpoint struct {
+	Endpoint
+	Codes []string
+}
+
+// DocsHandler serves the document as an HTML page. It panics if the
+// embedded document is invalid, which the package tests rule out.
+func DocsHandler() http.HandlerFunc {
+	doc, err := Parse()
+	if err != nil {
+		panic("openapi: " + err.Error())
+	}
+	var endpoints []docsEndpoint
+	for _, e := range doc.Endpoints() {
+		codes := make([]string, 0, len(e.Responses))
+		for code := range e.Responses {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		endpoints = append(endpoints, docsEndpoint{Endpoint: e, Codes: codes})
+	}
+
+	// The document is embedded, so the page is rendered once
+	var page bytes.Buffer
+	if err := docsTemplate.Execute(&page, struct {
+		*Document
+		Endpoints []docsEndpoint
+	}{doc, endpoints}); err != nil {
+		panic("openapi: " + err.Error())
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page.Bytes())
+	}
+}
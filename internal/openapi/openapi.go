@@ -0,0 +1,96 @@
+// Package openapi serves the OpenAPI 3.1 description of the API
+// (openapi.json) and a small HTML rendering of it. The document is written
+// by hand; a test in cmd/web fails when a registered route is missing.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+// Spec returns the raw OpenAPI document.
+func Spec() []byte {
+	return spec
+}
+
+// Document is the part of the OpenAPI document the docs page and the
+// route tests use.
+type Document struct {
+	OpenAPI string `json:"openapi"`
+	Info    struct {
+		Title       string `json:"title"`
+		Version     string `json:"version"`
+		Description string `json:"description"`
+	} `json:"info"`
+	Paths map[string]map[string]Operation `json:"paths"`
+}
+
+// Operation is one method of a path.
+type Operation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary"`
+	Description string                     `json:"description"`
+	Deprecated  bool                       `json:"deprecated"`
+	Security    []map[string][]string      `json:"security"`
+	RequestBody *Ref                       `json:"requestBody"`
+	Responses   map[string]json.RawMessage `json:"responses"`
+}
+
+// Ref is a JSON reference ("#/components/...").
+type Ref struct {
+	Ref string `json:"$ref"`
+}
+
+// Name returns the last segment of the reference.
+func (r Ref) Name() string {
+	return r.Ref[strings.LastIndexByte(r.Ref, '/')+1:]
+}
+
+// Endpoint is an operation with its method and path.
+type Endpoint struct {
+	Method string
+	Path   string
+	Operation
+}
+
+// Parse decodes the embedded document.
+func Parse() (*Document, error) {
+	var doc Document
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// Endpoints returns all operations sorted by path and method. Methods are
+// upper case like in route patterns.
+func (d *Document) Endpoints() []Endpoint {
+	var endpoints []Endpoint
+	for path, item := range d.Paths {
+		for method, op := range item {
+			endpoints = append(endpoints, Endpoint{Method: strings.ToUpper(method), Path: path, Operation: op})
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].Path != endpoints[j].Path {
+			return endpoints[i].Path < endpoints[j].Path
+		}
+		return endpoints[i].Method < endpoints[j].Method
+	})
+	return endpoints
+}
+
+// Handler serves the OpenAPI document.
+func Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(spec)
+	}
+}
@@ -0,0 +1,135 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"foodshop/internal/problem"
+	"foodshop/internal/validator"
+)
+
+// lookup follows a JSON pointer like "#/components/schemas/User".
+func lookup(doc interface{}, pointer string) (interface{}, bool) {
+	node := doc
+	for _, part := range strings.Split(strings.TrimPrefix(pointer, "#/"), "/") {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if node, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+func decodeSpec(t *testing.T) map[string]interface{} {
+	t.Helper()
+	var doc map[string]interface{}
+	if err := json.Unmarshal(Spec(), &doc); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	return doc
+}
+
+func TestSpecReferences(t *testing.T) {
+	doc := decodeSpec(t)
+	if doc["openapi"] != "3.1.0" {
+		t.Errorf("openapi = %v, want 3.1.0", doc["openapi"])
+	}
+
+	var walk func(path string, node interface{})
+	walk = func(path string, node interface{}) {
+		switch node := node.(type) {
+		case map[string]interface{}:
+			if ref, ok := node["$ref"].(string); ok {
+				if _, ok := lookup(doc, ref); !ok {
+					t.Errorf("%s: unresolved $ref %q", path, ref)
+				}
+			}
+			for k, v := range node {
+				walk(path+"/"+k, v)
+			}
+		case []interface{}:
+			for _, v := range node {
+				walk(path, v)
+			}
+		}
+	}
+	walk("#", doc)
+
+	seen := make(map[string]string)
+	d, err := Parse()
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	for _, e := range d.Endpoints() {
+		if e.OperationID == "" || len(e.Responses) == 0 {
+			t.Errorf("%s %s: operationId and responses are required", e.Method, e.Path)
+		}
+		if other, ok := seen[e.OperationID]; ok {
+			t.Errorf("operationId %q used by %s and %s %s", e.OperationID, other, e.Method, e.Path)
+		}
+		seen[e.OperationID] = e.Method + " " + e.Path
+	}
+}
+
+// TestSpecMatchesCode checks the parts of the document that are defined in
+// code: error codes and validation limits.
+func TestSpecMatchesCode(t *testing.T) {
+	doc := decodeSpec(t)
+
+	enum, _ := lookup(doc, "#/components/schemas/ErrorCode/enum")
+	var documented []string
+	for _, code := range enum.([]interface{}) {
+		documented = append(documented, code.(string))
+	}
+	slices.Sort(documented)
+	var defined []string
+	for _, code := range problem.Codes() {
+		defined = append(defined, string(code))
+	}
+	if !slices.Equal(documented, defined) {
+		t.Errorf("ErrorCode enum = %v, want problem codes %v", documented, defined)
+	}
+
+	limits := []struct {
+		pointer string
+		want    int
+	}{
+		{"#/components/schemas/UserRegistration/properties/username/minLength", validator.MinUsernameLength},
+		{"#/components/schemas/UserRegistration/properties/username/maxLength", validator.MaxUsernameLength},
+		{"#/components/schemas/UserRegistration/properties/password/minLength", validator.MinPasswordLength},
+		{"#/components/schemas/UserRegistration/properties/password/maxLength", validator.MaxPasswordLength},
+		{"#/components/schemas/UserRegistration/properties/email/maxLength", validator.MaxEmailLength},
+		{"#/components/schemas/PasswordChange/properties/new_password/minLength", validator.MinPasswordLength},
+		{"#/components/schemas/UpdateUserRequest/properties/password/minLength", validator.MinPasswordLength},
+	}
+	for _, l := range limits {
+		got, ok := lookup(doc, l.pointer)
+		if !ok || got != float64(l.want) {
+			t.Errorf("%s = %v, want %d", l.pointer, got, l.want)
+		}
+	}
+}
+
+func TestDocsHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	DocsHandler()(w, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Got %d, want 200", w.Code)
+	}
+	page := w.Body.String()
+	for _, want := range []string{"POST /api/v1/login", "<del>POST /login</del> (deprecated)", "requires a bearer token", `href="/openapi.json"`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Docs page does not contain %q", want)
+		}
+	}
+	if strings.Contains(page, "<script") || strings.Contains(page, "style=") {
+		t.Error("Docs page must not need inline scripts or styles (CSP default-src 'self')")
+	}
+}
@@ -0,0 +1,163 @@
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const argon2idID = "argon2id"
+
+// Argon2Params holds the tunable argon2id parameters.
+type Argon2Params struct {
+	// Memory in KiB.
+	Memory uint32
+	// Iterations (time cost).
+	Iterations uint32
+	// Parallelism (number of lanes).
+	Parallelism uint8
+	// SaltLength in bytes.
+	SaltLength uint32
+	// KeyLength in bytes.
+	KeyLength uint32
+}
+
+// DefaultArgon2Params follows the OWASP Password Storage Cheat Sheet
+// recommendation (19 MiB, 2 iterations, 1 lane).
+var DefaultArgon2Params = Argon2Params{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Bounds for argon2id parameters. Stored hashes outside of them are
+// rejected before hashing, so a tampered row can neither crash the server
+// (p=0) nor make it allocate unbounded memory.
+const (
+	MaxArgon2Memory      = 1024 * 1024 // KiB (1 GiB)
+	MaxArgon2Iterations  = 64
+	MaxArgon2Parallelism = 64
+	MinArgon2SaltLength  = 8
+	MinArgon2KeyLength   = 16
+	MaxArgon2Length      = 1024 // salt and key, bytes
+)
+
+// Validate returns an error if p is outside the supported bounds.
+// The memory has to be at least 8 KiB per lane (RFC 9106).
+func (p Argon2Params) Validate() error {
+	switch {
+	case p.Parallelism < 1 || p.Parallelism > MaxArgon2Parallelism:
+		return fmt.Errorf("argon2 parallelism must be between 1 and %d, got %d", MaxArgon2Parallelism, p.Parallelism)
+	case p.Memory < 8*uint32(p.Parallelism) || p.Memory > MaxArgon2Memory:
+		return fmt.Errorf("argon2 memory must be between %d and %d KiB, got %d", 8*uint32(p.Parallelism), MaxArgon2Memory, p.Memory)
+	case p.Iterations < 1 || p.Iterations > MaxArgon2Iterations:
+		return fmt.Errorf("argon2 iterations must be between 1 and %d, got %d", MaxArgon2Iterations, p.Iterations)
+	case p.SaltLength < MinArgon2SaltLength || p.SaltLength > MaxArgon2Length:
+		return fmt.Errorf("argon2 salt length must be between %d and %d bytes, got %d", MinArgon2SaltLength, MaxArgon2Length, p.SaltLength)
+	case p.KeyLength < MinArgon2KeyLength || p.KeyLength > MaxArgon2Length:
+		return fmt.Errorf("argon2 key length must be between %d and %d bytes, got %d", MinArgon2KeyLength, MaxArgon2Length, p.KeyLength)
+	}
+	return nil
+}
+
+// Argon2id implements Algorithm using argon2id.
+type Argon2id struct {
+	params Argon2Params
+}
+
+// NewArgon2id creates an argon2id Algorithm with the given parameters.
+func NewArgon2id(params Argon2Params) *Argon2id {
+	return &Argon2id{params: params}
+}
+
+// ID returns "argon2id".
+func (a *Argon2id) ID() string { return argon2idID }
+
+// Hash hashes the password with a random salt and returns the PHC string.
+func (a *Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.params.Iterations, a.params.Memory, a.params.Parallelism, a.params.KeyLength)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idID,
+		argon2.Version,
+		a.params.Memory,
+		a.params.Iterations,
+		a.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify recomputes the key with the parameters stored in the hash
+// and compares it in constant time.
+func (a *Argon2id) Verify(encoded, password string) error {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// NeedsRehash reports whether the hash was created with different parameters.
+func (a *Argon2id) NeedsRehash(encoded string) bool {
+	params, _, _, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params != a.params
+}
+
+// decodeArgon2id parses "$argon2id$v=19$m=...,t=...,p=...$salt$key".
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != argon2idID {
+		return params, nil, nil, ErrMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("%w: unsupported argon2 version %d", ErrMalformedHash, version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	if err := params.Validate(); err != nil {
+		return params, nil, nil, fmt.Errorf("%w: %v", ErrMalformedHash, err)
+	}
+
+	return params, salt, key, nil
+}
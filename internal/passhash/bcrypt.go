@@ -0,0 +1,59 @@
+package passhash
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const bcryptID = "bcrypt"
+
+// DefaultBcryptCost is the cost used for bcrypt hashes.
+const DefaultBcryptCost = bcrypt.DefaultCost
+
+// Bcrypt implements Algorithm using bcrypt. It is kept to verify hashes
+// created before argon2id became the default.
+// Note: bcrypt only uses the first 72 bytes of a password, so Hash rejects
+// longer passwords instead of silently truncating them.
+type Bcrypt struct {
+	cost int
+}
+
+// NewBcrypt creates a bcrypt Algorithm with the given cost.
+func NewBcrypt(cost int) *Bcrypt {
+	return &Bcrypt{cost: cost}
+}
+
+// ID returns "bcrypt".
+func (b *Bcrypt) ID() string { return bcryptID }
+
+// Hash hashes the password with bcrypt.
+func (b *Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.cost)
+	if err != nil {
+		return "", fmt.Errorf("bcrypt: %w", err)
+	}
+	return string(hash), nil
+}
+
+// Verify compares the password with the bcrypt hash.
+func (b *Bcrypt) Verify(encoded, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrMismatch
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedHash, err)
+	}
+	return nil
+}
+
+// NeedsRehash reports whether the hash uses a different cost.
+func (b *Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return true
+	}
+	return cost != b.cost
+}
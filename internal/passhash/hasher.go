@@ -0,0 +1,130 @@
+package passhash
+
+import (
+	"errors"
+	"foodshop/internal/metrics"
+	"strings"
+	"time"
+)
+
+// hashDuration shows the real cost of the hash parameters in production.
+var hashDuration = metrics.Default.NewHistogramVec("password_hash_duration_seconds",
+	"Time spent hashing and verifying passwords by algorithm (argon2id, bcrypt) and operation (hash, verify).",
+	[]float64{.01, .025, .05, .1, .25, .5, 1, 2.5}, "algorithm", "operation")
+
+var (
+	// ErrMismatch is returned when a password does not match the stored hash.
+	ErrMismatch = errors.New("password does not match")
+	// ErrUnknownAlgorithm is returned when a stored hash uses an unsupported algorithm.
+	ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+	// ErrMalformedHash is returned when a stored hash cannot be parsed.
+	ErrMalformedHash = errors.New("malformed password hash")
+)
+
+// Algorithm hashes and verifies passwords for one specific scheme.
+// Encoded hashes are PHC strings, e.g. "$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>".
+type Algorithm interface {
+	// ID returns the PHC identifier of the algorithm (e.g. "argon2id").
+	ID() string
+	// Hash hashes the password and returns the encoded hash.
+	Hash(password string) (string, error)
+	// Verify compares the password with the encoded hash.
+	// Returns ErrMismatch if the password is wrong.
+	Verify(encoded, password string) error
+	// NeedsRehash reports whether the encoded hash was created with
+	// parameters other than the current ones.
+	NeedsRehash(encoded string) bool
+}
+
+// Hasher is the abstraction the rest of the app depends on.
+// It hashes new passwords with the current algorithm and verifies
+// hashes created by any supported algorithm.
+type Hasher interface {
+	// Hash hashes the password with the current algorithm and parameters.
+	Hash(password string) (string, error)
+	// Verify compares the password with the encoded hash.
+	Verify(encoded, password string) error
+	// NeedsRehash reports whether the encoded hash should be upgraded
+	// to the current algorithm and parameters.
+	NeedsRehash(encoded string) bool
+}
+
+// Multi hashes with a current algorithm and verifies with any of the
+// registered algorithms. This allows existing bcrypt rows to keep working
+// while new and upgraded hashes use argon2id.
+type Multi struct {
+	current    Algorithm
+	algorithms map[string]Algorithm
+}
+
+// NewMulti creates a Hasher that hashes with current and additionally
+// accepts hashes from the legacy algorithms.
+func NewMulti(current Algorithm, legacy ...Algorithm) *Multi {
+	m := &Multi{
+		current:    current,
+		algorithms: map[string]Algorithm{current.ID(): current},
+	}
+	for _, alg := range legacy {
+		if _, exists := m.algorithms[alg.ID()]; !exists {
+			m.algorithms[alg.ID()] = alg
+		}
+	}
+	return m
+}
+
+// Default returns the default Hasher: argon2id with the recommended
+// parameters, plus bcrypt for hashes created before argon2id was introduced.
+func Default() *Multi {
+	return NewMulti(NewArgon2id(DefaultArgon2Params), NewBcrypt(DefaultBcryptCost))
+}
+
+// Hash hashes the password with the current algorithm.
+func (m *Multi) Hash(password string) (string, error) {
+	defer observe(m.current.ID(), "hash", time.Now())
+	return m.current.Hash(password)
+}
+
+// Verify detects the algorithm of the encoded hash and verifies the password.
+func (m *Multi) Verify(encoded, password string) error {
+	alg, err := m.algorithmFor(encoded)
+	if err != nil {
+		return err
+	}
+	defer observe(alg.ID(), "verify", time.Now())
+	return alg.Verify(encoded, password)
+}
+
+func observe(algorithm, operation string, start time.Time) {
+	hashDuration.With(algorithm, operation).Observe(time.Since(start).Seconds())
+}
+
+// NeedsRehash reports whether the encoded hash uses a different algorithm
+// than the current one or outdated parameters.
+func (m *Multi) NeedsRehash(encoded string) bool {
+	if identify(encoded) != m.current.ID() {
+		return true
+	}
+	return m.current.NeedsRehash(encoded)
+}
+
+func (m *Multi) algorithmFor(encoded string) (Algorithm, error) {
+	alg, ok := m.algorithms[identify(encoded)]
+	if !ok {
+		return nil, ErrUnknownAlgorithm
+	}
+	return alg, nil
+}
+
+// identify returns the PHC identifier of an encoded hash.
+// bcrypt variants ($2a$, $2b$, $2y$) are all reported as "bcrypt".
+func identify(encoded string) string {
+	if !strings.HasPrefix(encoded, "$") {
+		return ""
+	}
+	id, _, _ := strings.Cut(encoded[1:], "$")
+	switch id {
+	case "2a", "2b", "2y":
+		return bcryptID
+	}
+	return id
+}
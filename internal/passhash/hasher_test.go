@@ -0,0 +1,162 @@
+package passhash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fastArgon2Params keeps the tests quick.
+var fastArgon2Params = Argon2Params{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestArgon2idHashAndVerify(t *testing.T) {
+	alg := NewArgon2id(fastArgon2Params)
+
+	hash, err := alg.Hash("MyP@ssw0rd!")
+	if err != nil {
+		t.Fatalf("Hash() failed: %v", err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("Unexpected PHC string: %s", hash)
+	}
+
+	if err := alg.Verify(hash, "MyP@ssw0rd!"); err != nil {
+		t.Errorf("Verify() with correct password failed: %v", err)
+	}
+
+	if err := alg.Verify(hash, "wrong"); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected ErrMismatch, got %v", err)
+	}
+
+	// Same password must produce different hashes (random salt)
+	other, _ := alg.Hash("MyP@ssw0rd!")
+	if other == hash {
+		t.Error("Two hashes of the same password should differ")
+	}
+}
+
+func TestArgon2idLongPassword(t *testing.T) {
+	alg := NewArgon2id(fastArgon2Params)
+
+	// Passwords longer than 72 bytes must not be truncated
+	long := strings.Repeat("a", 100)
+	hash, err := alg.Hash(long + "1")
+	if err != nil {
+		t.Fatalf("Hash() failed: %v", err)
+	}
+
+	if err := alg.Verify(hash, long+"2"); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Passwords differing after byte 72 must not match, got %v", err)
+	}
+}
+
+func TestArgon2idNeedsRehash(t *testing.T) {
+	alg := NewArgon2id(fastArgon2Params)
+	hash, _ := alg.Hash("secret")
+
+	if alg.NeedsRehash(hash) {
+		t.Error("Hash with current parameters should not need rehash")
+	}
+
+	stronger := fastArgon2Params
+	stronger.Iterations = 2
+	if !NewArgon2id(stronger).NeedsRehash(hash) {
+		t.Error("Hash with old parameters should need rehash")
+	}
+}
+
+func TestArgon2idRejectsOutOfBoundsParams(t *testing.T) {
+	alg := NewArgon2id(fastArgon2Params)
+	hash, _ := alg.Hash("secret")
+	parts := strings.Split(hash, "$")
+
+	tests := []struct {
+		name   string
+		params string
+		salt   string
+		key    string
+	}{
+		{"zero parallelism", "m=1024,t=1,p=0", parts[4], parts[5]},
+		{"zero iterations", "m=1024,t=0,p=1", parts[4], parts[5]},
+		{"memory below 8 KiB per lane", "m=8,t=1,p=4", parts[4], parts[5]},
+		{"huge memory", "m=4294967295,t=1,p=1", parts[4], parts[5]},
+		{"huge iterations", "m=1024,t=100000,p=1", parts[4], parts[5]},
+		{"empty key", "m=1024,t=1,p=1", parts[4], ""},
+		{"short salt", "m=1024,t=1,p=1", "AAAA", parts[5]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := strings.Join([]string{"", parts[1], parts[2], tt.params, tt.salt, tt.key}, "$")
+			if err := alg.Verify(tampered, "secret"); !errors.Is(err, ErrMalformedHash) {
+				t.Errorf("Verify(%s) = %v, want ErrMalformedHash", tampered, err)
+			}
+			if !alg.NeedsRehash(tampered) {
+				t.Errorf("NeedsRehash(%s) = false, want true", tampered)
+			}
+		})
+	}
+}
+
+func TestArgon2ParamsValidate(t *testing.T) {
+	if err := DefaultArgon2Params.Validate(); err != nil {
+		t.Errorf("DefaultArgon2Params.Validate() = %v", err)
+	}
+
+	tooMuch := DefaultArgon2Params
+	tooMuch.Memory = MaxArgon2Memory + 1
+	if err := tooMuch.Validate(); err == nil {
+		t.Error("Validate() with too much memory succeeded, want error")
+	}
+}
+
+func TestBcryptRejectsLongPassword(t *testing.T) {
+	alg := NewBcrypt(4)
+
+	if _, err := alg.Hash(strings.Repeat("a", 73)); err == nil {
+		t.Error("bcrypt should reject passwords longer than 72 bytes")
+	}
+}
+
+func TestMultiVerifiesLegacyBcrypt(t *testing.T) {
+	legacy := NewBcrypt(4)
+	bcryptHash, err := legacy.Hash("secret")
+	if err != nil {
+		t.Fatalf("bcrypt Hash() failed: %v", err)
+	}
+
+	h := NewMulti(NewArgon2id(fastArgon2Params), legacy)
+
+	if err := h.Verify(bcryptHash, "secret"); err != nil {
+		t.Errorf("Verify() of bcrypt hash failed: %v", err)
+	}
+	if err := h.Verify(bcryptHash, "wrong"); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected ErrMismatch, got %v", err)
+	}
+	if !h.NeedsRehash(bcryptHash) {
+		t.Error("bcrypt hash should need rehash when argon2id is current")
+	}
+
+	argonHash, _ := h.Hash("secret")
+	if h.NeedsRehash(argonHash) {
+		t.Error("Fresh argon2id hash should not need rehash")
+	}
+}
+
+func TestMultiUnknownAlgorithm(t *testing.T) {
+	h := NewMulti(NewArgon2id(fastArgon2Params))
+
+	if err := h.Verify("$md5$abc", "secret"); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("Expected ErrUnknownAlgorithm, got %v", err)
+	}
+	if err := h.Verify("plaintext", "plaintext"); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("Expected ErrUnknownAlgorithm for non-PHC value, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package passhash
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// NoPepper is the pepper version of hashes created without a pepper.
+const NoPepper = 0
+
+// ErrUnknownPepper is returned when a hash references a pepper version
+// that is not configured anymore.
+var ErrUnknownPepper = errors.New("unknown pepper version")
+
+// Pepper holds the versioned server-side secrets that are mixed into every
+// password (HMAC-SHA256) before it is hashed. Unlike the salt, the pepper is
+// never stored in the database, so a leaked database alone cannot be
+// attacked offline.
+//
+// Every hash records the pepper version it was created with. To rotate,
+// add a new version and keep the old ones until all users have logged in
+// once; their hashes are migrated on the next successful login.
+type Pepper struct {
+	keys    map[int][]byte
+	current int
+}
+
+// NewPepper creates a Pepper from version -> secret. The highest version
+// is used for new hashes. Versions must be greater than zero.
+func NewPepper(keys map[int][]byte) (*Pepper, error) {
+	p := &Pepper{keys: make(map[int][]byte, len(keys))}
+	for version, key := range keys {
+		if version <= NoPepper {
+			return nil, fmt.Errorf("pepper version must be > 0, got %d", version)
+		}
+		if len(key) == 0 {
+			return nil, fmt.Errorf("pepper version %d is empty", version)
+		}
+		p.keys[version] = key
+		if version > p.current {
+			p.current = version
+		}
+	}
+	return p, nil
+}
+
+// ParsePepper parses peppers from a string. Entries are separated by
+// newlines or commas and have the form "<version>:<secret>".
+// A single-line value in which no entry starts with a version is one
+// unversioned secret (version 1), even if it contains ":" or ",".
+func ParsePepper(s string) (*Pepper, error) {
+	entries := strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == ',' })
+	keys := make(map[int][]byte, len(entries))
+
+	var unversioned []string
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		versionStr, secret, found := strings.Cut(entry, ":")
+		version, err := strconv.Atoi(versionStr)
+		if !found || err != nil {
+			unversioned = append(unversioned, entry)
+			continue
+		}
+		if _, exists := keys[version]; exists {
+			return nil, fmt.Errorf("duplicate pepper version %d", version)
+		}
+		keys[version] = []byte(secret)
+	}
+
+	if len(unversioned) > 0 {
+		value := strings.TrimSpace(s)
+		if len(keys) > 0 || strings.Contains(value, "\n") {
+			return nil, fmt.Errorf("pepper entries must have the form <version>:<secret>, got %d without a version", len(unversioned))
+		}
+		return NewPepper(map[int][]byte{1: []byte(value)})
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no pepper configured")
+	}
+	return NewPepper(keys)
+}
+
+// LoadPepper loads the pepper from the file named in fileEnv, or else from
+// the environment variable env. It returns nil (no pepper) if neither is set.
+func LoadPepper(env, fileEnv string) (*Pepper, error) {
+	if path := os.Getenv(fileEnv); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read pepper file: %w", err)
+		}
+		return ParsePepper(string(data))
+	}
+	if value := os.Getenv(env); value != "" {
+		return ParsePepper(value)
+	}
+	return nil, nil
+}
+
+// Current returns the pepper version used for new hashes.
+// A nil Pepper returns NoPepper.
+func (p *Pepper) Current() int {
+	if p == nil {
+		return NoPepper
+	}
+	return p.current
+}
+
+// Apply mixes the pepper of the given version into the password.
+// Version NoPepper returns the password unchanged.
+func (p *Pepper) Apply(password string, version int) (string, error) {
+	if version == NoPepper {
+		return password, nil
+	}
+	if p == nil {
+		return "", fmt.Errorf("%w: %d", ErrUnknownPepper, version)
+	}
+	key, ok := p.keys[version]
+	if !ok {
+		return "", fmt.Errorf("%w: %d", ErrUnknownPepper, version)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(password))
+	// Base64 keeps the input printable and below bcrypt's 72 byte limit
+	return base64.RawStdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
@@ -0,0 +1,121 @@
+package passhash
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePepper(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantCurrent int
+		wantError   bool
+	}{
+		{"Single value", "s3cret", 1, false},
+		{"Versioned", "1:old,2:new", 2, false},
+		{"Newline separated", "3:c\n1:a\n", 3, false},
+		{"Empty", "", 0, true},
+		{"Unversioned with colon", "x:secret", 1, false},
+		{"Unversioned with colon and comma", "a:b,c:d", 1, false},
+		{"Multi-line without version", "x:secret\n2:new", 0, true},
+		{"Zero version", "0:secret", 0, true},
+		{"Duplicate version", "1:a,1:b", 0, true},
+		{"Empty secret", "1:", 0, true},
+		{"Mixed without version", "old,2:new", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParsePepper(tt.input)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("ParsePepper(%q) error = %v, wantError %v", tt.input, err, tt.wantError)
+			}
+			if err == nil && p.Current() != tt.wantCurrent {
+				t.Errorf("Current() = %d, want %d", p.Current(), tt.wantCurrent)
+			}
+		})
+	}
+}
+
+// Ein Pepper ohne Version bleibt vollständig erhalten, auch mit ":" oder ","
+func TestParsePepperUnversionedKeepsWholeSecret(t *testing.T) {
+	p, err := ParsePepper("  a:b,c:d\n")
+	if err != nil {
+		t.Fatalf("ParsePepper() failed: %v", err)
+	}
+	want, _ := NewPepper(map[int][]byte{1: []byte("a:b,c:d")})
+
+	got, _ := p.Apply("secret", 1)
+	expected, _ := want.Apply("secret", 1)
+	if got != expected {
+		t.Error("Unversioned pepper was split instead of used as one secret")
+	}
+}
+
+func TestPepperApply(t *testing.T) {
+	p, err := ParsePepper("1:old,2:new")
+	if err != nil {
+		t.Fatalf("ParsePepper() failed: %v", err)
+	}
+
+	v1, _ := p.Apply("secret", 1)
+	v2, _ := p.Apply("secret", 2)
+	if v1 == v2 || v1 == "secret" {
+		t.Error("Different pepper versions must produce different values")
+	}
+
+	again, _ := p.Apply("secret", 1)
+	if again != v1 {
+		t.Error("Apply() must be deterministic")
+	}
+
+	plain, err := p.Apply("secret", NoPepper)
+	if err != nil || plain != "secret" {
+		t.Errorf("NoPepper should return the password unchanged, got %q, %v", plain, err)
+	}
+
+	if _, err := p.Apply("secret", 3); !errors.Is(err, ErrUnknownPepper) {
+		t.Errorf("Expected ErrUnknownPepper, got %v", err)
+	}
+}
+
+func TestNilPepper(t *testing.T) {
+	var p *Pepper
+
+	if p.Current() != NoPepper {
+		t.Errorf("nil Pepper Current() = %d, want %d", p.Current(), NoPepper)
+	}
+	if _, err := p.Apply("secret", 1); !errors.Is(err, ErrUnknownPepper) {
+		t.Errorf("Expected ErrUnknownPepper, got %v", err)
+	}
+}
+
+func TestLoadPepper(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pepper")
+	if err := os.WriteFile(path, []byte("1:a\n2:b\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	t.Setenv("TEST_PEPPER", "")
+	t.Setenv("TEST_PEPPER_FILE", "")
+	p, err := LoadPepper("TEST_PEPPER", "TEST_PEPPER_FILE")
+	if err != nil || p != nil {
+		t.Errorf("Expected no pepper, got %v, %v", p, err)
+	}
+
+	t.Setenv("TEST_PEPPER", "envsecret")
+	p, err = LoadPepper("TEST_PEPPER", "TEST_PEPPER_FILE")
+	if err != nil || p.Current() != 1 {
+		t.Errorf("Expected pepper version 1 from env, got %v, %v", p, err)
+	}
+
+	// File takes precedence over the environment variable
+	t.Setenv("TEST_PEPPER_FILE", path)
+	p, err = LoadPepper("TEST_PEPPER", "TEST_PEPPER_FILE")
+	if err != nil || p.Current() != 2 {
+		t.Errorf("Expected pepper version 2 from file, got %v, %v", p, err)
+	}
+}
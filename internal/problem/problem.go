@@ -0,0 +1,261 @@
+// Package problem writes error responses as RFC 9457 problem details
+// (application/problem+json). Every problem carries a stable code that
+// clients can switch on; the detail text is for humans and may change.
+// Details are taken from the i18n catalog ("error.<code>") in the language
+// of the request.
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"foodshop/internal/i18n"
+	"foodshop/internal/logging"
+	"foodshop/internal/validator"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// ContentType is the media type of problem responses.
+const ContentType = "application/problem+json"
+
+// typePrefix turns a code into the problem type URI.
+const typePrefix = "urn:problem-type:foodshop:"
+
+// Code is a stable, machine-readable error code.
+type Code string
+
+// Error codes returned by the API. Codes are part of the API contract:
+// add new ones, but never rename or reuse them.
+const (
+	CodeInvalidRequest      Code = "invalid_request"
+	CodeValidationFailed    Code = "validation_failed"
+	CodeInvalidCredentials  Code = "invalid_credentials"
+	CodeMissingToken        Code = "missing_token"
+	CodeInvalidToken        Code = "invalid_token"
+	CodeTokenRevoked        Code = "token_revoked"
+	CodeInvalidRefreshToken Code = "invalid_refresh_token"
+	CodePasswordExpired     Code = "password_expired"
+	CodeClientCertRejected  Code = "client_certificate_rejected"
+	CodeUserNotFound        Code = "user_not_found"
+	CodeNotFound            Code = "not_found"
+	CodeMethodNotAllowed    Code = "method_not_allowed"
+	CodeRequestTimeout      Code = "request_timeout"
+	CodeUserExists          Code = "user_exists"
+	CodeRequestTooLarge     Code = "request_too_large"
+	CodeChallengeRequired   Code = "challenge_required"
+	CodeLoginThrottled      Code = "login_throttled"
+	CodeRateLimited         Code = "rate_limited"
+	CodeInternal            Code = "internal_error"
+)
+
+// definition holds the fixed status and title of a code.
+type definition struct {
+	status int
+	title  string
+}
+
+var definitions = map[Code]definition{
+	CodeInvalidRequest:      {http.StatusBadRequest, "Invalid request"},
+	CodeValidationFailed:    {http.StatusBadRequest, "Validation failed"},
+	CodeInvalidCredentials:  {http.StatusUnauthorized, "Invalid credentials"},
+	CodeMissingToken:        {http.StatusUnauthorized, "Missing token"},
+	CodeInvalidToken:        {http.StatusUnauthorized, "Invalid token"},
+	CodeTokenRevoked:        {http.StatusUnauthorized, "Token revoked"},
+	CodeInvalidRefreshToken: {http.StatusUnauthorized, "Invalid refresh token"},
+	CodePasswordExpired:     {http.StatusForbidden, "Password expired"},
+	CodeClientCertRejected:  {http.StatusForbidden, "Client certificate rejected"},
+	CodeUserNotFound:        {http.StatusNotFound, "User not found"},
+	CodeNotFound:            {http.StatusNotFound, "Not found"},
+	CodeMethodNotAllowed:    {http.StatusMethodNotAllowed, "Method not allowed"},
+	CodeRequestTimeout:      {http.StatusRequestTimeout, "Request timeout"},
+	CodeUserExists:          {http.StatusConflict, "User already exists"},
+	CodeRequestTooLarge:     {http.StatusRequestEntityTooLarge, "Request body too large"},
+	CodeChallengeRequired:   {http.StatusPreconditionRequired, "Challenge required"},
+	CodeLoginThrottled:      {http.StatusTooManyRequests, "Too many failed login attempts"},
+	CodeRateLimited:         {http.StatusTooManyRequests, "Rate limit exceeded"},
+	CodeInternal:            {http.StatusInternalServerError, "Internal server error"},
+}
+
+// Codes returns all defined codes, sorted.
+func Codes() []Code {
+	codes := make([]Code, 0, len(definitions))
+	for code := range definitions {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	return codes
+}
+
+// Status returns the HTTP status of code, 500 for unknown codes.
+func (c Code) Status() int {
+	if d, ok := definitions[c]; ok {
+		return d.status
+	}
+	return http.StatusInternalServerError
+}
+
+// Title returns the short summary of code.
+func (c Code) Title() string {
+	if d, ok := definitions[c]; ok {
+		return d.title
+	}
+	return http.StatusText(c.Status())
+}
+
+// Type returns the problem type URI of code.
+func (c Code) Type() string {
+	return typePrefix + string(c)
+}
+
+// FieldError describes one invalid input field.
+type FieldError struct {
+	Field  string `json:"field"`
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+
+	message i18n.Message
+}
+
+// Problem is an RFC 9457 problem details object. Extensions are written as
+// additional top-level members.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Code       Code
+	RequestID  string
+	Errors     []FieldError
+	Extensions map[string]interface{}
+
+	// message is translated into Detail by Write
+	message i18n.Message
+}
+
+// New creates a problem for code. Its detail is the catalog message
+// "error.<code>".
+func New(code Code) *Problem {
+	return &Problem{
+		Type:    code.Type(),
+		Title:   code.Title(),
+		Status:  code.Status(),
+		Code:    code,
+		message: i18n.Message{Key: "error." + string(code)},
+	}
+}
+
+// Variant selects the more specific detail "error.<code>.<variant>".
+func (p *Problem) Variant(variant string) *Problem {
+	p.message.Key = "error." + string(p.Code) + "." + variant
+	return p
+}
+
+// Args sets the placeholder values of the detail.
+func (p *Problem) Args(args i18n.Args) *Problem {
+	p.message.Args = args
+	return p
+}
+
+// With adds an extension member and returns p for chaining.
+func (p *Problem) With(key string, value interface{}) *Problem {
+	if p.Extensions == nil {
+		p.Extensions = make(map[string]interface{})
+	}
+	p.Extensions[key] = value
+	return p
+}
+
+// Field adds a field error and returns p for chaining. A validation
+// problem uses the message of its first field error as detail.
+func (p *Problem) Field(field, code string, message i18n.Message) *Problem {
+	p.Errors = append(p.Errors, FieldError{Field: field, Code: code, message: message})
+	return p
+}
+
+// MarshalJSON writes the standard members and the extensions as one
+// object. Extensions cannot override standard members.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(p.Extensions)+8)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	m["type"] = p.Type
+	m["title"] = p.Title
+	m["status"] = p.Status
+	m["code"] = p.Code
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		m["instance"] = p.Instance
+	}
+	if p.RequestID != "" {
+		m["request_id"] = p.RequestID
+	}
+	if len(p.Errors) > 0 {
+		m["errors"] = p.Errors
+	}
+	return json.Marshal(m)
+}
+
+// Write sends p with the request path as instance and the request ID of r.
+// Details are translated into the request language (see i18n.Language).
+func Write(w http.ResponseWriter, r *http.Request, p *Problem) {
+	lang := i18n.Language(r.Context())
+	for i := range p.Errors {
+		if p.Errors[i].Detail == "" {
+			p.Errors[i].Detail = p.Errors[i].message.Text(lang)
+		}
+	}
+	if p.Detail == "" {
+		if p.Code == CodeValidationFailed && len(p.Errors) > 0 {
+			p.Detail = p.Errors[0].Detail
+		} else {
+			p.Detail = p.message.Text(lang)
+		}
+	}
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if p.RequestID == "" {
+		p.RequestID = logging.RequestID(r.Context())
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("Content-Language", lang)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// Error writes a problem for code with its default detail.
+func Error(w http.ResponseWriter, r *http.Request, code Code) {
+	Write(w, r, New(code))
+}
+
+// Validation turns a validator error into a validation_failed problem with
+// the field error. Other errors become invalid_request.
+func Validation(err error) *Problem {
+	var ve validator.ValidationError
+	if !errors.As(err, &ve) {
+		return New(CodeInvalidRequest)
+	}
+	return New(CodeValidationFailed).Field(ve.Field, ve.Code, ve.Message())
+}
+
+// MethodNotAllowed writes 405 with the Allow header.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	allow := strings.Join(allowed, ", ")
+	w.Header().Set("Allow", allow)
+	Write(w, r, New(CodeMethodNotAllowed).Args(i18n.Args{"method": r.Method, "allow": allow}))
+}
+
+// BodyError maps an error from decoding the request body: bodies over the
+// MaxBytesReader limit get 413, everything else 400.
+func BodyError(err error) *Problem {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return New(CodeRequestTooLarge).Args(i18n.Args{"limit": tooLarge.Limit})
+	}
+	return New(CodeInvalidRequest)
+}
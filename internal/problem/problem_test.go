@@ -0,0 +1,145 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"foodshop/internal/i18n"
+	"foodshop/internal/logging"
+	"foodshop/internal/validator"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	req = req.WithContext(logging.WithRequestID(req.Context(), "req-7"))
+	rec := httptest.NewRecorder()
+
+	Write(rec, req, New(CodeRateLimited).
+		With("retry_after", 12).
+		With("status", 200)) // must not override the standard member
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Status = %d, want 429", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type = %q, want %q", ct, ContentType)
+	}
+	var body map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	for key, want := range map[string]interface{}{
+		"type":        "urn:problem-type:foodshop:rate_limited",
+		"title":       "Rate limit exceeded",
+		"status":      float64(429),
+		"code":        "rate_limited",
+		"detail":      "Rate limit exceeded. Please try again later.",
+		"instance":    "/login",
+		"request_id":  "req-7",
+		"retry_after": float64(12),
+	} {
+		if body[key] != want {
+			t.Errorf("%s = %v, want %v", key, body[key], want)
+		}
+	}
+	if _, ok := body["errors"]; ok {
+		t.Errorf("errors member without field errors: %v", body)
+	}
+}
+
+func TestWriteLocalized(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/registration", nil)
+	req = req.WithContext(i18n.WithLanguage(req.Context(), i18n.German))
+	rec := httptest.NewRecorder()
+
+	Write(rec, req, Validation(validator.ValidatePassword("Kurz1!")))
+
+	if got := rec.Header().Get("Content-Language"); got != "de" {
+		t.Errorf("Content-Language = %q, want de", got)
+	}
+	var body struct {
+		Detail string       `json:"detail"`
+		Errors []FieldError `json:"errors"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	want := "Das Passwort muss mindestens 8 Zeichen lang sein"
+	if body.Detail != want || len(body.Errors) != 1 || body.Errors[0].Detail != want {
+		t.Errorf("Got detail %q and errors %+v, want %q", body.Detail, body.Errors, want)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		code      Code
+		fieldCode string
+	}{
+		{"Validator error", validator.ValidatePassword("short", "", ""), CodeValidationFailed, "too_short"},
+		{"Wrapped validator error", fmt.Errorf("registration: %w", validator.ValidateUsername("")), CodeValidationFailed, "required"},
+		{"Other error", errors.New("boom"), CodeInvalidRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Validation(tt.err)
+			if p.Code != tt.code || p.Status != http.StatusBadRequest {
+				t.Fatalf("Validation() = %s/%d, want %s/400", p.Code, p.Status, tt.code)
+			}
+			if tt.fieldCode == "" {
+				if len(p.Errors) != 0 {
+					t.Errorf("Unexpected field errors: %+v", p.Errors)
+				}
+				return
+			}
+			if len(p.Errors) != 1 || p.Errors[0].Code != tt.fieldCode {
+				t.Errorf("Field errors = %+v, want one %q error", p.Errors, tt.fieldCode)
+			}
+		})
+	}
+}
+
+func TestBodyError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	body := http.MaxBytesReader(rec, io.NopCloser(strings.NewReader(`"`+strings.Repeat("x", 20)+`"`)), 10)
+	var v interface{}
+	err := json.NewDecoder(body).Decode(&v)
+	if p := BodyError(err); p.Status != http.StatusRequestEntityTooLarge || p.Code != CodeRequestTooLarge {
+		t.Errorf("BodyError(too large) = %s/%d, want request_too_large/413", p.Code, p.Status)
+	}
+
+	err = json.NewDecoder(strings.NewReader("{")).Decode(&v)
+	if p := BodyError(err); p.Status != http.StatusBadRequest || p.Code != CodeInvalidRequest {
+		t.Errorf("BodyError(syntax) = %s/%d, want invalid_request/400", p.Code, p.Status)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	rec := httptest.NewRecorder()
+	MethodNotAllowed(rec, httptest.NewRequest(http.MethodDelete, "/profile", nil), http.MethodGet, http.MethodPut)
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, PUT" {
+		t.Errorf("Got %d with Allow %q, want 405 with \"GET, PUT\"", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestCodesDefined(t *testing.T) {
+	for code, d := range definitions {
+		if d.status < 400 || d.title == "" {
+			t.Errorf("Code %s has invalid definition %+v", code, d)
+		}
+		for _, lang := range i18n.Languages() {
+			if key := "error." + string(code); i18n.Text(lang, key, nil) == key {
+				t.Errorf("Code %s has no %s message", code, lang)
+			}
+		}
+	}
+	if got := Code("unknown").Status(); got != http.StatusInternalServerError {
+		t.Errorf("Unknown code status = %d, want 500", got)
+	}
+}
@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"foodshop/internal/middleware"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisKeyPrefix is prepended to every key.
+	redisKeyPrefix = "ratelimit:"
+	// redisTimeout applies to dialing and every round trip unless the URL
+	// sets dial_timeout, read_timeout or write_timeout.
+	redisTimeout = 2 * time.Second
+)
+
+// ParseRedisURL parses "redis://[[user]:password@]host[:port][/db]" or, for
+// TLS, "rediss://...". Pool settings can be given as query parameters, e.g.
+// "?pool_size=20&dial_timeout=1s" (see redis.ParseURL). Failed commands
+// are not retried unless max_retries is set.
+func ParseRedisURL(raw string) (*redis.Options, error) {
+	opts, err := redis.ParseURL(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+	if opts.Network != "tcp" {
+		return nil, fmt.Errorf("invalid redis url: want redis:// or rediss://, got %q", raw)
+	}
+	if opts.DB < 0 {
+		return nil, fmt.Errorf("invalid redis url: bad database %d", opts.DB)
+	}
+	return opts, nil
+}
+
+// RedisStore keeps sliding window counters in Redis (or any server speaking
+// the Redis protocol, e.g. Valkey or KeyDB), so instances on different hosts
+// share the limits. It only needs INCR, DECR, PEXPIRE and GET.
+//
+// A request increments the counter first and is then evaluated; a rejected
+// request decrements it again. Concurrent instances therefore never admit
+// more than the limit. Requests share a pool of connections, so a slow
+// round trip does not hold up the others.
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a store; connections are opened on demand.
+func NewRedisStore(opts *redis.Options) *RedisStore {
+	copied := *opts
+	opts = &copied
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = redisTimeout
+	}
+	if opts.ReadTimeout == 0 {
+		opts.ReadTimeout = redisTimeout
+	}
+	if opts.WriteTimeout == 0 {
+		opts.WriteTimeout = redisTimeout
+	}
+	// INCR is not idempotent: a retry after a lost reply counts twice
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = -1
+	}
+	return &RedisStore{client: redis.NewClient(opts)}
+}
+
+// Take implements middleware.LimiterStore.
+func (s *RedisStore) Take(ctx context.Context, key string, policy middleware.RateLimitPolicy) (middleware.LimitResult, error) {
+	index, elapsed := window(time.Now(), policy.Period)
+	currentKey := windowKey(redisKeyPrefix+key, index)
+	previousKey := windowKey(redisKeyPrefix+key, index-1)
+
+	pipe := s.client.Pipeline()
+	incr := pipe.Incr(ctx, currentKey)
+	pipe.PExpire(ctx, currentKey, 2*policy.Period)
+	get := pipe.Get(ctx, previousKey)
+	// A missing previous window is redis.Nil, not an error
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return middleware.LimitResult{}, fmt.Errorf("redis: %w", err)
+	}
+
+	current := incr.Val()
+	var previous int64
+	if b, err := get.Bytes(); err == nil {
+		previous, err = strconv.ParseInt(string(b), 10, 64)
+		if err != nil {
+			return middleware.LimitResult{}, fmt.Errorf("redis: invalid counter %q", b)
+		}
+	}
+
+	result := slidingWindow(previous, current, elapsed, policy)
+	if !result.Allowed {
+		// Rejected requests are not counted
+		if err := s.client.Decr(ctx, currentKey).Err(); err != nil {
+			return middleware.LimitResult{}, fmt.Errorf("redis: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// Close closes all pooled connections.
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,303 @@
+package ratelimit
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis is a minimal in-process server for the commands RedisStore uses.
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	mu   sync.Mutex
+	data map[string]int64
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	return serveFakeRedis(t, l, password)
+}
+
+// newFakeRediss is newFakeRedis behind TLS; the returned pool trusts its
+// self-signed certificate for "localhost".
+func newFakeRediss(t *testing.T, password string) (*fakeRedis, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	return serveFakeRedis(t, l, password), roots
+}
+
+func serveFakeRedis(t *testing.T, l net.Listener, password string) *fakeRedis {
+	f := &fakeRedis{listener: l, password: password, data: make(map[string]int64)}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	authed := f.password == ""
+
+	for {
+		args, err := readCommand(rd)
+		if err != nil || len(args) == 0 {
+			return
+		}
+
+		cmd := strings.ToUpper(args[0])
+		if !authed && cmd != "AUTH" {
+			fmt.Fprint(conn, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+		fmt.Fprint(conn, f.exec(cmd, args[1:], &authed))
+	}
+}
+
+// readCommand reads one command, an array of bulk strings.
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	readLine := func(prefix byte) (int, error) {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+		line = strings.TrimSuffix(line, "\r\n")
+		if line == "" || line[0] != prefix {
+			return 0, fmt.Errorf("unexpected line %q", line)
+		}
+		return strconv.Atoi(line[1:])
+	}
+
+	n, err := readLine('*')
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		size, err := readLine('$')
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+func (f *fakeRedis) exec(cmd string, args []string, authed *bool) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch cmd {
+	case "AUTH":
+		if len(args) != 1 || args[0] != f.password {
+			return "-WRONGPASS invalid password\r\n"
+		}
+		*authed = true
+		return "+OK\r\n"
+	case "SELECT", "PEXPIRE":
+		return ":1\r\n"
+	case "INCR":
+		f.data[args[0]]++
+		return fmt.Sprintf(":%d\r\n", f.data[args[0]])
+	case "DECR":
+		f.data[args[0]]--
+		return fmt.Sprintf(":%d\r\n", f.data[args[0]])
+	case "GET":
+		v, ok := f.data[args[0]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		s := strconv.FormatInt(v, 10)
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", cmd)
+}
+
+func TestRedisStore(t *testing.T) {
+	fake := newFakeRedis(t, "secret")
+	opts := &redis.Options{Addr: fake.listener.Addr().String(), Password: "secret", DB: 2}
+
+	// Two stores behave like two instances on different hosts
+	first := NewRedisStore(opts)
+	defer first.Close()
+	second := NewRedisStore(opts)
+	defer second.Close()
+
+	testStore(t, first, second)
+}
+
+// TestRedisStoreConcurrent runs requests in parallel over the pool; every
+// request must be counted exactly once.
+func TestRedisStoreConcurrent(t *testing.T) {
+	fake := newFakeRedis(t, "")
+	store := NewRedisStore(&redis.Options{Addr: fake.listener.Addr().String(), PoolSize: 4})
+	defer store.Close()
+
+	const requests = 50
+	policy := testPolicy
+	policy.Requests = requests
+
+	var wg sync.WaitGroup
+	for range requests {
+		wg.Go(func() {
+			if _, err := store.Take(t.Context(), "parallel", policy); err != nil {
+				t.Errorf("Take() failed: %v", err)
+			}
+		})
+	}
+	wg.Wait()
+
+	result, err := store.Take(t.Context(), "parallel", policy)
+	if err != nil {
+		t.Fatalf("Take() failed: %v", err)
+	}
+	if result.Allowed {
+		t.Errorf("Request %d was allowed, want the limit of %d reached", requests+1, requests)
+	}
+}
+
+func TestRedisStoreTLS(t *testing.T) {
+	fake, roots := newFakeRediss(t, "secret")
+	_, port, _ := net.SplitHostPort(fake.listener.Addr().String())
+
+	opts, err := ParseRedisURL("rediss://:secret@localhost:" + port + "/1")
+	if err != nil {
+		t.Fatalf("ParseRedisURL() failed: %v", err)
+	}
+	if opts.TLSConfig == nil {
+		t.Fatal("rediss:// did not enable TLS")
+	}
+	opts.TLSConfig.RootCAs = roots
+	store := NewRedisStore(opts)
+	defer store.Close()
+
+	if result, err := store.Take(t.Context(), "k", testPolicy); err != nil || !result.Allowed {
+		t.Errorf("Take() over TLS = %+v, %v, want allowed", result, err)
+	}
+
+	// Without the certificate in the roots, the handshake must fail
+	opts, _ = ParseRedisURL("rediss://:secret@localhost:" + port)
+	untrusted := NewRedisStore(opts)
+	defer untrusted.Close()
+	if _, err := untrusted.Take(t.Context(), "k", testPolicy); err == nil {
+		t.Error("Take() with an untrusted certificate succeeded, want error")
+	}
+}
+
+func TestRedisStoreErrors(t *testing.T) {
+	fake := newFakeRedis(t, "secret")
+
+	store := NewRedisStore(&redis.Options{Addr: fake.listener.Addr().String(), Password: "wrong"})
+	defer store.Close()
+	if _, err := store.Take(t.Context(), "k", testPolicy); err == nil {
+		t.Error("Take() with wrong password succeeded, want error")
+	}
+
+	// Unreachable server
+	addr := fake.listener.Addr().String()
+	fake.listener.Close()
+	store = NewRedisStore(&redis.Options{Addr: addr})
+	defer store.Close()
+	if _, err := store.Take(t.Context(), "k", testPolicy); err == nil {
+		t.Error("Take() without server succeeded, want error")
+	}
+}
+
+func TestParseRedisURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		addr     string
+		user     string
+		password string
+		db       int
+		tls      bool
+		wantErr  bool
+	}{
+		{url: "redis://localhost", addr: "localhost:6379"},
+		{url: "redis://cache:6380/3", addr: "cache:6380", db: 3},
+		{url: "redis://:pw@cache:6379", addr: "cache:6379", password: "pw"},
+		{url: "redis://app:pw@cache", addr: "cache:6379", user: "app", password: "pw"},
+		{url: "rediss://:pw@cache:6380/1", addr: "cache:6380", password: "pw", db: 1, tls: true},
+		{url: "http://cache", wantErr: true},
+		{url: "unix:///run/redis.sock", wantErr: true},
+		{url: "redis://cache/-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			got, err := ParseRedisURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRedisURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Addr != tt.addr || got.Username != tt.user || got.Password != tt.password || got.DB != tt.db {
+				t.Errorf("ParseRedisURL() = %s %s:%s db %d, want %s %s:%s db %d",
+					got.Addr, got.Username, got.Password, got.DB, tt.addr, tt.user, tt.password, tt.db)
+			}
+			if (got.TLSConfig != nil) != tt.tls {
+				t.Errorf("ParseRedisURL() TLS = %v, want %v", got.TLSConfig != nil, tt.tls)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"foodshop/internal/middleware"
+	"log/slog"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteStore keeps sliding window counters in a SQLite file. All processes
+// on one host that open the same file share the limits; write transactions
+// are serialized by SQLite (BEGIN IMMEDIATE), so the counting is exact.
+type SQLiteStore struct {
+	db *sql.DB
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSQLiteStore opens (or creates) the counter database at path.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("rate limit database path must not be empty")
+	}
+
+	// Immediate transactions take the write lock up front, so two processes
+	// cannot both read the old count; WAL keeps readers and the writer apart.
+	dsn := fmt.Sprintf("file:%s?_txlock=immediate&_busy_timeout=5000&_journal_mode=WAL", path)
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open rate limit sqlite: %w", err)
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS rate_limit_windows (
+		key TEXT NOT NULL,
+		window INTEGER NOT NULL,
+		count INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL,
+		PRIMARY KEY (key, window)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_rate_limit_windows_expires ON rate_limit_windows(expires_at);
+	`
+	if _, err := db.Exec(schema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("create rate limit schema: %w", err)
+	}
+
+	s := &SQLiteStore{db: db, stop: make(chan struct{}), done: make(chan struct{})}
+
+	// Start cleanup goroutine
+	go s.cleanup(time.Minute * 5)
+
+	return s, nil
+}
+
+// Take implements middleware.LimiterStore.
+func (s *SQLiteStore) Take(ctx context.Context, key string, policy middleware.RateLimitPolicy) (middleware.LimitResult, error) {
+	index, elapsed := window(time.Now(), policy.Period)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return middleware.LimitResult{}, fmt.Errorf("begin rate limit transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT window, count FROM rate_limit_windows WHERE key = ? AND window IN (?, ?)`,
+		key, index-1, index)
+	if err != nil {
+		return middleware.LimitResult{}, fmt.Errorf("read rate limit counters: %w", err)
+	}
+	var previous, current int64
+	for rows.Next() {
+		var w, count int64
+		if err := rows.Scan(&w, &count); err != nil {
+			rows.Close()
+			return middleware.LimitResult{}, fmt.Errorf("scan rate limit counter: %w", err)
+		}
+		if w == index {
+			current = count
+		} else {
+			previous = count
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return middleware.LimitResult{}, fmt.Errorf("read rate limit counters: %w", err)
+	}
+
+	result := slidingWindow(previous, current+1, elapsed, policy)
+	if !result.Allowed {
+		// Rejected requests are not counted
+		return result, nil
+	}
+
+	// The counter is needed until the following window has passed
+	expiresAt := (index + 2) * policy.Period.Milliseconds()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO rate_limit_windows (key, window, count, expires_at) VALUES (?, ?, 1, ?)
+		ON CONFLICT(key, window) DO UPDATE SET count = count + 1`,
+		key, index, expiresAt)
+	if err != nil {
+		return middleware.LimitResult{}, fmt.Errorf("update rate limit counter: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return middleware.LimitResult{}, fmt.Errorf("commit rate limit counter: %w", err)
+	}
+	return result, nil
+}
+
+// Close stops the cleanup, writes the WAL back into the database file and
+// closes it. It is safe to call Close more than once.
+func (s *SQLiteStore) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+
+		if _, cerr := s.db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); cerr != nil {
+			slog.Warn("Failed to checkpoint rate limit database", "error", cerr)
+		}
+		err = s.db.Close()
+	})
+	return err
+}
+
+// Running reports whether the cleanup goroutine is still running.
+func (s *SQLiteStore) Running() bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// cleanup deletes expired counters.
+func (s *SQLiteStore) cleanup(interval time.Duration) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if _, err := s.db.Exec(`DELETE FROM rate_limit_windows WHERE expires_at < ?`, time.Now().UnixMilli()); err != nil {
+				slog.Warn("Failed to clean up rate limit counters", "error", err)
+			}
+		}
+	}
+}
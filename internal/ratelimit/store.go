@@ -0,0 +1,31 @@
+package ratelimit
+
+import (
+	"fmt"
+	"foodshop/internal/middleware"
+	"strings"
+)
+
+// OpenStore creates the store described by spec:
+//
+//	"" or "memory"        in-memory token bucket (per process)
+//	"sqlite:<path>"       SQLiteStore, shared by processes on one host
+//	"redis://host:port/0" RedisStore, shared across hosts
+//	"rediss://..."        RedisStore over TLS
+//
+// Stores that hold connections implement io.Closer.
+func OpenStore(spec string) (middleware.LimiterStore, error) {
+	switch {
+	case spec == "" || spec == "memory":
+		return middleware.NewMemoryStore(), nil
+	case strings.HasPrefix(spec, "sqlite:"):
+		return NewSQLiteStore(strings.TrimPrefix(spec, "sqlite:"))
+	case strings.HasPrefix(spec, "redis://"), strings.HasPrefix(spec, "rediss://"):
+		opts, err := ParseRedisURL(spec)
+		if err != nil {
+			return nil, err
+		}
+		return NewRedisStore(opts), nil
+	}
+	return nil, fmt.Errorf("unknown rate limit store %q (want memory, sqlite:<path>, redis://... or rediss://...)", spec)
+}
@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"context"
+	"foodshop/internal/middleware"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testPolicy = middleware.RateLimitPolicy{Name: "test", Requests: 3, Period: time.Minute}
+
+// testStore checks the LimiterStore contract; first and second may be two
+// instances sharing the same state.
+func testStore(t *testing.T, first, second middleware.LimiterStore) {
+	t.Helper()
+	ctx := context.Background()
+
+	stores := []middleware.LimiterStore{first, second, first}
+	for i, store := range stores {
+		result, err := store.Take(ctx, "test|ip:203.0.113.5", testPolicy)
+		if err != nil {
+			t.Fatalf("Take() failed: %v", err)
+		}
+		if !result.Allowed {
+			t.Fatalf("request %d: Allowed = false, want true", i+1)
+		}
+		if result.Limit != 3 {
+			t.Errorf("Limit = %d, want 3", result.Limit)
+		}
+		if result.Remaining > 2-i {
+			t.Errorf("request %d: Remaining = %d, want at most %d", i+1, result.Remaining, 2-i)
+		}
+	}
+
+	// Limit reached on both instances; rejected requests are not counted
+	for _, store := range []middleware.LimiterStore{second, first} {
+		result, err := store.Take(ctx, "test|ip:203.0.113.5", testPolicy)
+		if err != nil {
+			t.Fatalf("Take() failed: %v", err)
+		}
+		if result.Allowed {
+			t.Fatal("Allowed = true after limit, want false")
+		}
+		if result.RetryAfter <= 0 || result.RetryAfter > 2*testPolicy.Period {
+			t.Errorf("RetryAfter = %v, want within (0, %v]", result.RetryAfter, 2*testPolicy.Period)
+		}
+	}
+
+	// Other keys are independent
+	result, err := second.Take(ctx, "test|ip:203.0.113.6", testPolicy)
+	if err != nil {
+		t.Fatalf("Take() failed: %v", err)
+	}
+	if !result.Allowed {
+		t.Error("other key: Allowed = false, want true")
+	}
+}
+
+func TestSQLiteStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ratelimit.db")
+
+	// Two stores on one file behave like two processes on one host
+	first, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore() failed: %v", err)
+	}
+	defer first.Close()
+	second, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore() failed: %v", err)
+	}
+	defer second.Close()
+
+	testStore(t, first, second)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	policy := middleware.RateLimitPolicy{Requests: 10, Period: time.Minute}
+
+	tests := []struct {
+		name          string
+		previous      int64
+		current       int64
+		elapsed       time.Duration
+		wantAllowed   bool
+		wantRemaining int
+		wantRetry     time.Duration
+	}{
+		{"First request", 0, 1, 0, true, 9, 0},
+		{"Previous window fully weighted", 10, 1, 0, false, 0, 6 * time.Second},
+		{"Previous window half slid out", 10, 5, 30 * time.Second, true, 0, 0},
+		{"Previous window half slid out, over", 10, 6, 30 * time.Second, false, 0, 6 * time.Second},
+		{"Current window full", 0, 11, 15 * time.Second, false, 0, 51 * time.Second},
+		{"Current window full, previous too", 4, 11, 15 * time.Second, false, 0, 51 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := slidingWindow(tt.previous, tt.current, tt.elapsed, policy)
+			if got.Allowed != tt.wantAllowed {
+				t.Errorf("Allowed = %v, want %v", got.Allowed, tt.wantAllowed)
+			}
+			if got.Remaining != tt.wantRemaining {
+				t.Errorf("Remaining = %d, want %d", got.Remaining, tt.wantRemaining)
+			}
+			if diff := got.RetryAfter - tt.wantRetry; diff < -time.Millisecond || diff > time.Millisecond {
+				t.Errorf("RetryAfter = %v, want %v", got.RetryAfter, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{"", false},
+		{"memory", false},
+		{"sqlite:" + filepath.Join(t.TempDir(), "rl.db"), false},
+		{"redis://localhost:6379/0", false},
+		{"rediss://:secret@localhost:6380/0", false},
+		{"redis://localhost/x", true},
+		{"memcached://localhost", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			store, err := OpenStore(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("OpenStore(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if closer, ok := store.(interface{ Close() error }); ok {
+				closer.Close()
+			}
+		})
+	}
+}
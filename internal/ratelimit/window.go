@@ -0,0 +1,82 @@
+// Package ratelimit provides shared LimiterStore implementations, so that
+// several instances of the server enforce one common rate limit.
+//
+// Both stores use the sliding window counter algorithm: requests are counted
+// in fixed windows of one period, and the previous window is weighted by the
+// part of it that still overlaps the sliding window:
+//
+//	count = previous * (1 - elapsed/period) + current
+//
+// This needs only two counters per key and is exact enough for rate limiting.
+// Burst is ignored; a policy allows Requests per Period.
+package ratelimit
+
+import (
+	"foodshop/internal/middleware"
+	"math"
+	"strconv"
+	"time"
+)
+
+// window returns the index of the fixed window containing now and the time
+// elapsed since the window started.
+func window(now time.Time, period time.Duration) (int64, time.Duration) {
+	ms := now.UnixMilli()
+	size := max(period.Milliseconds(), 1)
+	return ms / size, time.Duration(ms%size) * time.Millisecond
+}
+
+// windowKey is the storage key of a counter.
+func windowKey(key string, index int64) string {
+	return key + ":" + strconv.FormatInt(index, 10)
+}
+
+// slidingWindow evaluates the counters of a key. current already includes
+// the request being evaluated.
+func slidingWindow(previous, current int64, elapsed time.Duration, policy middleware.RateLimitPolicy) middleware.LimitResult {
+	period := policy.Period.Seconds()
+	limit := float64(policy.Requests)
+	weight := 1 - elapsed.Seconds()/period
+	count := float64(previous)*weight + float64(current)
+
+	result := middleware.LimitResult{
+		Allowed:   count <= limit,
+		Limit:     policy.Requests,
+		Remaining: int(math.Floor(limit - count)),
+	}
+
+	// The quota is full again once both windows have slid out
+	switch {
+	case current > 0:
+		result.Reset = 2*policy.Period - elapsed
+	case previous > 0:
+		result.Reset = policy.Period - elapsed
+	}
+
+	if !result.Allowed {
+		result.Remaining = 0
+		result.RetryAfter = retryAfter(float64(previous), float64(current-1), limit, elapsed.Seconds(), period)
+	}
+	return result
+}
+
+// retryAfter computes when one more request fits, given the counters without
+// the rejected request.
+func retryAfter(previous, current, limit, elapsed, period float64) time.Duration {
+	// Still in this window: wait until enough of the previous window has slid out
+	if free := limit - current - 1; free >= 0 && previous > 0 {
+		at := period * (1 - free/previous)
+		return seconds(max(at-elapsed, 0))
+	}
+
+	// Next window: the current counter becomes the previous one
+	wait := period - elapsed
+	if current > 0 {
+		wait += max(period*(1-(limit-1)/current), 0)
+	}
+	return seconds(wait)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
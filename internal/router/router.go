@@ -0,0 +1,176 @@
+// Package router adds route groups to http.ServeMux. Routes use Go 1.22
+// method patterns ("GET /profile"); a group prefixes its paths and wraps
+// its handlers in middleware (authentication, rate limit policies, ...).
+// Unknown paths get a 404 and known paths with the wrong method a 405 with
+// Allow header, both as problem+json.
+package router
+
+import (
+	"fmt"
+	"foodshop/internal/i18n"
+	"foodshop/internal/problem"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Middleware wraps a handler.
+type Middleware func(http.Handler) http.Handler
+
+// Route describes a registered route.
+type Route struct {
+	Method string
+	// Path is the full path pattern, e.g. "/api/v1/profile".
+	Path string
+	// Successor is the route an alias stands in for ("" for regular routes).
+	Successor string
+}
+
+// Pattern returns the ServeMux pattern of the route.
+func (r Route) Pattern() string {
+	return r.Method + " " + r.Path
+}
+
+// Deprecated reports whether the route is a deprecated alias.
+func (r Route) Deprecated() bool {
+	return r.Successor != ""
+}
+
+// registry is shared by a router and its groups.
+type registry struct {
+	routes   []Route
+	handlers map[string]http.Handler // wrapped handler by pattern, for Alias
+	methods  map[string]bool
+}
+
+// Router registers routes on a ServeMux. Groups share the mux of the
+// router they were created from.
+type Router struct {
+	mux        *http.ServeMux
+	prefix     string
+	middleware []Middleware
+	reg        *registry
+}
+
+// New returns an empty router.
+func New() *Router {
+	return &Router{
+		mux: http.NewServeMux(),
+		reg: &registry{handlers: make(map[string]http.Handler), methods: make(map[string]bool)},
+	}
+}
+
+// Group returns a router for routes below prefix. Its middleware runs
+// inside the middleware of r, in the given order (the first one is
+// outermost).
+func (r *Router) Group(prefix string, middleware ...Middleware) *Router {
+	return &Router{
+		mux:        r.mux,
+		prefix:     r.prefix + prefix,
+		middleware: append(slices.Clip(r.middleware), middleware...),
+		reg:        r.reg,
+	}
+}
+
+// Handle registers h for pattern ("METHOD /path") below the group prefix.
+// Like ServeMux it panics on invalid or conflicting patterns.
+func (r *Router) Handle(pattern string, h http.Handler) {
+	method, path := splitPattern(pattern)
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		h = r.middleware[i](h)
+	}
+	r.register(Route{Method: method, Path: r.prefix + path}, h)
+}
+
+// HandleFunc registers f for pattern.
+func (r *Router) HandleFunc(pattern string, f http.HandlerFunc) {
+	r.Handle(pattern, f)
+}
+
+// Alias registers pattern as a deprecated alias of the route target (a
+// full pattern like "GET /api/v1/profile"), including the middleware of
+// target. Responses carry the Deprecation header (RFC 9745) with since and
+// a Link to the successor.
+func (r *Router) Alias(pattern, target string, since time.Time) {
+	h, ok := r.reg.handlers[target]
+	if !ok {
+		panic(fmt.Sprintf("router: alias %q for unknown route %q", pattern, target))
+	}
+	method, path := splitPattern(pattern)
+	_, successor, _ := strings.Cut(target, " ")
+	deprecation := "@" + strconv.FormatInt(since.Unix(), 10)
+	link := "<" + successor + `>; rel="successor-version"`
+	r.register(Route{Method: method, Path: r.prefix + path, Successor: target},
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Deprecation", deprecation)
+			w.Header().Add("Link", link)
+			h.ServeHTTP(w, req)
+		}))
+}
+
+func splitPattern(pattern string) (method, path string) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok || method == "" || !strings.HasPrefix(path, "/") {
+		panic(fmt.Sprintf("router: pattern %q must be \"METHOD /path\"", pattern))
+	}
+	return method, path
+}
+
+func (r *Router) register(route Route, h http.Handler) {
+	r.mux.Handle(route.Pattern(), h)
+	r.reg.routes = append(r.reg.routes, route)
+	r.reg.handlers[route.Pattern()] = h
+	r.reg.methods[route.Method] = true
+	if route.Method == http.MethodGet {
+		// ServeMux serves HEAD with GET routes
+		r.reg.methods[http.MethodHead] = true
+	}
+}
+
+// Routes returns all registered routes sorted by path and method.
+func (r *Router) Routes() []Route {
+	routes := slices.Clone(r.reg.routes)
+	slices.SortFunc(routes, func(a, b Route) int {
+		if c := strings.Compare(a.Path, b.Path); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Method, b.Method)
+	})
+	return routes
+}
+
+// Handler returns the handler and pattern for req like ServeMux.Handler.
+// The pattern is empty if no route matches.
+func (r *Router) Handler(req *http.Request) (http.Handler, string) {
+	return r.mux.Handler(req)
+}
+
+// ServeHTTP dispatches req to the matching route.
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if _, pattern := r.mux.Handler(req); pattern != "" {
+		// The mux stores the pattern in req, so pass it on unchanged
+		r.mux.ServeHTTP(w, req)
+		return
+	}
+	if allowed := r.allowed(req); len(allowed) > 0 {
+		problem.MethodNotAllowed(w, req, allowed...)
+		return
+	}
+	problem.Write(w, req, problem.New(problem.CodeNotFound).Args(i18n.Args{"path": req.URL.Path}))
+}
+
+// allowed returns the methods that have a route for the path of req.
+func (r *Router) allowed(req *http.Request) []string {
+	var allowed []string
+	for method := range r.reg.methods {
+		probe := req.Clone(req.Context())
+		probe.Method = method
+		if _, pattern := r.mux.Handler(probe); pattern != "" {
+			allowed = append(allowed, method)
+		}
+	}
+	slices.Sort(allowed)
+	return allowed
+}
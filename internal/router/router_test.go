@@ -0,0 +1,135 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"foodshop/internal/problem"
+)
+
+// tag returns a middleware that appends name to the X-Trace header.
+func tag(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Trace", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ok(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Pattern", r.Pattern)
+}
+
+func newTestRouter() *Router {
+	r := New()
+	r.HandleFunc("GET /{$}", ok)
+	api := r.Group("/api/v1", tag("api"))
+	api.HandleFunc("POST /login", ok)
+	users := api.Group("/users", tag("auth"), tag("limit"))
+	users.HandleFunc("GET /{id}", ok)
+	users.HandleFunc("DELETE /{id}", ok)
+	r.Alias("POST /login", "POST /api/v1/login", time.Unix(1760745600, 0))
+	return r
+}
+
+func TestRouter(t *testing.T) {
+	r := newTestRouter()
+
+	tests := []struct {
+		name, method, target string
+		status               int
+		pattern, trace       string
+		allow                string
+	}{
+		{"Root", "GET", "/", http.StatusOK, "GET /{$}", "", ""},
+		{"Group prefix and middleware", "POST", "/api/v1/login", http.StatusOK, "POST /api/v1/login", "api", ""},
+		{"Nested group order", "GET", "/api/v1/users/7", http.StatusOK, "GET /api/v1/users/{id}", "api,auth,limit", ""},
+		{"HEAD with GET", "HEAD", "/api/v1/users/7", http.StatusOK, "GET /api/v1/users/{id}", "api,auth,limit", ""},
+		{"Alias with target middleware", "POST", "/login", http.StatusOK, "POST /login", "api", ""},
+		{"Wrong method", "PUT", "/api/v1/users/7", http.StatusMethodNotAllowed, "", "", "DELETE, GET, HEAD"},
+		{"Unknown path", "GET", "/login/x", http.StatusNotFound, "", "", ""},
+		{"Outside prefix", "POST", "/users/7", http.StatusNotFound, "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+
+			if w.Code != tt.status {
+				t.Fatalf("%s %s: got %d, want %d", tt.method, tt.target, w.Code, tt.status)
+			}
+			if got := w.Header().Get("X-Pattern"); got != tt.pattern {
+				t.Errorf("Pattern = %q, want %q", got, tt.pattern)
+			}
+			if got := strings.Join(w.Header().Values("X-Trace"), ","); got != tt.trace {
+				t.Errorf("Middleware = %q, want %q", got, tt.trace)
+			}
+			if got := w.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+			if tt.status >= 400 && w.Header().Get("Content-Type") != problem.ContentType {
+				t.Errorf("Content-Type = %q, want %q", w.Header().Get("Content-Type"), problem.ContentType)
+			}
+		})
+	}
+}
+
+func TestAlias(t *testing.T) {
+	r := newTestRouter()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/login", nil))
+	if got := w.Header().Get("Deprecation"); got != "@1760745600" {
+		t.Errorf("Deprecation = %q, want @1760745600", got)
+	}
+	if got := w.Header().Get("Link"); got != `</api/v1/login>; rel="successor-version"` {
+		t.Errorf("Link = %q, want successor /api/v1/login", got)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/login", nil))
+	if got := w.Header().Get("Deprecation"); got != "" {
+		t.Errorf("Deprecation on the successor route: %q", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Alias() for an unknown route did not panic")
+		}
+	}()
+	r.Alias("GET /old", "GET /missing", time.Now())
+}
+
+func TestRoutes(t *testing.T) {
+	var got []string
+	for _, route := range newTestRouter().Routes() {
+		s := route.Pattern()
+		if route.Deprecated() {
+			s += " -> " + route.Successor
+		}
+		got = append(got, s)
+	}
+	want := []string{
+		"POST /api/v1/login",
+		"DELETE /api/v1/users/{id}",
+		"GET /api/v1/users/{id}",
+		"POST /login -> POST /api/v1/login",
+		"GET /{$}",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Routes() = %q, want %q", got, want)
+	}
+}
+
+func TestHandleInvalidPattern(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Handle() without method did not panic")
+		}
+	}()
+	New().HandleFunc("/profile", ok)
+}
@@ -0,0 +1,147 @@
+// Package systemd implements the parts of the systemd service protocol the
+// server uses, without linking libsystemd:
+//
+//   - socket activation (sd_listen_fds): LISTEN_PID, LISTEN_FDS, LISTEN_FDNAMES
+//   - readiness and status notifications (sd_notify): NOTIFY_SOCKET
+//   - the service watchdog (sd_watchdog_enabled): WATCHDOG_USEC, WATCHDOG_PID
+//
+// Outside of systemd all functions are no-ops.
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// listenFDsStart is the first passed file descriptor (SD_LISTEN_FDS_START).
+const listenFDsStart = 3
+
+// Notification states, see sd_notify(3).
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Listeners returns the sockets passed by systemd socket activation, in the
+// order of the ListenStream= lines of the .socket unit. It returns nil if the
+// process was not socket activated. The environment variables are unset, so
+// child processes don't inherit them.
+func Listeners() ([]net.Listener, error) {
+	return listeners(listenFDsStart)
+}
+
+func listeners(start int) ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		// Not for us (e.g. inherited from a parent that was activated)
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	lns := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		fd := start + i
+		syscall.CloseOnExec(fd)
+
+		name := fmt.Sprintf("LISTEN_FD_%d", fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(f)
+		// FileListener dups the descriptor; the original is no longer needed
+		f.Close()
+		if err != nil {
+			for _, l := range lns {
+				l.Close()
+			}
+			return nil, fmt.Errorf("systemd: socket %s is not a listener: %w", name, err)
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// Notify sends a state notification (e.g. Ready) to the service manager.
+// It returns false without error if NOTIFY_SOCKET is not set.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// Abstract namespace sockets start with "@"
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("systemd: notify: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("systemd: notify: %w", err)
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the watchdog timeout (WatchdogSec= of the unit)
+// or 0 if the watchdog is disabled for this process.
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("systemd: invalid WATCHDOG_USEC %q", usec)
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
+
+// RunWatchdog pings the watchdog every timeout/2 as long as check succeeds,
+// until ctx is cancelled. A failing check skips the ping, so a hung or broken
+// server is restarted by systemd instead of being kept alive by a goroutine.
+func RunWatchdog(ctx context.Context, timeout time.Duration, check func(context.Context) error) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, timeout/4)
+		err := check(checkCtx)
+		cancel()
+		if err != nil {
+			slog.Warn("Health check failed, skipping watchdog ping", "error", err)
+			continue
+		}
+		if _, err := Notify(Watchdog); err != nil {
+			slog.Warn("Watchdog ping failed", "error", err)
+		}
+	}
+}
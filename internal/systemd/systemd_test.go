@@ -0,0 +1,238 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeNotifySocket listens like systemd on NOTIFY_SOCKET and returns the
+// received notifications.
+func fakeNotifySocket(t *testing.T, addr string) <-chan string {
+	t.Helper()
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen on notify socket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	messages := make(chan string, 16)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			messages <- string(buf[:n])
+		}
+	}()
+	return messages
+}
+
+func receive(t *testing.T, messages <-chan string) string {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("No notification received")
+		return ""
+	}
+}
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify(Ready); sent || err != nil {
+		t.Errorf("Notify() without NOTIFY_SOCKET = %v, %v, want false, nil", sent, err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	messages := fakeNotifySocket(t, socket)
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	for _, state := range []string{Ready, "STATUS=Serving", Stopping} {
+		sent, err := Notify(state)
+		if err != nil || !sent {
+			t.Fatalf("Notify(%q) failed: %v", state, err)
+		}
+		if got := receive(t, messages); got != state {
+			t.Errorf("Received %q, want %q", got, state)
+		}
+	}
+
+	// Abstract namespace socket ("@" prefix)
+	name := "foodshop-test-" + strconv.Itoa(os.Getpid())
+	messages = fakeNotifySocket(t, "\x00"+name)
+	t.Setenv("NOTIFY_SOCKET", "@"+name)
+	if _, err := Notify(Ready); err != nil {
+		t.Fatalf("Notify() on abstract socket failed: %v", err)
+	}
+	if got := receive(t, messages); got != Ready {
+		t.Errorf("Received %q, want %q", got, Ready)
+	}
+
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+	if _, err := Notify(Ready); err == nil {
+		t.Error("Notify() to missing socket succeeded, want error")
+	}
+}
+
+func TestListeners(t *testing.T) {
+	// Not socket activated
+	t.Setenv("LISTEN_PID", "")
+	t.Setenv("LISTEN_FDS", "")
+	if lns, err := Listeners(); err != nil || lns != nil {
+		t.Errorf("Listeners() without LISTEN_FDS = %v, %v, want nil, nil", lns, err)
+	}
+
+	// Pass a real socket the way systemd does: consecutive fds from start
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer tcp.Close()
+	f, err := tcp.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("Failed to get fd: %v", err)
+	}
+	defer f.Close()
+
+	tests := []struct {
+		name  string
+		pid   int
+		count int
+	}{
+		{"other process", os.Getpid() + 1, 0},
+		{"activated", os.Getpid(), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LISTEN_PID", strconv.Itoa(tt.pid))
+			t.Setenv("LISTEN_FDS", "1")
+			t.Setenv("LISTEN_FDNAMES", "http")
+
+			// listeners() takes ownership of the descriptor
+			fd, err := syscall.Dup(int(f.Fd()))
+			if err != nil {
+				t.Fatalf("Failed to dup fd: %v", err)
+			}
+			if tt.count == 0 {
+				defer syscall.Close(fd)
+			}
+
+			lns, err := listeners(fd)
+			if err != nil {
+				t.Fatalf("listeners() failed: %v", err)
+			}
+			if len(lns) != tt.count {
+				t.Fatalf("Got %d listeners, want %d", len(lns), tt.count)
+			}
+			for _, env := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+				if _, ok := os.LookupEnv(env); ok {
+					t.Errorf("%s still set, want it unset", env)
+				}
+			}
+			if tt.count == 0 {
+				return
+			}
+			defer lns[0].Close()
+			if lns[0].Addr().String() != tcp.Addr().String() {
+				t.Errorf("Listener address = %s, want %s", lns[0].Addr(), tcp.Addr())
+			}
+		})
+	}
+
+	// A descriptor that is not a socket
+	tmp, err := os.CreateTemp(t.TempDir(), "notasocket")
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer tmp.Close()
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	fd, err := syscall.Dup(int(tmp.Fd()))
+	if err != nil {
+		t.Fatalf("Failed to dup fd: %v", err)
+	}
+	if _, err := listeners(fd); err == nil {
+		t.Error("listeners() with a regular file succeeded, want error")
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+		wantErr   bool
+	}{
+		{"", "", 0, false},
+		{"30000000", "", 30 * time.Second, false},
+		{"30000000", pid, 30 * time.Second, false},
+		{"30000000", "1", 0, false},
+		{"abc", "", 0, true},
+		{"-5", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+		got, err := WatchdogInterval()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("WatchdogInterval(%q, %q) = %v, %v, want %v (error: %v)", tt.usec, tt.pid, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRunWatchdog(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	messages := fakeNotifySocket(t, socket)
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	var healthy atomic.Bool
+	var checks atomic.Int32
+	check := func(ctx context.Context) error {
+		checks.Add(1)
+		if !healthy.Load() {
+			return errors.New("database unavailable")
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunWatchdog(ctx, 40*time.Millisecond, check)
+		close(done)
+	}()
+
+	// Unhealthy: checks run, but no ping is sent
+	deadline := time.Now().Add(5 * time.Second)
+	for checks.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	select {
+	case msg := <-messages:
+		t.Fatalf("Received %q while unhealthy, want no ping", msg)
+	default:
+	}
+
+	healthy.Store(true)
+	if got := receive(t, messages); got != Watchdog {
+		t.Errorf("Received %q, want %q", got, Watchdog)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunWatchdog() did not return after cancel")
+	}
+}
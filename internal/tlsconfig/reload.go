@@ -0,0 +1,89 @@
+package tlsconfig
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// reloader holds a value loaded from files and loads it again when the
+// content of one of the files changed. Comparing content instead of
+// modification times also catches atomic renames that keep the old mtime
+// and Kubernetes secret symlink flips, whose mtime can go backwards. The
+// check runs on access (at most once per interval), so there is no
+// background goroutine to stop.
+// A failed reload keeps the previous value: a half-written certificate must
+// not take the server down.
+type reloader[T any] struct {
+	files    []string
+	interval time.Duration
+	load     func() (T, error)
+
+	mu          sync.Mutex
+	value       T
+	fingerprint [sha256.Size]byte
+	lastCheck   time.Time
+}
+
+func newReloader[T any](files []string, interval time.Duration, load func() (T, error)) (*reloader[T], error) {
+	r := &reloader[T]{files: files, interval: interval, load: load}
+	fingerprint, err := r.contentHash()
+	if err != nil {
+		return nil, err
+	}
+	if r.value, err = load(); err != nil {
+		return nil, err
+	}
+	r.fingerprint = fingerprint
+	r.lastCheck = time.Now()
+	return r, nil
+}
+
+// get returns the current value, reloading it if the files changed.
+func (r *reloader[T]) get() T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) < r.interval {
+		return r.value
+	}
+	r.lastCheck = time.Now()
+
+	fingerprint, err := r.contentHash()
+	if err != nil {
+		slog.Warn("TLS reload check failed", "error", err)
+		return r.value
+	}
+	if fingerprint == r.fingerprint {
+		return r.value
+	}
+
+	value, err := r.load()
+	if err != nil {
+		slog.Error("TLS reload failed, keeping previous version", "files", r.files, "error", err)
+		return r.value
+	}
+	r.value = value
+	r.fingerprint = fingerprint
+	slog.Info("TLS files reloaded", "files", r.files)
+	return r.value
+}
+
+// contentHash returns a SHA-256 over the content of all files. Symlinks
+// are followed, so the hash changes when the link target is replaced.
+func (r *reloader[T]) contentHash() ([sha256.Size]byte, error) {
+	h := sha256.New()
+	for _, file := range r.files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return [sha256.Size]byte{}, err
+		}
+		// Length prefix, so moving bytes between files changes the hash
+		h.Write(binary.BigEndian.AppendUint64(nil, uint64(len(data))))
+		h.Write(data)
+	}
+	return [sha256.Size]byte(h.Sum(nil)), nil
+}
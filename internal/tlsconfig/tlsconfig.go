@@ -0,0 +1,132 @@
+// Package tlsconfig builds the server TLS configuration: modern protocol
+// and cipher defaults, certificates that are reloaded when the files change
+// (e.g. after a Let's Encrypt renewal) and optional client certificate
+// authentication (mTLS).
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultReloadInterval is how often the certificate files are checked for changes.
+const DefaultReloadInterval = 30 * time.Second
+
+// Options configures NewServerConfig.
+type Options struct {
+	// CertFile and KeyFile are PEM files; CertFile may contain the chain.
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables client certificate verification with these CAs.
+	ClientCAFile string
+	// ClientAuth is the client certificate policy (see ParseClientAuth). It
+	// must not be tls.NoClientCert if ClientCAFile is set.
+	ClientAuth tls.ClientAuthType
+	// ReloadInterval limits how often the files are checked (default DefaultReloadInterval).
+	ReloadInterval time.Duration
+}
+
+// ParseClientAuth parses "none", "request" (verify if presented) or
+// "require". Empty means "require" if a client CA file is configured
+// (clientCA) and "none" otherwise; an explicit "none" with a client CA file
+// is an error, the CAs would be ignored.
+func ParseClientAuth(s string, clientCA bool) (tls.ClientAuthType, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		if clientCA {
+			return tls.RequireAndVerifyClientCert, nil
+		}
+		return tls.NoClientCert, nil
+	case "none":
+		if clientCA {
+			return tls.NoClientCert, errors.New("client auth none contradicts the client CA file")
+		}
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("unknown client auth %q (want none, request or require)", s)
+}
+
+// NewServerConfig creates a TLS configuration following the Mozilla
+// "intermediate" profile: TLS 1.2 and 1.3, only AEAD cipher suites with
+// forward secrecy, X25519 and P-256.
+// The certificate and client CAs are loaded now and reloaded on change.
+func NewServerConfig(opts Options) (*tls.Config, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, errors.New("tls: certificate and key file are required")
+	}
+	if opts.ReloadInterval <= 0 {
+		opts.ReloadInterval = DefaultReloadInterval
+	}
+
+	cert, err := newReloader([]string{opts.CertFile, opts.KeyFile}, opts.ReloadInterval, func() (*tls.Certificate, error) {
+		c, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &c, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("tls: load certificate: %w", err)
+	}
+
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		NextProtos:       []string{"h2", "http/1.1"},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return cert.get(), nil
+		},
+	}
+
+	if opts.ClientCAFile == "" {
+		if opts.ClientAuth != tls.NoClientCert {
+			return nil, errors.New("tls: client certificate authentication needs a client CA file")
+		}
+		return cfg, nil
+	}
+	if opts.ClientAuth == tls.NoClientCert {
+		return nil, errors.New("tls: client CA file given, but client certificates are not requested")
+	}
+
+	clientCAs, err := newReloader([]string{opts.ClientCAFile}, opts.ReloadInterval, func() (*x509.CertPool, error) {
+		pem, err := os.ReadFile(opts.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", opts.ClientCAFile)
+		}
+		return pool, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("tls: load client CAs: %w", err)
+	}
+
+	cfg.ClientAuth = opts.ClientAuth
+	// Per handshake config, so a reloaded CA pool is used for new connections
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := cfg.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = clientCAs.get()
+		return c, nil
+	}
+	return cfg, nil
+}
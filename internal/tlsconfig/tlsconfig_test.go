@@ -0,0 +1,317 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns certificate and key PEM for a leaf certificate.
+func (ca *testCA) issue(t *testing.T, serial int64, subject pkix.Name, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("Failed to set mtime: %v", err)
+	}
+}
+
+// startServer serves HTTPS with cfg and returns the address.
+func startServer(t *testing.T, cfg *tls.Config) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+		}
+	})}
+	go srv.Serve(tls.NewListener(ln, cfg))
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+// serverSerial returns the serial number of the certificate the server presents.
+func serverSerial(t *testing.T, addr string, roots *x509.CertPool) int64 {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestCertificateHotReload(t *testing.T) {
+	ca := newTestCA(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	old := time.Now().Add(-time.Minute)
+	certPEM, keyPEM := ca.issue(t, 100, pkix.Name{CommonName: "server"}, x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, certPEM, old)
+	writeFile(t, keyFile, keyPEM, old)
+
+	cfg, err := NewServerConfig(Options{CertFile: certFile, KeyFile: keyFile, ReloadInterval: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("NewServerConfig() failed: %v", err)
+	}
+	addr := startServer(t, cfg)
+
+	if got := serverSerial(t, addr, roots); got != 100 {
+		t.Fatalf("Serial = %d, want 100", got)
+	}
+
+	// Broken half-written file: previous certificate stays in use
+	writeFile(t, certFile, []byte("garbage"), time.Now())
+	if got := serverSerial(t, addr, roots); got != 100 {
+		t.Errorf("Serial after broken update = %d, want 100", got)
+	}
+
+	// Renewed certificate is picked up without restart
+	certPEM, keyPEM = ca.issue(t, 200, pkix.Name{CommonName: "server"}, x509.ExtKeyUsageServerAuth)
+	now := time.Now().Add(time.Second)
+	writeFile(t, keyFile, keyPEM, now)
+	writeFile(t, certFile, certPEM, now)
+	if got := serverSerial(t, addr, roots); got != 200 {
+		t.Errorf("Serial after renewal = %d, want 200", got)
+	}
+}
+
+// Atomare Renames mit altem mtime und Kubernetes-Symlink-Wechsel auf ein
+// älteres Verzeichnis müssen ebenfalls neu geladen werden
+func TestCertificateReloadWithoutNewerMtime(t *testing.T) {
+	ca := newTestCA(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	// Layout like a mounted Kubernetes secret: cert.pem -> ..data/cert.pem, ..data -> ..v1
+	dir := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	writeVersion := func(name string, serial int64, modTime time.Time) {
+		if err := os.Mkdir(filepath.Join(dir, name), 0o700); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		certPEM, keyPEM := ca.issue(t, serial, pkix.Name{CommonName: "server"}, x509.ExtKeyUsageServerAuth)
+		writeFile(t, filepath.Join(dir, name, "cert.pem"), certPEM, modTime)
+		writeFile(t, filepath.Join(dir, name, "key.pem"), keyPEM, modTime)
+	}
+	flip := func(target string) {
+		tmp := filepath.Join(dir, "..data_tmp")
+		if err := os.Symlink(target, tmp); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+			t.Fatalf("Failed to swap symlink: %v", err)
+		}
+	}
+
+	writeVersion("..v1", 100, time.Now())
+	flip("..v1")
+	for _, name := range []string{"cert.pem", "key.pem"} {
+		if err := os.Symlink(filepath.Join("..data", name), filepath.Join(dir, name)); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+	}
+
+	cfg, err := NewServerConfig(Options{
+		CertFile:       filepath.Join(dir, "cert.pem"),
+		KeyFile:        filepath.Join(dir, "key.pem"),
+		ReloadInterval: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatalf("NewServerConfig() failed: %v", err)
+	}
+	addr := startServer(t, cfg)
+	if got := serverSerial(t, addr, roots); got != 100 {
+		t.Fatalf("Serial = %d, want 100", got)
+	}
+
+	// The new version has an older mtime than the current one
+	writeVersion("..v2", 200, old)
+	flip("..v2")
+	if got := serverSerial(t, addr, roots); got != 200 {
+		t.Errorf("Serial after symlink flip to older files = %d, want 200", got)
+	}
+}
+
+func TestClientCertificateAuth(t *testing.T) {
+	ca := newTestCA(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+	certPEM, keyPEM := ca.issue(t, 1, pkix.Name{CommonName: "server"}, x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, certPEM, time.Now())
+	writeFile(t, keyFile, keyPEM, time.Now())
+	writeFile(t, caFile, ca.pem, time.Now())
+
+	cfg, err := NewServerConfig(Options{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientAuth: tls.RequireAndVerifyClientCert})
+	if err != nil {
+		t.Fatalf("NewServerConfig() failed: %v", err)
+	}
+	addr := startServer(t, cfg)
+
+	clientPEM, clientKeyPEM := ca.issue(t, 2, pkix.Name{CommonName: "billing", Organization: []string{"Foodshop"}}, x509.ExtKeyUsageClientAuth)
+	clientCert, err := tls.X509KeyPair(clientPEM, clientKeyPEM)
+	if err != nil {
+		t.Fatalf("Failed to load client certificate: %v", err)
+	}
+
+	get := func(certs []tls.Certificate) (string, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+		resp, err := client.Get("https://" + addr)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		buf := make([]byte, 64)
+		n, _ := resp.Body.Read(buf)
+		return string(buf[:n]), nil
+	}
+
+	body, err := get([]tls.Certificate{clientCert})
+	if err != nil {
+		t.Fatalf("Request with client certificate failed: %v", err)
+	}
+	if body != "billing" {
+		t.Errorf("Server saw client %q, want billing", body)
+	}
+
+	if _, err := get(nil); err == nil {
+		t.Error("Request without client certificate succeeded, want handshake error")
+	}
+}
+
+func TestServerConfigDefaults(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	certPEM, keyPEM := ca.issue(t, 1, pkix.Name{CommonName: "server"}, x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, certPEM, time.Now())
+	writeFile(t, keyFile, keyPEM, time.Now())
+
+	cfg, err := NewServerConfig(Options{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("NewServerConfig() failed: %v", err)
+	}
+	addr := startServer(t, cfg)
+
+	// TLS 1.1 is refused
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	if conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots, MaxVersion: tls.VersionTLS11}); err == nil {
+		conn.Close()
+		t.Error("TLS 1.1 handshake succeeded, want error")
+	}
+
+	// Client auth without CA file is a configuration error
+	if _, err := NewServerConfig(Options{CertFile: certFile, KeyFile: keyFile, ClientAuth: tls.RequireAndVerifyClientCert}); err == nil {
+		t.Error("NewServerConfig() with client auth but without CA succeeded, want error")
+	}
+	// A CA file without client certificate requests would be ignored silently
+	if _, err := NewServerConfig(Options{CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile}); err == nil {
+		t.Error("NewServerConfig() with CA but client auth none succeeded, want error")
+	}
+	if _, err := NewServerConfig(Options{CertFile: filepath.Join(dir, "missing.pem"), KeyFile: keyFile}); err == nil {
+		t.Error("NewServerConfig() with missing certificate succeeded, want error")
+	}
+}
+
+func TestParseClientAuth(t *testing.T) {
+	tests := []struct {
+		value    string
+		clientCA bool
+		want     tls.ClientAuthType
+		wantErr  bool
+	}{
+		{"", false, tls.NoClientCert, false},
+		{"", true, tls.RequireAndVerifyClientCert, false},
+		{"none", false, tls.NoClientCert, false},
+		{"none", true, tls.NoClientCert, true},
+		{"request", true, tls.VerifyClientCertIfGiven, false},
+		{"Require", true, tls.RequireAndVerifyClientCert, false},
+		{"optional", true, tls.NoClientCert, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseClientAuth(tt.value, tt.clientCA)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseClientAuth(%q, %v) = %v, %v, want %v (error %v)", tt.value, tt.clientCA, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
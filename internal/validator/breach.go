@@ -0,0 +1,176 @@
+package validator
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BreachChecker looks up how often a password appears in known breaches.
+type BreachChecker interface {
+	// BreachCount returns the number of times the password was seen in
+	// breaches (0 if it is unknown).
+	BreachCount(password string) (int, error)
+}
+
+var (
+	breachChecker   BreachChecker
+	breachThreshold = 1
+)
+
+// SetBreachChecker enables the breached-password check in ValidatePassword.
+// Passwords seen at least threshold times are rejected. A nil checker
+// disables the check.
+func SetBreachChecker(checker BreachChecker, threshold int) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	if threshold < 1 {
+		threshold = 1
+	}
+	breachChecker = checker
+	breachThreshold = threshold
+}
+
+func getBreachChecker() (BreachChecker, int) {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return breachChecker, breachThreshold
+}
+
+// HIBPCorpus is an offline copy of the Have I Been Pwned password hashes.
+// No network calls are made; lookups use binary search on sorted files.
+//
+// Two layouts are supported:
+//   - a single file sorted by hash with lines "<SHA1>:<count>"
+//     (pwned-passwords-sha1-ordered-by-hash.txt)
+//   - a directory with one file per 5-character hash prefix containing
+//     range-API lines "<35-char suffix>:<count>" (e.g. "21BD1")
+type HIBPCorpus struct {
+	path  string
+	isDir bool
+	file  *os.File
+	size  int64
+}
+
+// OpenHIBPCorpus opens a local HIBP corpus file or prefix directory.
+func OpenHIBPCorpus(path string) (*HIBPCorpus, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("open breach corpus: %w", err)
+	}
+
+	if info.IsDir() {
+		return &HIBPCorpus{path: path, isDir: true}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open breach corpus: %w", err)
+	}
+	return &HIBPCorpus{path: path, file: f, size: info.Size()}, nil
+}
+
+// Close closes the corpus file.
+func (c *HIBPCorpus) Close() error {
+	if c.file == nil {
+		return nil
+	}
+	return c.file.Close()
+}
+
+// BreachCount returns how often the password appears in the corpus.
+func (c *HIBPCorpus) BreachCount(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	if !c.isDir {
+		return searchSorted(c.file, c.size, hash)
+	}
+
+	// Range layout: look up the suffix in the file of its prefix
+	f, err := os.Open(filepath.Join(c.path, hash[:5]))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("open prefix file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("stat prefix file: %w", err)
+	}
+	return searchSorted(f, info.Size(), hash[5:])
+}
+
+// searchSorted binary searches a file of sorted "<KEY>:<count>" lines.
+// Offsets are bisected and then aligned to the next line start, so the
+// file never has to be loaded into memory.
+func searchSorted(r io.ReaderAt, size int64, key string) (int, error) {
+	lo, hi := int64(0), size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		start, line, next, err := lineAt(r, size, mid)
+		if err != nil {
+			return 0, err
+		}
+		if start >= hi {
+			hi = mid
+			continue
+		}
+
+		lineKey, count, _ := strings.Cut(line, ":")
+		switch cmp := strings.Compare(strings.ToUpper(lineKey), key); {
+		case cmp < 0:
+			lo = next
+		case cmp > 0:
+			hi = mid
+		default:
+			n, err := strconv.Atoi(strings.TrimSpace(count))
+			if err != nil {
+				return 0, fmt.Errorf("invalid breach count %q", count)
+			}
+			return n, nil
+		}
+	}
+	return 0, nil
+}
+
+// lineAt returns the first line starting at or after off, together with its
+// start offset and the offset of the following line.
+func lineAt(r io.ReaderAt, size, off int64) (int64, string, int64, error) {
+	start := off
+	if off > 0 {
+		// Skip the rest of the line that contains off-1
+		br := bufio.NewReader(io.NewSectionReader(r, off-1, size-off+1))
+		skipped, err := br.ReadSlice('\n')
+		if err == io.EOF {
+			return size, "", size, nil
+		}
+		if err != nil {
+			return 0, "", 0, fmt.Errorf("read breach corpus: %w", err)
+		}
+		start = off - 1 + int64(len(skipped))
+	}
+	if start >= size {
+		return size, "", size, nil
+	}
+
+	br := bufio.NewReader(io.NewSectionReader(r, start, size-start))
+	line, err := br.ReadSlice('\n')
+	if err != nil && err != io.EOF {
+		return 0, "", 0, fmt.Errorf("read breach corpus: %w", err)
+	}
+	next := start + int64(len(line))
+	return start, string(bytes.TrimRight(line, "\r\n")), next, nil
+}
@@ -0,0 +1,113 @@
+package validator
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// writeCorpus writes a sorted single-file corpus with the given passwords
+// (count = index + 1) plus filler entries.
+func writeCorpus(t *testing.T, passwords []string) string {
+	t.Helper()
+
+	var lines []string
+	for i, p := range passwords {
+		lines = append(lines, fmt.Sprintf("%s:%d", sha1Hex(p), i+1))
+	}
+	for i := 0; i < 500; i++ {
+		lines = append(lines, fmt.Sprintf("%s:%d", sha1Hex(fmt.Sprintf("filler-%d", i)), 7))
+	}
+	sort.Strings(lines)
+
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	return path
+}
+
+func TestHIBPCorpusFile(t *testing.T) {
+	corpus, err := OpenHIBPCorpus(writeCorpus(t, []string{"Password1!", "Summer2024!"}))
+	if err != nil {
+		t.Fatalf("OpenHIBPCorpus() failed: %v", err)
+	}
+	defer corpus.Close()
+
+	tests := []struct {
+		password string
+		want     int
+	}{
+		{"Password1!", 1},
+		{"Summer2024!", 2},
+		{"filler-0", 7},
+		{"filler-499", 7},
+		{"MyP@ssw0rd!-not-breached", 0},
+	}
+
+	for _, tt := range tests {
+		got, err := corpus.BreachCount(tt.password)
+		if err != nil {
+			t.Fatalf("BreachCount(%q) failed: %v", tt.password, err)
+		}
+		if got != tt.want {
+			t.Errorf("BreachCount(%q) = %d, want %d", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestHIBPCorpusRangeDirectory(t *testing.T) {
+	dir := t.TempDir()
+	hash := sha1Hex("Password1!")
+	content := fmt.Sprintf("0000000000000000000000000000000000A:3\n%s:42\nFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF:1\n", hash[5:])
+	if err := os.WriteFile(filepath.Join(dir, hash[:5]), []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	corpus, err := OpenHIBPCorpus(dir)
+	if err != nil {
+		t.Fatalf("OpenHIBPCorpus() failed: %v", err)
+	}
+
+	if got, _ := corpus.BreachCount("Password1!"); got != 42 {
+		t.Errorf("BreachCount() = %d, want 42", got)
+	}
+	// Missing prefix file means not breached
+	if got, err := corpus.BreachCount("MyP@ssw0rd!"); got != 0 || err != nil {
+		t.Errorf("BreachCount() = %d, %v, want 0, nil", got, err)
+	}
+}
+
+func TestValidatePasswordBreached(t *testing.T) {
+	corpus, err := OpenHIBPCorpus(writeCorpus(t, []string{"Password1!", "Summer2024!"}))
+	if err != nil {
+		t.Fatalf("OpenHIBPCorpus() failed: %v", err)
+	}
+	defer corpus.Close()
+
+	SetBreachChecker(corpus, 1)
+	defer SetBreachChecker(nil, 1)
+
+	if err := ValidatePassword("Password1!"); err == nil {
+		t.Error("ValidatePassword() should reject a breached password")
+	}
+	if err := ValidatePassword("MyP@ssw0rd!"); err != nil {
+		t.Errorf("ValidatePassword() rejected an unknown password: %v", err)
+	}
+
+	// Summer2024! was seen twice: allowed with threshold 3
+	SetBreachChecker(corpus, 3)
+	if err := ValidatePassword("Summer2024!"); err != nil {
+		t.Errorf("ValidatePassword() should allow passwords below the threshold: %v", err)
+	}
+}
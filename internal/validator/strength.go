@@ -0,0 +1,515 @@
+package validator
+
+import (
+	"foodshop/internal/i18n"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// StrengthResult is the result of EstimateStrength.
+type StrengthResult struct {
+	// Score from 0 (too guessable) to 4 (very unguessable), like zxcvbn.
+	Score int
+	// Guesses is the estimated number of guesses needed to crack the password.
+	Guesses float64
+	// Warning explains the weakest part of the password (may be empty).
+	Warning string
+	// WarningKey is the catalog key of Warning, for translations.
+	WarningKey string
+	// Suggestions help the user to choose a stronger password.
+	Suggestions []string
+}
+
+// Pattern kinds found by the matchers.
+const (
+	patternDictionary = "dictionary"
+	patternUserInput  = "user_input"
+	patternKeyboard   = "keyboard"
+	patternSequence   = "sequence"
+	patternRepeat     = "repeat"
+	patternDate       = "date"
+)
+
+// match is a guessable part of the password (runes i to j, j exclusive).
+type match struct {
+	pattern string
+	i, j    int
+	guesses float64
+	leet    bool
+}
+
+// keyboardRows are adjacent key runs on QWERTY and QWERTZ layouts.
+var keyboardRows = []string{
+	"1234567890ß",
+	"qwertyuiop",
+	"qwertzuiopü",
+	"asdfghjkl",
+	"asdfghjklöä",
+	"zxcvbnm",
+	"yxcvbnm",
+	"!\"§$%&/()=",
+	"!@#$%^&*()",
+}
+
+// leetSubstitutions maps common l33t characters to letters.
+var leetSubstitutions = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g',
+	'1': 'i', '!': 'i', '|': 'l', '0': 'o', '$': 's', '5': 's',
+	'7': 't', '+': 't', '2': 'z',
+}
+
+var (
+	nonWordRegex       = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+	separatedDateRegex = regexp.MustCompile(`\d{1,4}[./\-_ ]\d{1,2}[./\-_ ]\d{1,4}`)
+	digitsRegex        = regexp.MustCompile(`\d+`)
+)
+
+const minDictionaryLength = 3
+
+// maxStrengthRunes limits the part of the password that is searched for
+// patterns. The matchers grow superlinearly with the length and run on
+// unauthenticated registration; further characters count as bruteforce,
+// and a password of this length scores 4 unless it is highly repetitive.
+const maxStrengthRunes = 64
+
+// EstimateStrength estimates how hard a password is to guess, in the
+// spirit of zxcvbn: the password is split into the cheapest sequence of
+// known patterns (dictionary words, keyboard runs, sequences, repeats,
+// dates and the user's own data) and bruteforce characters.
+// userInputs (username, email, ...) are treated as a very small dictionary.
+func EstimateStrength(password string, userInputs ...string) StrengthResult {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return StrengthResult{Score: 0, Guesses: 1, Warning: english("strength.empty"), WarningKey: "strength.empty"}
+	}
+
+	analysed := runes[:min(len(runes), maxStrengthRunes)]
+	logGuesses, sequence := cheapestDecomposition(len(analysed), findMatches(analysed, userInputs))
+	logGuesses += float64(len(runes) - len(analysed))
+	guesses := math.Pow(10, logGuesses)
+	result := StrengthResult{
+		Score:   scoreFromGuesses(guesses),
+		Guesses: guesses,
+	}
+	var suggestions []string
+	result.WarningKey, suggestions = feedback(result.Score, sequence, len(runes))
+	if result.WarningKey != "" {
+		result.Warning = english(result.WarningKey)
+	}
+	for _, key := range suggestions {
+		result.Suggestions = append(result.Suggestions, english(key))
+	}
+	return result
+}
+
+// cheapestDecomposition covers n runes with matches and bruteforce characters
+// at the lowest cost. It returns log10(guesses) and the matches used.
+func cheapestDecomposition(n int, matches []match) (float64, []*match) {
+	// Dynamic programming over prefixes: cheapest log10(guesses) to cover runes[:k]
+	best := make([]float64, n+1)
+	used := make([]*match, n+1)
+	for k := 1; k <= n; k++ {
+		// Bruteforce one more character (cardinality 10 like zxcvbn)
+		best[k] = best[k-1] + 1
+		used[k] = nil
+		for idx := range matches {
+			m := &matches[idx]
+			if m.j != k {
+				continue
+			}
+			if cost := best[m.i] + math.Log10(m.guesses); cost < best[k] {
+				best[k] = cost
+				used[k] = m
+			}
+		}
+	}
+
+	// Walk back to collect the patterns of the cheapest decomposition
+	var sequence []*match
+	for k := n; k > 0; {
+		if m := used[k]; m != nil {
+			sequence = append(sequence, m)
+			k = m.i
+		} else {
+			k--
+		}
+	}
+	return best[n], sequence
+}
+
+// scoreFromGuesses uses the zxcvbn thresholds.
+func scoreFromGuesses(guesses float64) int {
+	switch {
+	case guesses < 1e3+5:
+		return 0
+	case guesses < 1e6+5:
+		return 1
+	case guesses < 1e8+5:
+		return 2
+	case guesses < 1e10+5:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func findMatches(runes []rune, userInputs []string) []match {
+	return append(simpleMatches(runes, userInputs), repeatMatches(runes)...)
+}
+
+// simpleMatches runs all matchers except repeatMatches, which uses them to
+// score the repeated block.
+func simpleMatches(runes []rune, userInputs []string) []match {
+	var matches []match
+	matches = append(matches, dictionaryMatches(runes, userInputs)...)
+	matches = append(matches, keyboardMatches(runes)...)
+	matches = append(matches, sequenceMatches(runes)...)
+	matches = append(matches, dateMatches(runes)...)
+	return matches
+}
+
+// dictionaryMatches finds common words/passwords and the user's own data,
+// also reversed and with l33t substitutions.
+func dictionaryMatches(runes []rune, userInputs []string) []match {
+	// Only substrings up to the longest known word can match
+	userWords := make(map[string]bool)
+	maxLength := maxCommonWordLength
+	for _, input := range userInputs {
+		input = strings.ToLower(input)
+		if n := len([]rune(input)); n >= minDictionaryLength {
+			userWords[input] = true
+			maxLength = max(maxLength, n)
+		}
+		for _, word := range nonWordRegex.Split(input, -1) {
+			if len([]rune(word)) >= minDictionaryLength {
+				userWords[word] = true
+			}
+		}
+	}
+
+	lower := []rune(strings.ToLower(string(runes)))
+	unleeted := make([]rune, len(lower))
+	for i, r := range lower {
+		if sub, ok := leetSubstitutions[r]; ok {
+			unleeted[i] = sub
+		} else {
+			unleeted[i] = r
+		}
+	}
+
+	var matches []match
+	for i := 0; i < len(runes); i++ {
+		for j := i + minDictionaryLength; j <= min(len(runes), i+maxLength); j++ {
+			original := runes[i:j]
+			for _, candidate := range []struct {
+				word       string
+				multiplier float64
+				leet       bool
+			}{
+				{string(lower[i:j]), 1, false},
+				{string(unleeted[i:j]), 2, true},
+				{reverse(string(lower[i:j])), 2, false},
+			} {
+				var pattern string
+				var rank float64
+				if userWords[candidate.word] {
+					pattern, rank = patternUserInput, 1
+				} else if r, ok := commonWords[candidate.word]; ok {
+					pattern, rank = patternDictionary, float64(r)
+				} else {
+					continue
+				}
+				matches = append(matches, match{
+					pattern: pattern,
+					i:       i,
+					j:       j,
+					guesses: rank * candidate.multiplier * uppercaseVariations(original),
+					leet:    candidate.leet,
+				})
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// uppercaseVariations estimates the extra guesses caused by capitalisation.
+func uppercaseVariations(word []rune) float64 {
+	upper, lower := 0, 0
+	for _, r := range word {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+	// First letter, last letter or all caps are the common variants
+	if lower == 0 || (upper == 1 && (unicode.IsUpper(word[0]) || unicode.IsUpper(word[len(word)-1]))) {
+		return 2
+	}
+	variations := 0.0
+	for k := 1; k <= min(upper, lower); k++ {
+		variations += binomial(upper+lower, k)
+	}
+	return math.Max(variations, 2)
+}
+
+// keyboardMatches finds runs of at least 3 adjacent keys.
+func keyboardMatches(runes []rune) []match {
+	lower := strings.ToLower(string(runes))
+	lr := []rune(lower)
+
+	var matches []match
+	for i := 0; i < len(lr); i++ {
+		longest := 0
+		for _, row := range keyboardRows {
+			for _, r := range []string{row, reverse(row)} {
+				for j := i + 3; j <= len(lr); j++ {
+					if !strings.Contains(r, string(lr[i:j])) {
+						break
+					}
+					longest = max(longest, j-i)
+				}
+			}
+		}
+		for length := 3; length <= longest; length++ {
+			// Starting key * direction * length, similar to zxcvbn's spatial estimate
+			matches = append(matches, match{pattern: patternKeyboard, i: i, j: i + length, guesses: 40 * 2 * float64(length)})
+		}
+	}
+	return matches
+}
+
+// sequenceMatches finds runs like "abc", "cba", "1234" or "9876".
+func sequenceMatches(runes []rune) []match {
+	var matches []match
+	for i := 0; i+2 < len(runes); {
+		delta := runes[i+1] - runes[i]
+		if delta != 1 && delta != -1 {
+			i++
+			continue
+		}
+		j := i + 2
+		for j < len(runes) && runes[j]-runes[j-1] == delta {
+			j++
+		}
+		if j-i >= 3 {
+			base := 26.0
+			if unicode.IsDigit(runes[i]) {
+				base = 10
+			}
+			if delta < 0 {
+				base *= 2
+			}
+			matches = append(matches, match{pattern: patternSequence, i: i, j: j, guesses: base * float64(j-i)})
+		}
+		i = j - 1
+	}
+	return matches
+}
+
+// repeatMatches finds repeated characters ("aaa") and repeated blocks ("abcabc").
+func repeatMatches(runes []rune) []match {
+	var matches []match
+	n := len(runes)
+	// A block is scored once, without looking for repeats inside it: the
+	// recursion would grow super-exponentially with the password length
+	blockGuesses := make(map[string]float64)
+	for i := 0; i < n; i++ {
+		for block := 1; i+2*block <= n; block++ {
+			count := 1
+			for i+(count+1)*block <= n && string(runes[i+count*block:i+(count+1)*block]) == string(runes[i:i+block]) {
+				count++
+			}
+			if count < 2 || (block == 1 && count < 3) {
+				continue
+			}
+			// Guessing the block once is enough, repetitions are almost free
+			key := string(runes[i : i+block])
+			guesses, ok := blockGuesses[key]
+			if !ok {
+				logGuesses, _ := cheapestDecomposition(block, simpleMatches(runes[i:i+block], nil))
+				guesses = math.Pow(10, logGuesses)
+				blockGuesses[key] = guesses
+			}
+			matches = append(matches, match{
+				pattern: patternRepeat,
+				i:       i,
+				j:       i + count*block,
+				guesses: guesses * float64(count),
+			})
+		}
+	}
+	return matches
+}
+
+// dateMatches finds years (1900-2099) and dates with or without separators.
+func dateMatches(runes []rune) []match {
+	var matches []match
+	s := string(runes)
+
+	// Separated dates like 12.05.1990 or 1990-05-12
+	for _, loc := range separatedDateRegex.FindAllStringIndex(s, -1) {
+		parts := digitsRegex.FindAllString(s[loc[0]:loc[1]], -1)
+		if len(parts) == 3 && isDate(parts...) {
+			i := len([]rune(s[:loc[0]]))
+			j := len([]rune(s[:loc[1]]))
+			matches = append(matches, match{pattern: patternDate, i: i, j: j, guesses: 365 * yearSpace(parts) * 4})
+		}
+	}
+
+	// Digit runs: years, ddmm, ddmmyy, ddmmyyyy, yyyymmdd
+	for i := 0; i < len(runes); i++ {
+		for _, length := range []int{4, 6, 8} {
+			j := i + length
+			if j > len(runes) || !allDigits(runes[i:j]) {
+				continue
+			}
+			d := string(runes[i:j])
+			var guesses float64
+			switch {
+			case length == 4 && isYear(d):
+				guesses = yearSpace([]string{d})
+			case length == 4 && isDate(d[:2], d[2:]):
+				guesses = 365
+			case length == 6 && isDate(d[:2], d[2:4], d[4:]):
+				guesses = 365 * 100
+			case length == 8 && (isDate(d[:2], d[2:4], d[4:]) || isDate(d[:4], d[4:6], d[6:])):
+				guesses = 365 * yearSpace([]string{d[:4], d[4:]})
+			default:
+				continue
+			}
+			matches = append(matches, match{pattern: patternDate, i: i, j: j, guesses: guesses})
+		}
+	}
+	return matches
+}
+
+// isDate reports whether parts form a plausible date: day and month in
+// either order, optionally with a 2- or 4-digit year first or last.
+func isDate(parts ...string) bool {
+	switch len(parts) {
+	case 2:
+	case 3:
+		switch {
+		case isYear(parts[0]):
+			parts = parts[1:]
+		case isYear(parts[2]) || len(parts[2]) == 2:
+			parts = parts[:2]
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+
+	a, err1 := strconv.Atoi(parts[0])
+	b, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	validDM := func(d, m int) bool { return d >= 1 && d <= 31 && m >= 1 && m <= 12 }
+	return validDM(a, b) || validDM(b, a)
+}
+
+func isYear(s string) bool {
+	if len(s) != 4 {
+		return false
+	}
+	year, err := strconv.Atoi(s)
+	return err == nil && year >= 1900 && year <= 2099
+}
+
+// yearSpace is the number of plausible years, based on the distance to the current year.
+func yearSpace(parts []string) float64 {
+	for _, p := range parts {
+		if isYear(p) {
+			year, _ := strconv.Atoi(p)
+			return math.Max(math.Abs(float64(year-time.Now().Year())), 20)
+		}
+	}
+	return 100
+}
+
+// feedback returns the catalog keys of the warning and the suggestions.
+func feedback(score int, sequence []*match, length int) (string, []string) {
+	if score >= 3 {
+		return "", nil
+	}
+
+	suggestions := []string{"strength.suggestion.passphrase"}
+
+	// Warn about the pattern covering the largest part of the password
+	var worst *match
+	for _, m := range sequence {
+		if worst == nil || m.j-m.i > worst.j-worst.i {
+			worst = m
+		}
+	}
+	if worst == nil {
+		if length < 12 {
+			return "strength.too_short", suggestions
+		}
+		return "", suggestions
+	}
+
+	switch worst.pattern {
+	case patternUserInput:
+		return "strength.user_input", suggestions
+	case patternDictionary:
+		if worst.j-worst.i == length {
+			return "strength.common_password", suggestions
+		}
+		if worst.leet {
+			suggestions = append(suggestions, "strength.suggestion.leet")
+		}
+		return "strength.common_words", suggestions
+	case patternKeyboard:
+		return "strength.keyboard", append(suggestions, "strength.suggestion.keyboard")
+	case patternSequence:
+		return "strength.sequence", append(suggestions, "strength.suggestion.sequence")
+	case patternRepeat:
+		return "strength.repeat", append(suggestions, "strength.suggestion.repeat")
+	case patternDate:
+		return "strength.date", append(suggestions, "strength.suggestion.date")
+	}
+	return "", suggestions
+}
+
+// english returns the English catalog message for key.
+func english(key string) string {
+	return i18n.Text(i18n.English, key, nil)
+}
+
+func allDigits(runes []rune) bool {
+	for _, r := range runes {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+func binomial(n, k int) float64 {
+	result := 1.0
+	for i := 1; i <= k; i++ {
+		result = result * float64(n-k+i) / float64(i)
+	}
+	return result
+}
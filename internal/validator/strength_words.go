@@ -0,0 +1,52 @@
+package validator
+
+import "strings"
+
+// commonWordList contains frequently used passwords and words (English and
+// German), ordered by frequency. The rank is used as number of guesses.
+const commonWordList = `
+password passwort 123456 qwerty qwertz 12345678 111111 abc123 letmein
+welcome willkommen monkey dragon master login admin hallo hello iloveyou
+sunshine princess football fussball baseball shadow superman batman michael
+secret geheim sommer summer winter herbst autumn fruehling spring liebe love
+schatz hase maus baby lucky freedom whatever trustno1 starwars pokemon
+computer internet killer charlie jordan jennifer thomas daniel andreas
+stefan michael alexander sabine nicole julia anna lisa schalke bayern
+borussia dortmund hamburg berlin muenchen deutschland germany europa
+test test123 pass access flower blume sonne sunny mond stern himmel
+hund katze tiger pferd apple banana orange cookie chocolate schokolade
+passw0rd changeme default guest user benutzer kennwort zugang sicher
+security shop food foodshop essen pizza burger kaffee coffee bier beer
+family familie mother mutter father vater sister brother bruder friend
+freund freundin hallo123 gott jesus angel engel heaven hoelle devil
+teufel music musik rock metal guitar gitarre player gamer soccer hockey
+tennis golf money geld gold silver silber diamond king queen koenig
+prinzessin ninja pirate hunter jaeger warrior krieger magic zauber
+matrix hacker office buero work arbeit company firma system server
+database windows linux google facebook instagram twitter amazon
+mercedes porsche audi ferrari bmw volkswagen golf corvette mustang
+january february march april may june july august september october
+november december januar februar maerz juni juli oktober dezember
+monday tuesday friday saturday sunday montag dienstag freitag samstag
+sonntag red blue green black white yellow purple rot blau gruen schwarz
+weiss gelb correct horse battery staple
+`
+
+var commonWords = func() map[string]int {
+	words := make(map[string]int)
+	for rank, word := range strings.Fields(commonWordList) {
+		if _, exists := words[word]; !exists {
+			words[word] = rank + 1
+		}
+	}
+	return words
+}()
+
+// maxCommonWordLength bounds the substrings dictionaryMatches looks up.
+var maxCommonWordLength = func() int {
+	longest := 0
+	for word := range commonWords {
+		longest = max(longest, len([]rune(word)))
+	}
+	return longest
+}()
@@ -1,8 +1,12 @@
 package validator
 
 import (
+	"fmt"
+	"foodshop/internal/i18n"
+	"log/slog"
 	"regexp"
 	"strings"
+	"sync"
 	"unicode"
 )
 
@@ -13,14 +17,36 @@ var (
 	emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`)
 )
 
+// Length limits of the input fields.
+const (
+	MinUsernameLength = 3
+	MaxUsernameLength = 50
+	MinPasswordLength = 8
+	MaxPasswordLength = 128
+	MaxEmailLength    = 254
+)
+
 // ValidationError represents a validation error.
 type ValidationError struct {
-	Field   string
-	Message string
+	Field string
+	// Code identifies the violated rule for clients, e.g. "too_short".
+	Code string
+	// Params fill the placeholders of the message, e.g. {"min": 8}.
+	Params i18n.Args
+}
+
+func newError(field, code string, params i18n.Args) ValidationError {
+	return ValidationError{Field: field, Code: code, Params: params}
 }
 
+// Message returns the catalog message of the error ("validation.<field>.<code>").
+func (e ValidationError) Message() i18n.Message {
+	return i18n.Message{Key: "validation." + e.Field + "." + e.Code, Args: e.Params}
+}
+
+// Error returns the English message.
 func (e ValidationError) Error() string {
-	return e.Message
+	return e.Message().Text(i18n.English)
 }
 
 // ValidateUsername validates username according to security best practices.
@@ -28,19 +54,19 @@ func ValidateUsername(username string) error {
 	username = strings.TrimSpace(username)
 
 	if username == "" {
-		return ValidationError{Field: "username", Message: "Username is required"}
+		return newError("username", "required", nil)
 	}
 
-	if len(username) < 3 {
-		return ValidationError{Field: "username", Message: "Username must be at least 3 characters long"}
+	if len(username) < MinUsernameLength {
+		return newError("username", "too_short", i18n.Args{"min": MinUsernameLength})
 	}
 
-	if len(username) > 50 {
-		return ValidationError{Field: "username", Message: "Username must not exceed 50 characters"}
+	if len(username) > MaxUsernameLength {
+		return newError("username", "too_long", i18n.Args{"max": MaxUsernameLength})
 	}
 
 	if !usernameRegex.MatchString(username) {
-		return ValidationError{Field: "username", Message: "Username can only contain letters, numbers, and underscores"}
+		return newError("username", "invalid_characters", nil)
 	}
 
 	// Prevent usernames that could be confused with system names
@@ -48,27 +74,110 @@ func ValidateUsername(username string) error {
 	lowerUsername := strings.ToLower(username)
 	for _, r := range reserved {
 		if lowerUsername == r {
-			return ValidationError{Field: "username", Message: "Username is reserved"}
+			return newError("username", "reserved", nil)
 		}
 	}
 
 	return nil
 }
 
-// ValidatePassword validates password strength.
-func ValidatePassword(password string) error {
+// PasswordPolicy selects which rules ValidatePassword enforces.
+type PasswordPolicy int
+
+const (
+	// PolicyComposition requires upper, lower, digit and special characters (default).
+	PolicyComposition PasswordPolicy = iota
+	// PolicyStrength requires a minimum EstimateStrength score.
+	PolicyStrength
+	// PolicyBoth requires the composition rules and the minimum score.
+	PolicyBoth
+)
+
+var (
+	// configMu guards the password policy and breach checker settings.
+	configMu         sync.RWMutex
+	passwordPolicy   = PolicyComposition
+	passwordMinScore = 3
+)
+
+// SetPasswordPolicy configures ValidatePassword. minScore (0-4) is only
+// used by PolicyStrength and PolicyBoth.
+func SetPasswordPolicy(policy PasswordPolicy, minScore int) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	passwordPolicy = policy
+	passwordMinScore = min(max(minScore, 0), 4)
+}
+
+// ParsePasswordPolicy parses "composition", "strength" or "both".
+func ParsePasswordPolicy(s string) (PasswordPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "composition":
+		return PolicyComposition, nil
+	case "strength":
+		return PolicyStrength, nil
+	case "both":
+		return PolicyBoth, nil
+	}
+	return PolicyComposition, fmt.Errorf("unknown password policy %q", s)
+}
+
+func getPasswordPolicy() (PasswordPolicy, int) {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return passwordPolicy, passwordMinScore
+}
+
+// ValidatePassword validates password strength according to the configured
+// policy. userInputs (username, email, ...) are penalised by the strength
+// estimator, so a password derived from them is rejected.
+func ValidatePassword(password string, userInputs ...string) error {
 	if password == "" {
-		return ValidationError{Field: "password", Message: "Password is required"}
+		return newError("password", "required", nil)
+	}
+
+	if len(password) < MinPasswordLength {
+		return newError("password", "too_short", i18n.Args{"min": MinPasswordLength})
+	}
+
+	if len(password) > MaxPasswordLength {
+		return newError("password", "too_long", i18n.Args{"max": MaxPasswordLength})
+	}
+
+	policy, minScore := getPasswordPolicy()
+
+	if policy == PolicyComposition || policy == PolicyBoth {
+		if err := validateComposition(password); err != nil {
+			return err
+		}
 	}
 
-	if len(password) < 8 {
-		return ValidationError{Field: "password", Message: "Password must be at least 8 characters long"}
+	if policy == PolicyStrength || policy == PolicyBoth {
+		if result := EstimateStrength(password, userInputs...); result.Score < minScore {
+			warning := result.WarningKey
+			if warning == "" {
+				warning = "strength.suggestion.passphrase"
+			}
+			return newError("password", "too_weak", i18n.Args{"warning": i18n.Key(warning)})
+		}
 	}
 
-	if len(password) > 128 {
-		return ValidationError{Field: "password", Message: "Password must not exceed 128 characters"}
+	// Reject passwords from known breaches (if a corpus is configured)
+	if checker, threshold := getBreachChecker(); checker != nil {
+		count, err := checker.BreachCount(password)
+		if err != nil {
+			// Fail open: a broken corpus must not block all registrations
+			slog.Warn("Breached password lookup failed", "error", err)
+		} else if count >= threshold {
+			return newError("password", "breached", nil)
+		}
 	}
 
+	return nil
+}
+
+// validateComposition checks the character class rules.
+func validateComposition(password string) error {
 	// Check for at least one uppercase letter
 	hasUpper := false
 	// Check for at least one lowercase letter
@@ -92,19 +201,19 @@ func ValidatePassword(password string) error {
 	}
 
 	if !hasUpper {
-		return ValidationError{Field: "password", Message: "Password must contain at least one uppercase letter"}
+		return newError("password", "missing_uppercase", nil)
 	}
 
 	if !hasLower {
-		return ValidationError{Field: "password", Message: "Password must contain at least one lowercase letter"}
+		return newError("password", "missing_lowercase", nil)
 	}
 
 	if !hasDigit {
-		return ValidationError{Field: "password", Message: "Password must contain at least one digit"}
+		return newError("password", "missing_digit", nil)
 	}
 
 	if !hasSpecial {
-		return ValidationError{Field: "password", Message: "Password must contain at least one special character"}
+		return newError("password", "missing_special", nil)
 	}
 
 	return nil
@@ -118,12 +227,12 @@ func ValidateEmail(email string) error {
 
 	email = strings.TrimSpace(email)
 
-	if len(email) > 254 {
-		return ValidationError{Field: "email", Message: "Email address is too long"}
+	if len(email) > MaxEmailLength {
+		return newError("email", "too_long", i18n.Args{"max": MaxEmailLength})
 	}
 
 	if !emailRegex.MatchString(email) {
-		return ValidationError{Field: "email", Message: "Invalid email format"}
+		return newError("email", "invalid_format", nil)
 	}
 
 	return nil
@@ -1,7 +1,9 @@
 package validator
 
 import (
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestValidateUsername(t *testing.T) {
@@ -108,3 +110,119 @@ func TestSanitizeInput(t *testing.T) {
 		})
 	}
 }
+
+func TestEstimateStrength(t *testing.T) {
+	tests := []struct {
+		name       string
+		password   string
+		userInputs []string
+		maxScore   int
+		minScore   int
+	}{
+		{"Common password", "Password1!", nil, 0, 0},
+		{"Keyboard pattern", "qwertzuiop", nil, 1, 0},
+		{"Sequence", "abcdefgh", nil, 1, 0},
+		{"Repeat", "aaaaaaaaaa", nil, 1, 0},
+		{"Repeated block", "abcabcabcabc", nil, 1, 0},
+		{"Date", "12.05.1990", nil, 2, 0},
+		{"Username with year", "johndoe1990", []string{"johndoe", "john@example.com"}, 1, 0},
+		{"Passphrase without digit", "mauer-kerze-gabel-wolke", nil, 4, 4},
+		{"Random", "zuG7#kQ9!vLp", nil, 4, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := EstimateStrength(tt.password, tt.userInputs...)
+			if result.Score < tt.minScore || result.Score > tt.maxScore {
+				t.Errorf("EstimateStrength(%q) score = %d, want %d..%d", tt.password, result.Score, tt.minScore, tt.maxScore)
+			}
+			if result.Score < 3 && result.Warning == "" && len(result.Suggestions) == 0 {
+				t.Errorf("EstimateStrength(%q) should give feedback for a weak password", tt.password)
+			}
+		})
+	}
+}
+
+func TestValidatePasswordPolicy(t *testing.T) {
+	defer SetPasswordPolicy(PolicyComposition, 3)
+
+	tests := []struct {
+		name       string
+		policy     PasswordPolicy
+		password   string
+		userInputs []string
+		wantError  bool
+	}{
+		{"Composition accepts patterned password", PolicyComposition, "Password1!", nil, false},
+		{"Composition rejects passphrase", PolicyComposition, "mauer-kerze-gabel-wolke", nil, true},
+		{"Strength rejects patterned password", PolicyStrength, "Password1!", nil, true},
+		{"Strength accepts passphrase", PolicyStrength, "mauer-kerze-gabel-wolke", nil, false},
+		{"Strength rejects username", PolicyStrength, "johndoe1990", []string{"johndoe"}, true},
+		{"Both rejects passphrase without digit", PolicyBoth, "mauer-kerze-gabel-wolke", nil, true},
+		{"Both accepts strong password", PolicyBoth, "zuG7#kQ9!vLp", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetPasswordPolicy(tt.policy, 3)
+			err := ValidatePassword(tt.password, tt.userInputs...)
+			if (err != nil) != tt.wantError {
+				t.Errorf("ValidatePassword(%q) error = %v, wantError %v", tt.password, err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestParsePasswordPolicy(t *testing.T) {
+	for input, want := range map[string]PasswordPolicy{
+		"":            PolicyComposition,
+		"composition": PolicyComposition,
+		"Strength":    PolicyStrength,
+		"both":        PolicyBoth,
+	} {
+		got, err := ParsePasswordPolicy(input)
+		if err != nil || got != want {
+			t.Errorf("ParsePasswordPolicy(%q) = %v, %v, want %v", input, got, err, want)
+		}
+	}
+
+	if _, err := ParsePasswordPolicy("none"); err == nil {
+		t.Error("ParsePasswordPolicy() should reject unknown policies")
+	}
+}
+
+// TestEstimateStrengthRepetitiveRuntime guards against the repeat matcher
+// growing super-exponentially with the password length (unauthenticated
+// registration reaches it with up to MaxPasswordLength characters).
+func TestEstimateStrengthRepetitiveRuntime(t *testing.T) {
+	const limit = 250 * time.Millisecond
+	for _, password := range []string{
+		strings.Repeat("a", MaxPasswordLength),
+		strings.Repeat("abc1", MaxPasswordLength/4),
+		strings.Repeat("Passwort1!", MaxPasswordLength/10),
+		strings.Repeat("ab", MaxPasswordLength/4) + strings.Repeat("xyz", MaxPasswordLength/6),
+	} {
+		start := time.Now()
+		EstimateStrength(password)
+		if elapsed := time.Since(start); elapsed > limit {
+			t.Errorf("EstimateStrength(%d chars of %q) took %v, want < %v", len(password), password[:4], elapsed, limit)
+		}
+	}
+}
+
+// BenchmarkEstimateStrengthWorstCase measures the slowest inputs found for
+// the matchers: maximum length, one repeated character or short block.
+func BenchmarkEstimateStrengthWorstCase(b *testing.B) {
+	for _, password := range []string{
+		strings.Repeat("a", MaxPasswordLength),
+		strings.Repeat("1", MaxPasswordLength),
+		strings.Repeat("ab", MaxPasswordLength/2),
+		strings.Repeat("qwertyui", MaxPasswordLength/8),
+	} {
+		b.Run(password[:2], func(b *testing.B) {
+			for b.Loop() {
+				EstimateStrength(password, "user", "user@example.com")
+			}
+		})
+	}
+}